@@ -0,0 +1,157 @@
+//go:build windows
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"polytube/replay/internal/buffer"
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+	"polytube/replay/internal/manifest"
+	"polytube/replay/internal/uploader"
+)
+
+// bufferTag is added to the tags of every session saved from a replay buffer.
+const bufferTag = "replay-buffer"
+
+// replayBuffer saves the replay buffer of --buffer into sessions of their own
+// and uploads them in the background while the recording goes on.
+type replayBuffer struct {
+	cfg         *cliConfig
+	watcher     *buffer.Watcher
+	manifest    *manifest.Manifest // of the buffer; dates the saved sessions
+	sessionInfo info.SessionInfo
+	bandwidth   *uploader.BandwidthLimiter
+	logger      logger.LoggerInterface
+
+	saving     sync.Mutex         // one save at a time
+	gameExit   *uploader.GameExit // guarded by saving
+	deliveries sync.WaitGroup
+}
+
+// bufferTriggers parses --buffer-triggers.
+func bufferTriggers(s string) []string {
+	var triggers []string
+	for _, t := range info.ParseTags(s) {
+		triggers = append(triggers, strings.ToUpper(t))
+	}
+	return triggers
+}
+
+// run saves the buffer whenever the video covering a trigger is on disk,
+// until ctx is canceled.
+func (b *replayBuffer) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if triggers := b.watcher.Ready(false); triggers != nil {
+				b.save(strings.Join(buffer.TriggerNames(triggers), ","))
+			}
+		}
+	}
+}
+
+// setGameExit records how the game ended, for the sessions saved from now on.
+func (b *replayBuffer) setGameExit(e uploader.GameExit) {
+	b.saving.Lock()
+	defer b.saving.Unlock()
+	b.gameExit = &e
+}
+
+// finish saves the buffer once more if triggers arrived just before the
+// recording stopped, or for the stop itself with --buffer-save-on-exit, and
+// waits until every saved session is uploaded. The event logger must be closed.
+func (b *replayBuffer) finish() {
+	b.saving.Lock() // also waits for a save run started before it stopped
+	if triggers := b.watcher.Ready(true); triggers != nil {
+		b.saveLocked(strings.Join(buffer.TriggerNames(triggers), ","))
+	} else if b.cfg.BufferOnExit {
+		b.saveLocked("exit")
+	}
+	b.saving.Unlock()
+	b.deliveries.Wait()
+}
+
+// save copies the buffer into a new session directory next to it and starts
+// uploading it. A session that can't be uploaded stays spooled like any other.
+func (b *replayBuffer) save(reason string) {
+	b.saving.Lock()
+	defer b.saving.Unlock()
+	b.saveLocked(reason)
+}
+
+// saveLocked is save with b.saving held.
+func (b *replayBuffer) saveLocked(reason string) {
+	sessionID := uuid.New().String()
+	dest := filepath.Join(filepath.Dir(b.watcher.Dir), sessionID)
+	saved, err := buffer.Save(buffer.SaveOptions{Dir: b.watcher.Dir, Dest: dest, Segment: b.watcher.Segment})
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("save replay buffer (%s) failed: %v", reason, err))
+		_ = os.RemoveAll(dest)
+		return
+	}
+
+	sessionInfo := b.sessionInfo
+	sessionInfo.Tags = append(slices.Clone(sessionInfo.Tags), bufferTag)
+	sessionInfo.Metadata = maps.Clone(sessionInfo.Metadata)
+	if sessionInfo.Metadata == nil {
+		sessionInfo.Metadata = make(map[string]any)
+	}
+	sessionInfo.Metadata["buffer_trigger"] = reason
+	sessionInfo.Metadata["buffer_session_id"] = b.cfg.SessionID
+
+	journal, err := uploader.CreateJournal(dest, sessionID, sessionInfo)
+	if err != nil {
+		b.logger.Error(fmt.Sprintf("create upload journal of saved replay buffer failed: %v", err))
+		_ = os.RemoveAll(dest)
+		return
+	}
+	if b.gameExit != nil {
+		if err := journal.SetGameExit(*b.gameExit); err != nil {
+			b.logger.Warn(fmt.Sprintf("record game exit in journal failed: %v", err))
+		}
+	}
+	if man, err := manifest.New(dest, sessionID, sessionInfo, b.cfg.redacted()); err != nil {
+		b.logger.Warn(fmt.Sprintf("create manifest of saved replay buffer failed: %v", err))
+	} else {
+		if start := b.manifest.Started(); start != nil {
+			man.MarkRecorded(start.Add(saved.Start), saved.Duration)
+		}
+		if b.gameExit != nil {
+			man.SetGameExit(*b.gameExit)
+		}
+		saveManifest(man, journal, b.logger)
+	}
+	b.logger.Info(fmt.Sprintf("Replay buffer saved (%s) as session %s: %d segment(s), %s from %s, %d event(s)",
+		reason, sessionID, saved.Segments, saved.Duration.Round(time.Second), formatSessionTime(saved.Start), saved.Events))
+	fmt.Printf("Replay buffer saved as session %s (%s)\n", sessionID, reason)
+
+	b.deliveries.Add(1)
+	go func() {
+		defer b.deliveries.Done()
+		done, err := deliverSession(b.cfg, dest, journal, b.bandwidth, nil)
+		switch {
+		case err != nil:
+			b.logger.Error(fmt.Sprintf("upload saved replay buffer %s failed: %v", sessionID, err))
+		case !done:
+			b.logger.Warn(fmt.Sprintf("Saved replay buffer %s not fully uploaded; spooled in %s", sessionID, dest))
+		default:
+			b.logger.Info(fmt.Sprintf("Saved replay buffer %s uploaded", sessionID))
+		}
+	}()
+}
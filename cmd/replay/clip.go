@@ -0,0 +1,77 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"polytube/replay/internal/export"
+	"polytube/replay/internal/info"
+	"polytube/replay/pkg/models"
+)
+
+// runClip implements `polytube.exe clip`.
+func runClip(args []string) int {
+	cfg := &cliConfig{}
+	ex := &exportFlags{}
+	var before, after time.Duration
+	var exact bool
+	var types string
+	fs := flag.NewFlagSet("clip", flag.ContinueOnError)
+	registerExportFlags(fs, cfg, ex)
+	fs.DurationVar(&before, "before", 30*time.Second, "Video to keep before each marker.")
+	fs.DurationVar(&after, "after", 10*time.Second, "Video to keep after each marker.")
+	fs.BoolVar(&exact, "exact", false, "Re-encode the clips so they start on the exact frame. Slower; without it clips are copied from the nearest keyframe.")
+	fs.StringVar(&types, "event-type", models.EventTypeMarker.String(), "Comma-separated event types to cut around (e.g. MARKER,GAME_CRASHED).")
+	setCommandUsage(fs, "clip [flags] <session dir>")
+	dirs, code := parseExportFlags(fs, cfg, "clip", args)
+	if dirs == nil {
+		return code
+	}
+	if before < 0 || after < 0 || before+after <= 0 {
+		fmt.Fprintf(os.Stderr, "--before and --after must not be negative and not both zero (got %s, %s)\n", before, after)
+		return 2
+	}
+
+	exit := 0
+	for _, dir := range dirs {
+		sessionID, _ := describeSession(dir)
+		opts := export.ClipOptions{
+			SessionDir: dir,
+			Dest:       filepath.Join(ex.dest, sessionID+"-clips"),
+			Before:     before,
+			After:      after,
+			Exact:      exact,
+			Offset:     ex.offset,
+			Types:      info.ParseTags(types),
+		}
+		var err error
+		if opts.FFmpegPath, err = resolveFFmpeg(ex.ffmpeg, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			exit = 1
+			continue
+		}
+		fmt.Printf("Cutting %s ...\n", dir)
+		clips, err := export.CutClips(opts)
+		for _, c := range clips {
+			note := c.Marker.Content
+			if note == "" {
+				note = c.Marker.EventType
+			}
+			fmt.Printf("Wrote %s (%s-%s, %s)\n", c.Video, formatSessionTime(c.Start), formatSessionTime(c.End), oneLine(note))
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			exit = 1
+			continue
+		}
+		if len(clips) == 0 {
+			fmt.Printf("No %s events in %s\n", types, dir)
+		}
+	}
+	return exit
+}
@@ -0,0 +1,314 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+	"polytube/replay/internal/recorder"
+	"polytube/replay/internal/uploader"
+)
+
+// command is a subcommand of polytube.exe.
+type command struct {
+	name    string
+	summary string
+	run     func(args []string) int
+}
+
+// commands lists the subcommands in the order shown by help. It is filled in
+// init because help refers back to it.
+var commands []command
+
+func init() {
+	commands = []command{
+		{"record", "Record a window and upload the session (default when no command is given)", runRecord},
+		{"load", "Extract the bundled binaries (ffmpeg) into --out and exit", runLoad},
+		{"upload", "Upload a previously recorded folder", runUpload},
+		{"upload-pending", "Upload sessions spooled while the storage was unreachable", runUploadPending},
+		{"inspect", "Show the recorded sessions in a folder, or their events and a summary", runInspect},
+		{"export", "Export a recorded session for offline viewing (html, overlay)", runExport},
+		{"clip", "Cut the video and events around every marker into short MP4 clips", runClip},
+		{"doctor", "Check FFmpeg, the output folder, the configuration and the storage", runDoctor},
+		{"help", "Show help for a command", runHelp},
+	}
+}
+
+func findCommand(name string) (command, bool) {
+	for _, c := range commands {
+		if c.name == name {
+			return c, true
+		}
+	}
+	return command{}, false
+}
+
+func printUsage() {
+	out := os.Stderr
+	fmt.Fprintln(out, "Usage: polytube.exe <command> [flags]")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Commands:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, c := range commands {
+		fmt.Fprintf(w, "  %s\t%s\n", c.name, c.summary)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Every flag can also be set in a config file (--config or "+configEnvVar+")")
+	fmt.Fprintln(out, "or as an environment variable "+envPrefix+"<FLAG_NAME>, e.g. "+envName("api-key")+".")
+	fmt.Fprintln(out, "Run `polytube.exe help <command>` for the flags of a command.")
+}
+
+// runHelp prints the command list, or the flags of one command.
+func runHelp(args []string) int {
+	if len(args) == 0 {
+		printUsage()
+		return 0
+	}
+	c, ok := findCommand(args[0])
+	if !ok || c.name == "help" {
+		printUsage()
+		return 2
+	}
+	return c.run([]string{"-h"})
+}
+
+// setCommandUsage sets the usage text of a command's flag set.
+func setCommandUsage(fs *flag.FlagSet, synopsis string) {
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: polytube.exe %s\n\nFlags:\n", synopsis)
+		fs.PrintDefaults()
+	}
+}
+
+// flagErrorCode maps a parse error to an exit code: 0 for -h, 2 otherwise.
+func flagErrorCode(err error) int {
+	if errors.Is(err, flag.ErrHelp) {
+		return 0
+	}
+	return 2
+}
+
+// runLoad implements `polytube.exe load`.
+func runLoad(args []string) int {
+	cfg := &cliConfig{}
+	fs := flag.NewFlagSet("load", flag.ContinueOnError)
+	registerConfigFlag(fs, cfg)
+	fs.StringVar(&cfg.OutPath, "out", "", "Directory where ffmpeg.exe is extracted (the --out used for recording).")
+	setCommandUsage(fs, "load --out <dir>")
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return flagErrorCode(err)
+	}
+	if err := cfg.validate("load"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return loadBinaries(cfg.OutPath)
+}
+
+// loadBinaries extracts ffmpeg.exe into outPath.
+func loadBinaries(outPath string) int {
+	if err := ensureDir(outPath); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create out directory: %v\n", err)
+		return 1
+	}
+	if err := recorder.LoadFFmpeg(filepath.Join(outPath, "ffmpeg.exe")); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to load FFmpeg: %v\n", err)
+		return 1
+	}
+	fmt.Println("Loading complete.")
+	return 0
+}
+
+// runInspect implements `polytube.exe inspect`: it lists the sessions in an
+// --out directory (or a single session directory) with their upload status,
+// or, with any of the event flags or an event file, reads their events.
+func runInspect(args []string) int {
+	cfg := &cliConfig{}
+	ev := &inspectEventFlags{}
+	fs := flag.NewFlagSet("inspect", flag.ContinueOnError)
+	registerConfigFlag(fs, cfg)
+	fs.StringVar(&cfg.OutPath, "out", "", "Recorded directory (an --out directory or a single session directory) or an event .parquet file.")
+	fs.BoolVar(&ev.events, "events", false, "Print the events of each session instead of the upload status.")
+	fs.BoolVar(&ev.summary, "summary", false, "Print a summary of each session's events: duration, keys per minute, most-pressed buttons, console errors.")
+	fs.StringVar(&ev.types, "event-type", "", "Only events of these comma-separated types (e.g. CONSOLE_LOG,LEVEL_LOADED).")
+	fs.StringVar(&ev.levels, "level", "", "Only events of these comma-separated levels (e.g. ERROR,WARNING or KEYBOARD).")
+	fs.StringVar(&ev.content, "content", "", "Only events whose content contains this text (case-insensitive).")
+	fs.StringVar(&ev.from, "from", "", "Only events at or after this time into the session (e.g. 90s, 1m30s or 01:30).")
+	fs.StringVar(&ev.to, "to", "", "Only events up to this time into the session.")
+	fs.StringVar(&ev.format, "format", "table", "Output format for events and summaries: table or json.")
+	fs.IntVar(&ev.limit, "limit", 0, "Print at most this many events per session. 0 means all.")
+	setCommandUsage(fs, "inspect [flags] <dir | events.parquet>")
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return flagErrorCode(err)
+	}
+	if cfg.OutPath == "" && fs.NArg() == 1 {
+		cfg.OutPath = fs.Arg(0)
+	}
+	if err := cfg.validate("inspect"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if fi, err := os.Stat(cfg.OutPath); ev.enabled() || (err == nil && !fi.IsDir()) {
+		return runInspectEvents(cfg.OutPath, ev)
+	}
+
+	dirs, err := findRecordedSessions(cfg.OutPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tCREATED\tSTATUS\tFILES\tUPLOADED\tFAILED\tSIZE (MB)\tDIR")
+	for _, dir := range dirs {
+		fmt.Fprintln(w, inspectSession(dir))
+	}
+	w.Flush()
+	return 0
+}
+
+// inspectSession returns one tab-separated row describing a session directory.
+func inspectSession(dir string) string {
+	size := float64(0)
+	files := 0
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if fi, err := entry.Info(); err == nil && !entry.IsDir() && entry.Name() != uploader.JournalFileName {
+			files++
+			size += megabytes(fi.Size())
+		}
+	}
+
+	journal, err := uploader.LoadJournal(dir)
+	if err != nil {
+		return fmt.Sprintf("-\t-\tno journal\t%d\t-\t-\t%.1f\t%s", files, size, dir)
+	}
+
+	uploaded, failed := 0, 0
+	for _, e := range journal.Files {
+		switch e.Status {
+		case uploader.FileStatusUploaded, uploader.FileStatusConfirmed:
+			uploaded++
+		case uploader.FileStatusFailed:
+			failed++
+		}
+	}
+	status := "pending"
+	if journal.Completed {
+		status = "completed"
+	}
+	created := "-"
+	if !journal.CreatedAt.IsZero() {
+		created = journal.CreatedAt.Local().Format(time.DateTime)
+	}
+	return fmt.Sprintf("%s\t%s\t%s\t%d\t%d\t%d\t%.1f\t%s", journal.SessionID, created, status, files, uploaded, failed, size, dir)
+}
+
+// runDoctor implements `polytube.exe doctor`: it checks everything a
+// recording needs and prints one line per check. It returns 1 if any check failed.
+func runDoctor(args []string) int {
+	cfg := &cliConfig{}
+	fs := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	registerConfigFlag(fs, cfg)
+	fs.StringVar(&cfg.OutPath, "out", "", "Directory used as --out for recording.")
+	registerUploadFlags(fs, cfg)
+	setCommandUsage(fs, "doctor --out <dir> [upload flags]")
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return flagErrorCode(err)
+	}
+
+	failed := false
+	report := func(level, format string, a ...any) {
+		if level == "FAIL" {
+			failed = true
+		}
+		fmt.Printf("[%-4s] %s\n", level, fmt.Sprintf(format, a...))
+	}
+
+	if err := cfg.validate("doctor"); err != nil {
+		report("FAIL", "configuration: %v", err)
+		return 1
+	}
+	if cfg.ConfigPath != "" {
+		report("OK", "configuration: %s", cfg.ConfigPath)
+	} else {
+		report("OK", "configuration: flags and environment")
+	}
+
+	// Output directory.
+	if err := ensureDir(cfg.OutPath); err != nil {
+		report("FAIL", "out directory %s: %v", cfg.OutPath, err)
+		return 1
+	}
+	probe := filepath.Join(cfg.OutPath, ".polytube_write_test")
+	if err := os.WriteFile(probe, nil, 0o644); err != nil {
+		report("FAIL", "out directory %s is not writable: %v", cfg.OutPath, err)
+	} else {
+		_ = os.Remove(probe)
+		report("OK", "out directory %s is writable", cfg.OutPath)
+	}
+
+	// FFmpeg.
+	ffmpegPath := filepath.Join(cfg.OutPath, "ffmpeg.exe")
+	if err := recorder.LoadFFmpeg(ffmpegPath); err != nil {
+		report("FAIL", "ffmpeg: extract to %s: %v", ffmpegPath, err)
+	} else if version, err := recorder.CheckFFmpeg(ffmpegPath); err != nil {
+		report("FAIL", "ffmpeg: %v", err)
+	} else {
+		report("OK", "ffmpeg: %s", version)
+	}
+
+	// Machine.
+	sessionInfo := info.SessionInfo{Logger: logger.DiscardLogger{}}
+	sessionInfo.PopulateDeviceInfo("")
+	report("INFO", "os: %s, gpu: %s", deref(sessionInfo.OS), deref(sessionInfo.GPUModel))
+
+	// Storage.
+	storage, err := newStorage(cfg, nil)
+	if err != nil {
+		report("FAIL", "storage: %v", err)
+	} else if err := storage.Ready(); err != nil {
+		report("WARN", "storage: %v; sessions will be recorded but not uploaded", err)
+	} else if err := uploader.CheckConnectivity(storage); err != nil {
+		report("WARN", "storage unreachable: %v; sessions will be spooled", err)
+	} else {
+		report("OK", "storage reachable")
+	}
+
+	// Spool.
+	pending, err := uploader.ListPendingSessions(filepath.Join(cfg.OutPath, "data"))
+	if err != nil {
+		report("WARN", "spool: %v", err)
+	} else {
+		var total int64
+		for _, s := range pending {
+			total += s.Size
+		}
+		level := "OK"
+		if cfg.SpoolMaxMB > 0 && total > int64(cfg.SpoolMaxMB)*1024*1024 {
+			level = "WARN"
+		}
+		report(level, "spool: %d pending session(s), %.1f MB (limit %d MB)", len(pending), megabytes(total), cfg.SpoolMaxMB)
+	}
+
+	if failed {
+		return 1
+	}
+	return 0
+}
+
+func deref(s *string) string {
+	if s == nil || *s == "" {
+		return "unknown"
+	}
+	return *s
+}
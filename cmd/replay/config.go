@@ -0,0 +1,399 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/google/uuid"
+	"gopkg.in/yaml.v3"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/input"
+	"polytube/replay/internal/ipc"
+	"polytube/replay/internal/launcher"
+	"polytube/replay/internal/marker"
+	"polytube/replay/internal/recorder"
+	"polytube/replay/internal/window"
+	"polytube/replay/pkg/models"
+)
+
+// Settings come from four places, later ones winning:
+//
+//  1. flag defaults
+//  2. a config file (--config or POLYTUBE_CONFIG): YAML, TOML or JSON
+//  3. environment variables POLYTUBE_<FLAG_NAME> (e.g. POLYTUBE_API_KEY)
+//  4. command-line flags
+//
+// Config file keys are the flag names; snake_case is accepted too, so both
+// "api-key" and "api_key" work. Every cliConfig field is a flag, so every
+// field can be set in all four places.
+const (
+	configEnvVar = "POLYTUBE_CONFIG"
+	envPrefix    = "POLYTUBE_"
+)
+
+// cliConfig captures all user-provided settings. The JSON names match the flag
+// names; the session manifest stores the config redacted.
+type cliConfig struct {
+	ConfigPath     string        `json:"config,omitempty"`
+	Title          string        `json:"title,omitempty"`
+	TitleContains  string        `json:"title-contains,omitempty"`
+	TitleRegex     string        `json:"title-regex,omitempty"`
+	WindowClass    string        `json:"window-class,omitempty"`
+	WindowExe      string        `json:"window-exe,omitempty"`
+	WindowPID      int           `json:"window-pid,omitempty"`
+	WindowTimeout  time.Duration `json:"window-timeout"` // 0 = wait forever
+	OutPath        string        `json:"out"`
+	Endpoint       string        `json:"endpoint,omitempty"`
+	Storage        string        `json:"storage,omitempty"`
+	ApiID          string        `json:"api-id,omitempty"`
+	ApiKey         string        `json:"api-key,omitempty"`
+	SessionID      string        `json:"session-id,omitempty"`
+	PollSeconds    int           `json:"poll,omitempty"`
+	IsLoading      bool          `json:"-"` // deprecated --load on record; use the load command
+	Tags           string        `json:"tags,omitempty"`
+	AppName        string        `json:"app-name,omitempty"`
+	AppVersion     string        `json:"app-version,omitempty"`
+	Engine         string        `json:"engine,omitempty"`
+	Metadata       string        `json:"meta-data,omitempty"`
+	Resume         bool          `json:"resume"`
+	MaxUploads     int           `json:"max-uploads,omitempty"`
+	UploadLimit    int           `json:"upload-limit"`        // KB/s, 0 = unlimited
+	PartSize       int           `json:"part-size,omitempty"` // MB
+	ContentMD5     bool          `json:"content-md5"`
+	SpoolMaxMB     int           `json:"spool-max-mb"`  // 0 = unlimited
+	SpoolMaxAge    time.Duration `json:"spool-max-age"` // 0 = unlimited
+	IPCAddr        string        `json:"ipc-addr,omitempty"`
+	IPCTokens      string        `json:"ipc-tokens,omitempty"` // source=token,...
+	EventTypes     string        `json:"event-types,omitempty"`
+	EventSinks     string        `json:"event-sinks,omitempty"`    // parquet, ndjson, csv, sqlite
+	Overflow       string        `json:"event-overflow,omitempty"` // drop-newest, drop-oldest, block or spill
+	QueueSize      int           `json:"event-queue,omitempty"`
+	BlockWait      time.Duration `json:"event-block-timeout,omitempty"`
+	MarkerKey      string        `json:"marker-key,omitempty"`      // e.g. Ctrl+Shift+M, or none
+	MarkerCombo    string        `json:"marker-combo,omitempty"`    // e.g. Back+Start, or none
+	Buffer         time.Duration `json:"buffer,omitempty"`          // 0 = record the whole session
+	BufferTriggers string        `json:"buffer-triggers,omitempty"` // event types, comma-separated
+	BufferOnExit   bool          `json:"buffer-save-on-exit"`
+	HangTimeout    time.Duration `json:"hang-timeout"`          // 0 = no hang detection
+	CrashDumps     string        `json:"crash-dumps,omitempty"` // folders, comma-separated
+	Exec           string        `json:"exec,omitempty"`        // game to launch and record
+	ExecArgs       []string      `json:"-"`                     // arguments after --, not a flag; may hold secrets
+}
+
+// windowSelector returns the window to record selected by --title,
+// --title-contains, --title-regex, --window-class, --window-exe and --window-pid.
+// The settings must be valid.
+func (c *cliConfig) windowSelector() window.Selector {
+	sel := window.Selector{
+		Title:    c.Title,
+		Contains: c.TitleContains,
+		Class:    c.WindowClass,
+		Exe:      c.WindowExe,
+		PID:      c.WindowPID,
+	}
+	if c.TitleRegex != "" {
+		sel.Regexp, _ = regexp.Compile("(?i)" + c.TitleRegex) // already validated
+	}
+	return sel
+}
+
+// redacted returns a copy of the config that is safe to log or write to disk.
+func (c cliConfig) redacted() cliConfig {
+	if c.ApiKey != "" {
+		c.ApiKey = "<redacted>"
+	}
+	if u, err := url.Parse(c.Storage); err == nil && u.User != nil {
+		c.Storage = u.Redacted()
+	}
+	if c.IPCTokens != "" {
+		var sources []string
+		for _, pair := range strings.Split(c.IPCTokens, ",") {
+			if source, _, ok := strings.Cut(pair, "="); ok {
+				sources = append(sources, strings.TrimSpace(source)+"=<redacted>")
+			}
+		}
+		c.IPCTokens = strings.Join(sources, ",")
+	}
+	if len(c.ExecArgs) > 0 {
+		c.ExecArgs = []string{"<redacted>"}
+	}
+	return c
+}
+
+// String renders the config with secrets redacted, for logs and debug output.
+func (c cliConfig) String() string {
+	type plain cliConfig // drop the String method to avoid recursion
+	return fmt.Sprintf("%+v", plain(c.redacted()))
+}
+
+// registerConfigFlag defines --config on fs.
+func registerConfigFlag(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.ConfigPath, "config", os.Getenv(configEnvVar), "Config file (.yaml, .yml, .toml or .json) with default values for any flag. Also read from "+configEnvVar+".")
+}
+
+// parseConfig parses args into fs and then fills every flag not given on the
+// command line from the environment and the config file. Errors are printed
+// to the flag set's output.
+func parseConfig(fs *flag.FlagSet, cfg *cliConfig, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		return err // already printed by the flag package
+	}
+	if err := applyConfigSources(fs, cfg); err != nil {
+		fmt.Fprintln(fs.Output(), err)
+		return err
+	}
+	return nil
+}
+
+// applyConfigSources sets the flags not given on the command line from the
+// config file and then the environment.
+func applyConfigSources(fs *flag.FlagSet, cfg *cliConfig) error {
+	explicit := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if cfg.ConfigPath != "" {
+		values, err := readConfigFile(cfg.ConfigPath)
+		if err != nil {
+			return err
+		}
+		keys := make([]string, 0, len(values))
+		for k := range values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			name := strings.ReplaceAll(strings.ToLower(key), "_", "-")
+			if name == "config" || fs.Lookup(name) == nil {
+				return fmt.Errorf("config file %s: unknown key %q for %s", cfg.ConfigPath, key, fs.Name())
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := fs.Set(name, values[key]); err != nil {
+				return fmt.Errorf("config file %s: key %q: %v", cfg.ConfigPath, key, err)
+			}
+		}
+	}
+
+	var envErr error
+	fs.VisitAll(func(f *flag.Flag) {
+		if envErr != nil || f.Name == "config" || explicit[f.Name] {
+			return
+		}
+		env := envName(f.Name)
+		if v, ok := os.LookupEnv(env); ok {
+			if err := fs.Set(f.Name, v); err != nil {
+				envErr = fmt.Errorf("environment variable %s: %v", env, err)
+			}
+		}
+	})
+	return envErr
+}
+
+// envName maps a flag name to its environment variable, e.g. api-key -> POLYTUBE_API_KEY.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigFile reads a YAML, TOML or JSON file into flag values. Lists are
+// joined with commas and objects (e.g. meta-data) are re-encoded as JSON.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read config file: %w", err)
+	}
+
+	raw := make(map[string]any)
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		_, err = toml.Decode(string(data), &raw)
+	case ".json":
+		err = json.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q (use .yaml, .yml, .toml or .json)", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		s, err := configValue(v)
+		if err != nil {
+			return nil, fmt.Errorf("config file %s: key %q: %w", path, k, err)
+		}
+		values[k] = s
+	}
+	return values, nil
+}
+
+// configValue converts a decoded config value to its flag string form.
+func configValue(v any) (string, error) {
+	switch v := v.(type) {
+	case nil:
+		return "", nil
+	case string:
+		return v, nil
+	case []any:
+		parts := make([]string, 0, len(v))
+		for _, item := range v {
+			s, err := configValue(item)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, s)
+		}
+		return strings.Join(parts, ","), nil
+	case map[string]any:
+		b, err := json.Marshal(v)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	case float64:
+		// JSON numbers; print integers without an exponent.
+		if v == float64(int64(v)) {
+			return fmt.Sprintf("%d", int64(v)), nil
+		}
+		return fmt.Sprint(v), nil
+	default:
+		return fmt.Sprint(v), nil
+	}
+}
+
+// gamepadButtons lists the button names --marker-combo may use.
+func gamepadButtons() []string {
+	names := make([]string, 0, len(input.ButtonNames))
+	for _, name := range input.ButtonNames {
+		names = append(names, name)
+	}
+	return names
+}
+
+// validate checks the settings used by command and reports every problem at once.
+func (c *cliConfig) validate(command string) error {
+	var errs []error
+	require := func(name, value string) {
+		if strings.TrimSpace(value) == "" {
+			errs = append(errs, fmt.Errorf("--%s is required for %s (or set %s or %q in the config file)", name, command, envName(name), name))
+		}
+	}
+
+	require("out", c.OutPath)
+	if command == "record" {
+		selected := c.Title != "" || c.TitleContains != "" || c.TitleRegex != "" || c.WindowClass != "" || c.WindowExe != "" || c.WindowPID != 0
+		if c.Exec == "" && !selected {
+			errs = append(errs, fmt.Errorf("a window to record is required for %s: set --title, --title-contains, --title-regex, --window-class, --window-exe or --window-pid, or launch the game with --exec", command))
+		} else if c.Exec != "" {
+			if _, err := launcher.Resolve(c.Exec); err != nil {
+				errs = append(errs, fmt.Errorf("--exec: %w", err))
+			}
+		}
+		if _, err := regexp.Compile(c.TitleRegex); err != nil {
+			errs = append(errs, fmt.Errorf("--title-regex: %w", err))
+		}
+		if c.WindowPID < 0 {
+			errs = append(errs, fmt.Errorf("--window-pid must not be negative (got %d)", c.WindowPID))
+		}
+		if c.WindowTimeout < 0 {
+			errs = append(errs, fmt.Errorf("--window-timeout must not be negative (got %s)", c.WindowTimeout))
+		}
+		if len(c.ExecArgs) > 0 && c.Exec == "" {
+			errs = append(errs, fmt.Errorf("unexpected arguments %q: game arguments after -- need --exec", c.ExecArgs))
+		}
+		if c.PollSeconds < 1 {
+			errs = append(errs, fmt.Errorf("--poll must be at least 1 second (got %d)", c.PollSeconds))
+		}
+		if _, err := uuid.Parse(c.SessionID); c.SessionID != "" && err != nil {
+			errs = append(errs, fmt.Errorf("--session-id must be a UUID (got %q)", c.SessionID))
+		}
+	}
+	if command == "record" && c.IPCAddr != "" {
+		if err := ipc.CheckLoopback(c.IPCAddr); err != nil {
+			errs = append(errs, fmt.Errorf("--ipc-addr: %w", err))
+		}
+		if tokens, err := ipc.ParseTokens(c.IPCTokens); err != nil {
+			errs = append(errs, fmt.Errorf("--ipc-tokens: %w", err))
+		} else if len(tokens) == 0 {
+			errs = append(errs, fmt.Errorf("--ipc-tokens is required with --ipc-addr (or set %s)", envName("ipc-tokens")))
+		}
+	}
+	if command == "record" {
+		for _, name := range info.ParseTags(c.EventTypes) {
+			if err := models.ValidateEventTypeName(name); err != nil {
+				errs = append(errs, fmt.Errorf("--event-types: %w", err))
+			}
+		}
+		if _, err := events.ParseFormats(c.EventSinks); err != nil {
+			errs = append(errs, fmt.Errorf("--event-sinks: %w", err))
+		}
+		if _, err := events.ParseOverflowPolicy(c.Overflow); err != nil {
+			errs = append(errs, fmt.Errorf("--event-overflow: %w", err))
+		}
+		if c.QueueSize < 1 {
+			errs = append(errs, fmt.Errorf("--event-queue must be at least 1 (got %d)", c.QueueSize))
+		}
+		if c.BlockWait <= 0 {
+			errs = append(errs, fmt.Errorf("--event-block-timeout must be positive (got %s)", c.BlockWait))
+		}
+		if _, err := marker.ParseHotkey(c.MarkerKey); err != nil {
+			errs = append(errs, fmt.Errorf("--marker-key: %w", err))
+		}
+		if _, err := marker.ParseCombo(c.MarkerCombo, gamepadButtons()); err != nil {
+			errs = append(errs, fmt.Errorf("--marker-combo: %w", err))
+		}
+		if c.Buffer != 0 && c.Buffer < recorder.BufferSegmentDuration {
+			errs = append(errs, fmt.Errorf("--buffer must be 0 or at least %s (got %s)", recorder.BufferSegmentDuration, c.Buffer))
+		}
+		if formats, err := events.ParseFormats(c.EventSinks); c.Buffer > 0 && err == nil && (len(formats) != 1 || formats[0] != events.FormatParquet) {
+			errs = append(errs, fmt.Errorf("--buffer keeps only parquet events; --event-sinks must be parquet (got %q)", c.EventSinks))
+		}
+		if c.HangTimeout < 0 {
+			errs = append(errs, fmt.Errorf("--hang-timeout must not be negative (got %s)", c.HangTimeout))
+		}
+		custom := info.ParseTags(c.EventTypes)
+		for _, name := range bufferTriggers(c.BufferTriggers) {
+			if !models.IsBuiltinEventType(name) && !slices.Contains(custom, name) {
+				errs = append(errs, fmt.Errorf("--buffer-triggers: unknown event type %q (built in or registered with --event-types)", name))
+			}
+		}
+	}
+	if command == "record" || command == "upload" {
+		if _, err := info.ParseMetadata(c.Metadata); err != nil {
+			errs = append(errs, fmt.Errorf("--meta-data: %w", err))
+		}
+	}
+	if command != "load" && command != "inspect" && command != "export" && command != "clip" {
+		if err := validateUploadFlags(c); err != nil {
+			errs = append(errs, err)
+		}
+		if c.Storage != "" {
+			if _, err := url.Parse(c.Storage); err != nil {
+				errs = append(errs, fmt.Errorf("--storage is not a valid URL: %v", err))
+			}
+		} else if u, err := url.Parse(c.Endpoint); err != nil || u.Scheme == "" || u.Host == "" {
+			errs = append(errs, fmt.Errorf("--endpoint must be an absolute URL like https://polytube.io (got %q)", c.Endpoint))
+		}
+		if (c.ApiID == "") != (c.ApiKey == "") && c.Storage == "" {
+			errs = append(errs, errors.New("--api-id and --api-key must be set together"))
+		}
+	}
+	return errors.Join(errs...)
+}
@@ -0,0 +1,270 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"polytube/replay/internal/export"
+	"polytube/replay/internal/manifest"
+	"polytube/replay/internal/recorder"
+)
+
+// exportFormats lists the formats of `export`, in the order shown by help.
+var exportFormats []command
+
+func init() {
+	exportFormats = []command{
+		{"html", "Offline HTML replay viewer: video, input timeline and console log", runExportHTML},
+		{"overlay", "MP4 with the keyboard, mouse and gamepad state drawn onto the video", runExportOverlay},
+	}
+}
+
+// runExport implements `polytube.exe export <format>`.
+func runExport(args []string) int {
+	if len(args) > 0 {
+		for _, f := range exportFormats {
+			if f.name == args[0] {
+				return f.run(args[1:])
+			}
+		}
+	}
+	out := os.Stderr
+	fmt.Fprintln(out, "Usage: polytube.exe export <format> [flags] <session dir>")
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Formats:")
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	for _, f := range exportFormats {
+		fmt.Fprintf(w, "  %s\t%s\n", f.name, f.summary)
+	}
+	w.Flush()
+	fmt.Fprintln(out)
+	fmt.Fprintln(out, "Run `polytube.exe export <format> -h` for the flags of a format.")
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" {
+		return 0
+	}
+	return 2
+}
+
+// exportFlags are the flags shared by the export formats.
+type exportFlags struct {
+	dest   string
+	ffmpeg string
+	offset time.Duration
+}
+
+// registerExportFlags defines the shared export flags on fs.
+func registerExportFlags(fs *flag.FlagSet, cfg *cliConfig, ex *exportFlags) {
+	registerConfigFlag(fs, cfg)
+	fs.StringVar(&cfg.OutPath, "out", "", "Recorded directory: a single session directory, or an --out directory to export every session in it.")
+	fs.StringVar(&ex.dest, "dest", ".", "Directory to write the exports to. They are named after the session ID: <session-id>-replay, <session-id>-overlay.mp4, <session-id>-clips.")
+	fs.StringVar(&ex.ffmpeg, "ffmpeg", "", "ffmpeg.exe to use. Default: the one in the --out directory the session was recorded to, extracted if missing.")
+	fs.DurationVar(&ex.offset, "offset", 0, "Shift the events by this much against the video (e.g. 250ms or -1.5s) if they are out of sync.")
+}
+
+// parseExportFlags parses the flags of an export format (or of clip) and
+// resolves the session directories to export.
+func parseExportFlags(fs *flag.FlagSet, cfg *cliConfig, command string, args []string) ([]string, int) {
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return nil, flagErrorCode(err)
+	}
+	if cfg.OutPath == "" && fs.NArg() == 1 {
+		cfg.OutPath = fs.Arg(0)
+	}
+	if err := cfg.validate(command); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 2
+	}
+	dirs, err := findRecordedSessions(cfg.OutPath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return nil, 1
+	}
+	return dirs, 0
+}
+
+// runExportHTML implements `polytube.exe export html`.
+func runExportHTML(args []string) int {
+	cfg := &cliConfig{}
+	ex := &exportFlags{}
+	var video string
+	fs := flag.NewFlagSet("export html", flag.ContinueOnError)
+	registerExportFlags(fs, cfg, ex)
+	fs.StringVar(&video, "video", string(export.VideoMP4), "Video in the bundle: mp4 (remuxed with FFmpeg, plays in every browser) or hls (the recorded segments, Safari and Edge only).")
+	setCommandUsage(fs, "export html [flags] <session dir>")
+	dirs, code := parseExportFlags(fs, cfg, "export", args)
+	if dirs == nil {
+		return code
+	}
+	format, err := export.ParseVideoFormat(video)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--video: %v\n", err)
+		return 2
+	}
+
+	exit := 0
+	for _, dir := range dirs {
+		opts := export.HTMLOptions{
+			SessionDir: dir,
+			Video:      format,
+			Offset:     ex.offset,
+		}
+		opts.Title, opts.Details = describeSession(dir)
+		opts.Dest = filepath.Join(ex.dest, opts.Title+"-replay")
+		if format == export.VideoMP4 {
+			if opts.FFmpegPath, err = resolveFFmpeg(ex.ffmpeg, dir); err != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+				exit = 1
+				continue
+			}
+		}
+		fmt.Printf("Exporting %s ...\n", dir)
+		path, err := export.HTML(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			exit = 1
+			continue
+		}
+		fmt.Printf("Wrote %s\n", path)
+	}
+	return exit
+}
+
+// runExportOverlay implements `polytube.exe export overlay`.
+func runExportOverlay(args []string) int {
+	cfg := &cliConfig{}
+	ex := &exportFlags{}
+	var layout, position, font string
+	var scale float64
+	fs := flag.NewFlagSet("export overlay", flag.ContinueOnError)
+	registerExportFlags(fs, cfg, ex)
+	fs.StringVar(&layout, "layout", "keyboard,controller", "Panels to draw, side by side: keyboard (keys and mouse buttons as a heatmap), controller (gamepad diagram) or both.")
+	fs.StringVar(&position, "position", string(export.CornerBottomLeft), "Corner of the video for the panels: bottom-left, bottom-right, top-left or top-right.")
+	fs.Float64Var(&scale, "scale", 1, "Size of the panels; 1 is 24 pixels per key on the 1280x720 video.")
+	fs.StringVar(&font, "font", defaultOverlayFont(), "TrueType font for the key labels.")
+	setCommandUsage(fs, "export overlay [flags] <session dir>")
+	dirs, code := parseExportFlags(fs, cfg, "export", args)
+	if dirs == nil {
+		return code
+	}
+	layouts, err := export.ParseLayouts(layout)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--layout: %v\n", err)
+		return 2
+	}
+	corner, err := export.ParseCorner(position)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "--position: %v\n", err)
+		return 2
+	}
+	if scale <= 0 || scale > 4 {
+		fmt.Fprintf(os.Stderr, "--scale must be between 0 and 4 (got %g)\n", scale)
+		return 2
+	}
+
+	exit := 0
+	for _, dir := range dirs {
+		sessionID, _ := describeSession(dir)
+		opts := export.OverlayOptions{
+			SessionDir: dir,
+			Dest:       filepath.Join(ex.dest, sessionID+"-overlay.mp4"),
+			Layouts:    layouts,
+			Corner:     corner,
+			Scale:      scale,
+			Offset:     ex.offset,
+			FontFile:   font,
+		}
+		if opts.FFmpegPath, err = resolveFFmpeg(ex.ffmpeg, dir); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			exit = 1
+			continue
+		}
+		fmt.Printf("Rendering %s (this re-encodes the whole video) ...\n", dir)
+		script, err := export.Overlay(opts)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			exit = 1
+			continue
+		}
+		fmt.Printf("Wrote %s (filter script %s)\n", opts.Dest, script)
+	}
+	return exit
+}
+
+// defaultOverlayFont returns Arial from the Windows font directory, or "" to
+// leave the choice to FFmpeg.
+func defaultOverlayFont() string {
+	path := filepath.Join(os.Getenv("WINDIR"), "Fonts", "arial.ttf")
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// describeSession returns the session ID of a session directory (its folder
+// name if it has no manifest) and what the manifest knows about the session.
+func describeSession(dir string) (string, []export.Detail) {
+	m, err := manifest.Load(dir)
+	if err != nil || m.SessionID == "" {
+		abs, _ := filepath.Abs(dir)
+		return filepath.Base(abs), nil
+	}
+	var details []export.Detail
+	add := func(name string, value *string) {
+		if value != nil && *value != "" {
+			details = append(details, export.Detail{Name: name, Value: *value})
+		}
+	}
+	s := m.SessionInfo
+	add("App", s.AppName)
+	add("Version", s.AppVersion)
+	add("Build", s.BuildNumber)
+	add("Branch", s.Branch)
+	add("Engine", s.Engine)
+	add("OS", s.OS)
+	add("GPU", s.GPUModel)
+	if len(s.Tags) > 0 {
+		tags := strings.Join(s.Tags, ", ")
+		add("Tags", &tags)
+	}
+	if m.Recording.Start != nil {
+		start := m.Recording.Start.Wall.Local().Format(time.DateTime)
+		add("Recorded", &start)
+	}
+	return m.SessionID, details
+}
+
+// resolveFFmpeg returns the ffmpeg.exe to export with: path if set, otherwise
+// the one in the --out directory the session was recorded to (<out>/data/<id>),
+// extracting the bundled binary there (or into the temp directory for a
+// session copied elsewhere) if it is missing.
+func resolveFFmpeg(path, sessionDir string) (string, error) {
+	if path != "" {
+		return path, nil
+	}
+	abs, err := filepath.Abs(sessionDir)
+	if err != nil {
+		return "", err
+	}
+	outDir := filepath.Dir(abs)
+	if filepath.Base(outDir) == "data" {
+		outDir = filepath.Dir(outDir)
+	} else if filepath.Base(abs) != "data" {
+		// Not under an --out directory; keep the session folder free of it.
+		outDir = filepath.Join(os.TempDir(), "polytube")
+	}
+	if err := ensureDir(outDir); err != nil {
+		return "", fmt.Errorf("failed to create %s: %w", outDir, err)
+	}
+	ffmpegPath := filepath.Join(outDir, "ffmpeg.exe")
+	if err := recorder.LoadFFmpeg(ffmpegPath); err != nil {
+		return "", fmt.Errorf("failed to load FFmpeg into %s: %w", outDir, err)
+	}
+	return ffmpegPath, nil
+}
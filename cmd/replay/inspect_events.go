@@ -0,0 +1,289 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/manifest"
+	"polytube/replay/pkg/models"
+)
+
+// inspectEventFlags are the flags of `inspect` that read the recorded events.
+type inspectEventFlags struct {
+	events  bool
+	summary bool
+	types   string
+	levels  string
+	content string
+	from    string
+	to      string
+	format  string
+	limit   int
+}
+
+// enabled reports whether any event flag was given.
+func (f *inspectEventFlags) enabled() bool {
+	return f.events || f.summary || f.types != "" || f.levels != "" || f.content != "" || f.from != "" || f.to != ""
+}
+
+// filter builds the event filter from the flags.
+func (f *inspectEventFlags) filter() (events.Filter, error) {
+	filter := events.Filter{
+		Types:   info.ParseTags(f.types),
+		Levels:  info.ParseTags(f.levels),
+		Content: f.content,
+	}
+	var errs []error
+	if f.from != "" {
+		d, err := parseSessionTime(f.from)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--from: %w", err))
+		}
+		filter.From = &d
+	}
+	if f.to != "" {
+		d, err := parseSessionTime(f.to)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("--to: %w", err))
+		}
+		filter.To = &d
+	}
+	if f.format != "table" && f.format != "json" {
+		errs = append(errs, fmt.Errorf("--format must be table or json (got %q)", f.format))
+	}
+	if f.limit < 0 {
+		errs = append(errs, fmt.Errorf("--limit must not be negative (got %d)", f.limit))
+	}
+	return filter, errors.Join(errs...)
+}
+
+// inspectedSession is the JSON output of one session.
+type inspectedSession struct {
+	SessionID string            `json:"session_id,omitempty"`
+	Path      string            `json:"path"`
+	Summary   *events.Summary   `json:"summary,omitempty"`
+	Events    []json.RawMessage `json:"events,omitempty"`
+	Error     string            `json:"error,omitempty"`
+}
+
+// runInspectEvents prints the events and/or summaries of the sessions at path:
+// a single event file, a session directory or an --out directory.
+func runInspectEvents(path string, flags *inspectEventFlags) int {
+	filter, err := flags.filter()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	if !flags.events && !flags.summary {
+		flags.events = true
+	}
+
+	var targets []string
+	if fi, err := os.Stat(path); err == nil && !fi.IsDir() {
+		targets = []string{path}
+	} else if targets, err = findRecordedSessions(path); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	exit := 0
+	var sessions []inspectedSession
+	for _, target := range targets {
+		s := inspectedSession{Path: target}
+		var all []models.Event
+		if fi, err := os.Stat(target); err == nil && !fi.IsDir() {
+			all, err = events.ReadFile(target)
+		} else {
+			s.SessionID = filepath.Base(target)
+			all, err = events.ReadSession(target)
+		}
+		if err != nil {
+			s.Error = err.Error()
+			exit = 1
+		}
+		selected := filter.Apply(all)
+
+		if flags.format == "json" {
+			if flags.summary {
+				sum := events.Summarize(selected)
+				s.Summary = &sum
+			}
+			if flags.events {
+				for i, e := range selected {
+					if flags.limit > 0 && i >= flags.limit {
+						break
+					}
+					b, _ := events.MarshalEvent(e)
+					s.Events = append(s.Events, b)
+				}
+			}
+			sessions = append(sessions, s)
+			continue
+		}
+
+		if len(targets) > 1 || flags.summary {
+			fmt.Printf("== %s\n", target)
+		}
+		if s.Error != "" {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", target, s.Error)
+		}
+		if flags.summary {
+			printSummary(os.Stdout, target, events.Summarize(selected))
+		}
+		if flags.events {
+			printEvents(os.Stdout, selected, flags.limit)
+		}
+		if len(targets) > 1 {
+			fmt.Println()
+		}
+	}
+
+	if flags.format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(sessions); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	return exit
+}
+
+// printEvents writes events as a table, at most limit rows (0 = all).
+func printEvents(out io.Writer, list []models.Event, limit int) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tTYPE\tLEVEL\tCONTENT\tVALUE\tSOURCE\tFIELDS")
+	for i, e := range list {
+		if limit > 0 && i >= limit {
+			fmt.Fprintf(w, "... %d more\n", len(list)-limit)
+			break
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			formatSessionTime(time.Duration(e.SessionMs)*time.Millisecond),
+			e.EventType, dash(e.EventLevel), dash(oneLine(e.Content)),
+			strconv.FormatFloat(e.Value, 'f', -1, 64), dash(e.Source), e.Attributes)
+	}
+	w.Flush()
+}
+
+// printSummary writes the summary of one session.
+func printSummary(out io.Writer, target string, s events.Summary) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	duration := formatSessionTime(time.Duration(s.DurationMs) * time.Millisecond)
+	if m, err := manifest.Load(target); err == nil && m.Recording.DurationMs > 0 {
+		duration += fmt.Sprintf(" (recording %s)", formatSessionTime(time.Duration(m.Recording.DurationMs)*time.Millisecond))
+	}
+	fmt.Fprintf(w, "Duration:\t%s\n", duration)
+	if !s.RecordingStart {
+		fmt.Fprintf(w, "Warning:\tno %s event; times are relative to the first event\n", models.EventTypeRecordingStarted)
+	}
+
+	var types []string
+	for t, n := range s.ByType {
+		types = append(types, fmt.Sprintf("%s %d", t, n))
+	}
+	sort.Strings(types)
+	fmt.Fprintf(w, "Events:\t%d\t%s\n", s.Events, strings.Join(types, ", "))
+
+	perMinute := 0.0
+	if s.DurationMs > 0 {
+		perMinute = float64(s.KeyPresses) / (float64(s.DurationMs) / 60000)
+	}
+	fmt.Fprintf(w, "Key presses:\t%d\t%.1f per minute\n", s.KeyPresses, perMinute)
+	if len(s.KeysPerMinute) > 0 {
+		minutes := make([]string, len(s.KeysPerMinute))
+		for i, n := range s.KeysPerMinute {
+			minutes[i] = strconv.Itoa(n)
+		}
+		fmt.Fprintf(w, "Keys per minute:\t%s\n", strings.Join(minutes, " "))
+	}
+	for i, b := range s.TopButtons {
+		label := ""
+		if i == 0 {
+			label = "Most pressed:"
+		}
+		fmt.Fprintf(w, "%s\t%s (%s)\t%d\n", label, b.Name, strings.ToLower(b.Device), b.Presses)
+	}
+	fmt.Fprintf(w, "Console errors:\t%d\t%d warning(s)\n", s.ConsoleErrors, s.ConsoleWarns)
+	for _, m := range s.TopErrors {
+		fmt.Fprintf(w, "\t%dx\t%s\n", m.Count, oneLine(m.Message))
+	}
+	for i, m := range s.Markers {
+		label := ""
+		if i == 0 {
+			label = "Markers:"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\n", label, formatSessionTime(time.Duration(m.SessionMs)*time.Millisecond), oneLine(m.Note))
+	}
+	if s.DroppedEvents > 0 || s.SequenceGaps > 0 {
+		fmt.Fprintf(w, "Dropped events:\t%d\t%d missing from the sequence\n", s.DroppedEvents, s.SequenceGaps)
+	}
+	w.Flush()
+}
+
+// parseSessionTime parses a time into the session: a Go duration (90s, 1m30s)
+// or a clock time like 01:30 or 1:02:03.5.
+func parseSessionTime(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if d, err := time.ParseDuration(s); err == nil {
+		return d, nil
+	}
+	neg := strings.HasPrefix(s, "-")
+	parts := strings.Split(strings.TrimPrefix(s, "-"), ":")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q (use e.g. 90s, 1m30s or 01:30)", s)
+	}
+	secs := 0.0
+	for i, p := range parts {
+		n, err := strconv.ParseFloat(p, 64)
+		if err != nil || n < 0 || (i < len(parts)-1 && n != float64(int(n))) {
+			return 0, fmt.Errorf("invalid time %q (use e.g. 90s, 1m30s or 01:30)", s)
+		}
+		secs = secs*60 + n
+	}
+	d := time.Duration(secs * float64(time.Second))
+	if neg {
+		d = -d
+	}
+	return d, nil
+}
+
+// formatSessionTime formats a session time as [-][h:]mm:ss.mmm.
+func formatSessionTime(d time.Duration) string {
+	sign := ""
+	if d < 0 {
+		sign, d = "-", -d
+	}
+	ms := d.Milliseconds()
+	h, m, sec, ms := ms/3600000, ms/60000%60, ms/1000%60, ms%1000
+	if h > 0 {
+		return fmt.Sprintf("%s%d:%02d:%02d.%03d", sign, h, m, sec, ms)
+	}
+	return fmt.Sprintf("%s%02d:%02d.%03d", sign, m, sec, ms)
+}
+
+// oneLine replaces line breaks so a value fits in one table row.
+func oneLine(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\n", " ", "\r", " ", "\t", " ").Replace(s)
+}
+
+// dash returns "-" for an empty table cell.
+func dash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
@@ -1,11 +1,28 @@
 //go:build windows
 
 // Package main provides the Windows-only CLI entrypoint for the Replay tool.
-// It coordinates the lifecycle: parse flags -> init services -> start FFmpeg recording
+// The CLI is split into commands (record, load, upload, upload-pending, inspect,
+// export, clip, doctor); record is the default so the original flag-only invocation keeps
+// working. Settings come from flags, POLYTUBE_* environment variables and an
+// optional YAML/TOML/JSON config file (see config.go).
+//
+// Recording coordinates the lifecycle: parse flags -> init services -> start FFmpeg recording
 // -> run background listeners/pollers -> wait for FFmpeg exit -> orderly shutdown.
 //
 // The program exits only after FFmpeg (recording the target window) exits, which
-// happens when the target window closes.
+// happens when the target window closes. With --exec the tool launches the game
+// itself, records its window once it appears and stops when the game exits.
+//
+// Every session records into its own directory (<out>/data/<session-id>) together
+// with an upload journal. On startup, sessions left unfinished by a crash, power
+// loss or network outage are resumed with their original session ID before the
+// new recording starts. When the storage is unreachable, sessions stay spooled
+// on disk (bounded by --spool-max-mb and --spool-max-age) and are drained on a
+// later run or with `polytube.exe upload-pending`.
+//
+// With --buffer the recording is a replay buffer instead: only its last minutes
+// stay on disk, and they are saved into a new session, uploaded like any other,
+// whenever a --buffer-triggers event occurs (see buffer.go).
 package main
 
 import (
@@ -14,103 +31,331 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 
+	"polytube/replay/internal/buffer"
 	"polytube/replay/internal/console"
 	"polytube/replay/internal/events"
 	"polytube/replay/internal/info"
 	"polytube/replay/internal/input"
+	"polytube/replay/internal/ipc"
+	"polytube/replay/internal/launcher"
 	"polytube/replay/internal/logger"
+	"polytube/replay/internal/manifest"
+	"polytube/replay/internal/marker"
 	"polytube/replay/internal/recorder"
 	"polytube/replay/internal/uploader"
+	"polytube/replay/internal/watchdog"
+	"polytube/replay/internal/window"
+	"polytube/replay/pkg/models"
 )
 
 const (
 	defaultPollSeconds = 5
+	defaultMaxUploads  = uploader.DefaultMaxConcurrent
+	defaultPartSizeMB  = uploader.DefaultPartSize / (1024 * 1024)
+	defaultSpoolMaxMB  = 10 * 1024
+	defaultSpoolMaxAge = 14 * 24 * time.Hour
+	eventFileMaxBytes  = 64 * 1024 * 1024 // start a new event file within a segment beyond this
 )
 
-// cliConfig captures all user-provided settings from flags.
-type cliConfig struct {
-	Title       string
-	OutPath     string
-	Endpoint    string
-	ApiID       string
-	ApiKey      string
-	SessionID   string
-	PollSeconds int
-	IsLoading   bool
-	Tags        string
-	AppName     string
-	AppVersion  string
-	Engine      string
-	Metadata    string
-}
-
 // serviceBundle groups all running components so main can manage their lifecycle.
 type serviceBundle struct {
 	ctx                  context.Context
 	cancel               context.CancelFunc
 	rec                  *recorder.Recorder
 	upl                  *uploader.Uploader
-	eventLogger          *events.ParquetEventLogger
+	manifest             *manifest.Manifest
+	eventLogger          *events.EventLogger
+	logTo                events.EventLoggerInterface // what producers log to; wraps eventLogger
 	internalLogger       *logger.Logger
 	mnkInputListener     *input.MNKInputListener
 	gamepadInputListener *input.GamepadInputListener
 	consoleListener      *console.ConsoleListener
+	buffer               *replayBuffer // --buffer only
+	watchdog             *watchdog.Watchdog
+	uploading            bool // the recording started and the session is uploaded
+}
+
+// gameExitGrace is how long the game may take to exit after its window closed.
+const gameExitGrace = 5 * time.Second
+
+// crashDumpDirs returns the folders watched for crash dumps: those of
+// --crash-dumps and the local dump folder of Windows Error Reporting.
+func crashDumpDirs(s string) []string {
+	dirs := info.ParseTags(s)
+	if local := os.Getenv("LOCALAPPDATA"); local != "" {
+		dirs = append(dirs, filepath.Join(local, "CrashDumps"))
+	}
+	return dirs
+}
+
+// windowPollInterval is how often a window that is not there yet is looked for.
+const windowPollInterval = 500 * time.Millisecond
+
+// defaultWindowTimeout is how long the window to record is waited for.
+const defaultWindowTimeout = time.Minute
+
+// waitForWindow waits up to timeout (0 waits forever) for the window to record
+// to appear. With a launched game, it fails if the game exits first.
+func waitForWindow(ctx context.Context, sel window.Selector, timeout time.Duration, game *launcher.Game) (window.Window, error) {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	if timeout > 0 {
+		var stop context.CancelFunc
+		ctx, stop = context.WithTimeoutCause(ctx, timeout, fmt.Errorf("no window appeared within %s", timeout))
+		defer stop()
+	}
+	if game != nil {
+		go func() {
+			select {
+			case <-game.Done():
+				cancel(fmt.Errorf("game exited with code %d before its window appeared", game.ExitCode()))
+			case <-ctx.Done():
+			}
+		}()
+	}
+	return window.Wait(ctx, windowPollInterval, sel.Find)
+}
+
+// recordGameExit stores how the game ended in the journal, for the session end
+// update, and in the manifest.
+func recordGameExit(svcs *serviceBundle, exit *watchdog.Exit) {
+	e := uploader.GameExit{Status: exit.Status, ExitCode: exit.Code}
+	if exit.CrashDump != "" {
+		e.CrashDump = filepath.Base(exit.CrashDump)
+	}
+	svcs.internalLogger.Info(fmt.Sprintf("Game %s", exit.Status))
+	if journal := svcs.journal(); journal != nil {
+		if err := journal.SetGameExit(e); err != nil {
+			svcs.internalLogger.Warn(fmt.Sprintf("record game exit in journal failed: %v", err))
+		}
+	}
+	svcs.manifest.SetGameExit(e)
+	if svcs.buffer != nil {
+		svcs.buffer.setGameExit(e)
+	}
+}
+
+// journal returns the upload journal of the recorded session, or nil in
+// replay buffer mode, where the buffer itself is never uploaded.
+func (s *serviceBundle) journal() *uploader.Journal {
+	if s.upl == nil {
+		return nil
+	}
+	return s.upl.Journal
+}
+
+// startUploads creates the remote session and uploads segments every poll
+// seconds as they appear, until the background context is canceled. It is
+// called once the recording started, so a run that never finds its window
+// leaves no empty session behind.
+func (s *serviceBundle) startUploads(poll int) {
+	if s.upl == nil {
+		return
+	}
+	s.uploading = true
+	go func() {
+		s.internalLogger.Info(fmt.Sprintf("Uploader poller starting (interval=%ds)", poll))
+		ticker := time.NewTicker(time.Duration(poll) * time.Second)
+
+		s.internalLogger.Info("Starting session info...")
+		s.upl.StartSessionInfo()
+
+		defer ticker.Stop()
+		for {
+			select {
+			case <-s.ctx.Done():
+				s.internalLogger.Info("Uploader poller stopping (context canceled)")
+				return
+			case <-ticker.C:
+				s.upl.UploadTS()
+				saveManifest(s.manifest, s.upl.Journal, s.internalLogger)
+			}
+		}
+	}()
 }
 
-// main parses flags, starts services, waits for FFmpeg to exit, and runs shutdown.
+// main dispatches to the command named by the first argument. Without one,
+// or when the first argument is a flag, it records (the original CLI).
 func main() {
-	cfg := parseFlags()
+	args := os.Args[1:]
+	name := "record"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+	cmd, ok := findCommand(name)
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+		printUsage()
+		os.Exit(2)
+	}
+	os.Exit(cmd.run(args))
+}
 
-	dataDir := filepath.Join(cfg.OutPath, "data")
-	// Prepare file paths under the output folder.
-	internalLogPath := filepath.Join(dataDir, "internal.log")
-	eventsPath := filepath.Join(dataDir, "events.parquet")
+// runRecord implements `polytube.exe record`: it records the target window
+// until it closes, then runs the shutdown sequence. It returns the exit code.
+func runRecord(args []string) int {
+	cfg, code := parseRecordFlags(args)
+	if cfg == nil {
+		return code
+	}
+	if cfg.IsLoading {
+		fmt.Fprintln(os.Stderr, "--load is deprecated; use `polytube.exe load --out <dir>`")
+		return loadBinaries(cfg.OutPath)
+	}
+
+	baseDataDir := filepath.Join(cfg.OutPath, "data")
 	ffmpegPath := filepath.Join(cfg.OutPath, "ffmpeg.exe")
 
 	if err := ensureDir(cfg.OutPath); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create out directory: %v\n", err)
-		os.Exit(1)
-	}
-	if err := ensureAndWipeDir(dataDir); err != nil {
-		fmt.Fprintf(os.Stderr, "failed to create or wipe data directory: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
 	if err := recorder.LoadFFmpeg(ffmpegPath); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to load FFmpeg: %v\n", err)
-		os.Exit(1)
+		return 1
 	}
 
-	if cfg.IsLoading {
-		fmt.Println("Loading complete.")
-		os.Exit(0)
+	if err := ensureDir(baseDataDir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create data directory: %v\n", err)
+		return 1
+	}
+
+	// One bandwidth budget shared by every upload of this process.
+	bandwidth := uploader.NewBandwidthLimiter(int64(cfg.UploadLimit) * 1024)
+
+	storage, err := newStorage(cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --storage: %v\n", err)
+		return 2
+	}
+
+	// Deliver sessions interrupted by a crash or outage before recording a new one.
+	// If the storage can't be used (e.g. no credentials) or is unreachable they
+	// stay spooled, for a later run or the upload command on another machine.
+	if err := storage.Ready(); err != nil {
+		fmt.Printf("Storage not configured (%v); pending sessions stay spooled\n", err)
+	} else if cfg.Resume {
+		if err := uploader.CheckConnectivity(storage); err != nil {
+			fmt.Printf("Storage unreachable (%v); pending sessions stay spooled\n", err)
+		} else {
+			resumeUnfinishedSessions(cfg, baseDataDir, bandwidth)
+		}
+	}
+	enforceSpoolQuota(cfg, baseDataDir, "")
+	keep, err := uploader.FindUnfinishedSessions(baseDataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to scan data directory: %v\n", err)
+		return 1
+	}
+	if err := cleanDataDir(baseDataDir, keep); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to clean data directory: %v\n", err)
+		return 1
+	}
+
+	// Prepare file paths under the session folder.
+	dataDir := filepath.Join(baseDataDir, cfg.SessionID)
+	internalLogPath := filepath.Join(dataDir, "internal.log")
+
+	if err := ensureAndWipeDir(dataDir); err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create or wipe session directory: %v\n", err)
+		return 1
 	}
 
 	// Initialize services and start background tasks.
-	svcs, err := startServices(cfg, dataDir, internalLogPath, eventsPath, ffmpegPath)
+	svcs, err := startServices(cfg, dataDir, internalLogPath, ffmpegPath, bandwidth)
 	if err != nil {
 		// Best-effort stderr message since internal logger may not have initialized.
 		fmt.Fprintf(os.Stderr, "startup error: %v\n", err)
-		os.Exit(1)
+		return 1
+	}
+
+	// Launch-and-record: start the game and record its window once it appears.
+	var game *launcher.Game
+	if cfg.Exec != "" {
+		game = &launcher.Game{
+			Path:        cfg.Exec,
+			Args:        cfg.ExecArgs,
+			EventLogger: svcs.logTo,
+			Logger:      svcs.internalLogger,
+		}
+		if err := game.Start(svcs.ctx); err != nil {
+			svcs.internalLogger.Error(err.Error())
+			fmt.Fprintln(os.Stderr, err)
+			_ = shutdown(svcs)
+			return 1
+		}
+		fmt.Printf("Started %s\n", cfg.Exec)
 	}
 
+	// Pre-flight: find the window to record, waiting for it to appear. FFmpeg
+	// then captures that very window, whatever its title changes to.
+	sel := cfg.windowSelector()
+	if game != nil && sel.IsZero() {
+		sel.PID = game.PID() // any window of the game
+	}
+	w, err := sel.Find()
+	if err != nil {
+		fmt.Printf("Waiting for the window to record (%s)...\n", sel)
+		w, err = waitForWindow(svcs.ctx, sel, cfg.WindowTimeout, game)
+	}
+	if err != nil {
+		svcs.internalLogger.Error(fmt.Sprintf("waiting for the window to record failed: %v", err))
+		fmt.Fprintln(os.Stderr, err)
+		code := 1
+		if game != nil {
+			// Nothing is recorded, so the game launched for it is ended too.
+			if err := game.Kill(); err != nil {
+				svcs.internalLogger.Warn(err.Error())
+			}
+			<-game.Done()
+			code = max(game.ExitCode(), 1)
+		}
+		_ = shutdown(svcs)
+		return code
+	}
+	svcs.internalLogger.Info(fmt.Sprintf("Recording window %q (class %q, pid %d)", w.Title, w.Class, w.PID))
+	svcs.rec.Title = w.Title
+	svcs.rec.Handle = uintptr(w.Handle)
+	svcs.watchdog.Find = func() (watchdog.Process, error) { return watchdog.OpenProcess(w) }
+
 	// Record and block until FFmpeg exits (i.e., the game window closes).
 	if err := svcs.rec.Start(); err != nil {
 		svcs.internalLogger.Error(fmt.Errorf("recorder start failed: %w", err).Error())
 		_ = shutdown(svcs) // attempt cleanup anyway
-		os.Exit(1)
+		return 1
+	}
+	svcs.watchdog.Start(svcs.ctx)
+	svcs.startUploads(cfg.PollSeconds)
+
+	// The session ends when the launched game exits, even if its window
+	// outlives it.
+	if game != nil {
+		go func() {
+			select {
+			case <-game.Done():
+				svcs.internalLogger.Info("Game exited; stopping the recording")
+				if err := svcs.rec.Stop(); err != nil {
+					svcs.internalLogger.Warn(err.Error())
+				}
+			case <-svcs.ctx.Done():
+			}
+		}()
 	}
 
 	// Log event
 	if err := svcs.rec.LogRecordingStartedEvent(); err != nil {
 		svcs.internalLogger.Error(fmt.Errorf("failed to log RECORDING_STARTED event. Have to exit.: %w", err).Error())
 		_ = shutdown(svcs) // attempt cleanup anyway
-		os.Exit(1)
+		return 1
 	}
+	svcs.manifest.MarkStarted()
+	saveManifest(svcs.manifest, svcs.journal(), svcs.internalLogger)
 	svcs.internalLogger.Info("FFmpeg started; waiting for process to exit...")
 
 	if err := svcs.rec.Wait(); err != nil {
@@ -118,6 +363,13 @@ func main() {
 	} else {
 		svcs.internalLogger.Info("FFmpeg exited normally (window closed).")
 	}
+	svcs.manifest.MarkEnded()
+
+	// Tell a normal quit from a crash or a hang; the game usually exits right
+	// after its window closed.
+	if exit := svcs.watchdog.Stop(gameExitGrace); exit != nil {
+		recordGameExit(svcs, exit)
+	}
 
 	// Execute the orderly shutdown sequence (strict order).
 	if err := shutdown(svcs); err != nil {
@@ -126,60 +378,129 @@ func main() {
 		// Do not os.Exit with non-zero here purely due to late-stage upload hiccups,
 		// but you can choose to if your policy requires it.
 	}
+
+	// The buffer was saved into sessions of their own where it mattered.
+	if cfg.Buffer > 0 {
+		if err := os.RemoveAll(dataDir); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove replay buffer %s: %v\n", dataDir, err)
+		}
+	}
+
+	// Keep the spool within its quota; the session just recorded is never dropped.
+	enforceSpoolQuota(cfg, baseDataDir, dataDir)
+
+	// Launch-and-record exits with the game's exit code.
+	if game != nil {
+		return max(game.ExitCode(), 0)
+	}
+	return 0
 }
 
-// parseFlags configures the CLI and validates required flags.
-func parseFlags() *cliConfig {
+// parseRecordFlags parses and validates the record command's settings. It
+// returns nil and an exit code when the command should not run.
+func parseRecordFlags(args []string) (*cliConfig, int) {
 	cfg := &cliConfig{}
+	fs := flag.NewFlagSet("record", flag.ContinueOnError)
+	registerConfigFlag(fs, cfg)
+	fs.BoolVar(&cfg.IsLoading, "load", false, "Deprecated: use the load command. Loads nessesary binaries (ffmpeg) and exits.")
+	fs.StringVar(&cfg.Title, "title", "", "Window title to record (exact match, case-insensitive, use quotes if needed). Optional with --exec or another window flag.")
+	fs.StringVar(&cfg.TitleContains, "title-contains", "", "Record the window whose title contains this text (case-insensitive), for titles with changing parts such as an FPS counter.")
+	fs.StringVar(&cfg.TitleRegex, "title-regex", "", "Record the window whose title matches this regular expression (case-insensitive, Go syntax), e.g. '^My Game \\(v[0-9.]+\\)$'.")
+	fs.StringVar(&cfg.WindowClass, "window-class", "", "Record the window of this window class (exact match, case-insensitive), e.g. UnityWndClass.")
+	fs.StringVar(&cfg.WindowExe, "window-exe", "", "Record the window of the process running this executable (e.g. MyGame.exe).")
+	fs.IntVar(&cfg.WindowPID, "window-pid", 0, "Record the window of the process with this ID.")
+	fs.DurationVar(&cfg.WindowTimeout, "window-timeout", defaultWindowTimeout, "How long to wait for the window to appear before giving up. 0 waits forever.")
+	fs.StringVar(&cfg.Exec, "exec", "", "Launch this game executable and record its window; arguments for it follow --, e.g. --exec game.exe -- -windowed. Its stdout/stderr become console events and the tool exits with its exit code.")
+	fs.StringVar(&cfg.OutPath, "out", "", "Directory where output files (video, logs, etc.) will be saved.")
+	fs.StringVar(&cfg.SessionID, "session-id", "", "*Leave Empty* Unique session identifier (UUID). Used to link uploads to an existing session on the server. Auto generated.")
+	fs.StringVar(&cfg.Tags, "tags", "", "Comma-separated list of tags for organizing or categorizing the recording session (e.g., 'test,debug,build42').")
+	fs.StringVar(&cfg.AppName, "app-name", "<Unassigned>", "Name of the app or game being recorded. Appears in analytics and upload metadata.")
+	fs.StringVar(&cfg.AppVersion, "app-version", "<Unassigned>", "Version of the app being recorded. Use semantic versioning (e.g., '1.0.0').")
+	fs.StringVar(&cfg.Engine, "engine", "<Unassigned>", "What game engine is primarily used to make this game.")
+	fs.StringVar(&cfg.Metadata, "meta-data", "{}", "JSON object attached to the session as free-form metadata. Known keys (build_number, branch, player_id) also fill the matching session fields.")
+	fs.BoolVar(&cfg.Resume, "resume", true, "Resume uploading sessions left unfinished by a previous run before recording.")
+	fs.StringVar(&cfg.IPCAddr, "ipc-addr", "", fmt.Sprintf("Accept events over HTTP on this loopback address (e.g. %s). Disabled if empty.", ipc.DefaultAddr))
+	fs.StringVar(&cfg.IPCTokens, "ipc-tokens", "", "Comma-separated source=token pairs accepted by --ipc-addr (e.g. 'unity=<token>,harness=<token>').")
+	fs.StringVar(&cfg.EventTypes, "event-types", "", "Comma-separated custom event types (UPPER_SNAKE_CASE) games may send. If set, events of other custom types are rejected.")
+	fs.StringVar(&cfg.EventSinks, "event-sinks", events.FormatParquet, fmt.Sprintf("Comma-separated event log formats to write: %s. Parquet is what polytube.io reads.", strings.Join(events.Formats, ", ")))
+	fs.StringVar(&cfg.Overflow, "event-overflow", string(events.OverflowDropNewest), "What to do with events when the event queue is full: drop-newest, drop-oldest, block (up to --event-block-timeout) or spill (to a file in the session folder).")
+	fs.IntVar(&cfg.QueueSize, "event-queue", events.DefaultQueueSize, "Number of events buffered in memory before --event-overflow applies.")
+	fs.DurationVar(&cfg.BlockWait, "event-block-timeout", events.DefaultBlockTimeout, "How long --event-overflow=block waits for room before dropping an event.")
+	fs.StringVar(&cfg.MarkerKey, "marker-key", "Ctrl+Shift+M", "Hotkey that drops a marker (e.g. F9 or Ctrl+Shift+M), for cutting clips later. 'none' disables it.")
+	fs.StringVar(&cfg.MarkerCombo, "marker-combo", "Back+Start", "Gamepad buttons that drop a marker when held together (e.g. Back+Start or LeftBumper+RightBumper+Y). 'none' disables it.")
+	fs.DurationVar(&cfg.Buffer, "buffer", 0, "Replay buffer mode: keep only the last N minutes (e.g. 5m) on disk and save them as a session of their own, uploaded like any other, when --buffer-triggers events occur. 0 records and uploads the whole session.")
+	fs.StringVar(&cfg.BufferTriggers, "buffer-triggers", strings.Join([]string{models.EventTypeMarker.String(), models.EventTypeGameCrashed.String(), models.EventTypeGameHung.String()}, ","), "Comma-separated event types that save the replay buffer. BUFFER_SAVE_REQUESTED (/save-buffer on stdin, POST /v1/buffer/save) always does.")
+	fs.BoolVar(&cfg.BufferOnExit, "buffer-save-on-exit", false, "Also save the replay buffer whenever the recorded window closes.")
+	fs.DurationVar(&cfg.HangTimeout, "hang-timeout", watchdog.DefaultHangAfter, "Log GAME_HUNG when the game window does not respond for this long. 0 disables hang detection.")
+	fs.StringVar(&cfg.CrashDumps, "crash-dumps", "", "Comma-separated folders where the game writes crash dumps (*.dmp), in addition to %LOCALAPPDATA%\\CrashDumps. A new dump marks the game as crashed.")
+	fs.IntVar(&cfg.PollSeconds, "poll", defaultPollSeconds, fmt.Sprintf("Interval in seconds between uploader checks for new files to upload. Default: %d", defaultPollSeconds))
+	registerUploadFlags(fs, cfg)
+	setCommandUsage(fs, "record [flags] [-- <game arguments>]")
 
-	flag.BoolVar(&cfg.IsLoading, "load", false, "Loads nessesary binaries (ffmpeg) and exits. Ignores other flags.")
-	flag.StringVar(&cfg.Title, "title", "", "Window title to record (exact match, use quotes if needed).")
-	flag.StringVar(&cfg.OutPath, "out", "", "Directory where output files (video, logs, etc.) will be saved.")
-	flag.StringVar(&cfg.Endpoint, "endpoint", "https://polytube.io", "Upload endpoint URL for cloud storage.")
-	flag.StringVar(&cfg.ApiID, "api-id", "", "API ID for authentication when communicating with the upload endpoint.")
-	flag.StringVar(&cfg.ApiKey, "api-key", "", "API Key for authentication when communicating with the upload endpoint.")
-	flag.StringVar(&cfg.SessionID, "session-id", "", "*Leave Empty* Unique session identifier (UUID). Used to link uploads to an existing session on the server. Auto generated.")
-	flag.StringVar(&cfg.Tags, "tags", "", "Comma-separated list of tags for organizing or categorizing the recording session (e.g., 'test,debug,build42').")
-	flag.StringVar(&cfg.AppName, "app-name", "<Unassigned>", "Name of the app or game being recorded. Appears in analytics and upload metadata.")
-	flag.StringVar(&cfg.AppVersion, "app-version", "<Unassigned>", "Version of the app being recorded. Use semantic versioning (e.g., '1.0.0').")
-	flag.StringVar(&cfg.Engine, "engine", "<Unassigned>", "What game engine is primarily used to make this game.")
-	flag.StringVar(&cfg.Metadata, "meta-data", "{}", "An alternative way to pass data without breaking older versions. Uses Json format.")
-	flag.IntVar(&cfg.PollSeconds, "poll", defaultPollSeconds, fmt.Sprintf("Interval in seconds between uploader checks for new files to upload. Default: %d", defaultPollSeconds))
-	flag.Parse()
-
-	fmt.Printf("[DEBUG] Parsed flags: %+v\n", cfg)
-
-	var missing []string
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return nil, flagErrorCode(err)
+	}
+	cfg.ExecArgs = fs.Args()
+	command := "record"
 	if cfg.IsLoading {
-		if cfg.OutPath == "" {
-			missing = append(missing, "--out")
-		}
-	} else {
-		if cfg.OutPath == "" {
-			missing = append(missing, "--out")
-		}
-		if cfg.Title == "" {
-			missing = append(missing, "--title")
-		}
+		command = "load"
 	}
-
-	if len(missing) > 0 {
-		fmt.Fprintf(os.Stderr, "missing required flags: %v\n", missing)
-		flag.Usage()
-		os.Exit(2)
+	if err := cfg.validate(command); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		fmt.Fprintln(os.Stderr, "Run `polytube.exe help record` for usage.")
+		return nil, 2
+	}
+	if cfg.IsLoading {
+		return cfg, 0
 	}
 
-	if cfg.SessionID == "" && !cfg.IsLoading {
+	if cfg.SessionID == "" {
 		cfg.SessionID = uuid.New().String()
 		fmt.Printf("Generated new session ID: %s\n", cfg.SessionID)
 	}
 
-	return cfg
+	for _, name := range info.ParseTags(cfg.EventTypes) {
+		_ = models.RegisterEventType(name) // already validated
+	}
+
+	return cfg, 0
+}
+
+// registerUploadFlags defines the storage and upload flags shared by record,
+// upload, upload-pending and doctor.
+func registerUploadFlags(fs *flag.FlagSet, cfg *cliConfig) {
+	fs.StringVar(&cfg.Endpoint, "endpoint", "https://polytube.io", "Upload endpoint URL for cloud storage.")
+	fs.StringVar(&cfg.Storage, "storage", "", "Storage backend URL: empty for polytube.io at --endpoint, s3://bucket/prefix?endpoint=..., file:///C:/dir or put+https://host/path.")
+	fs.StringVar(&cfg.ApiID, "api-id", "", "API ID for authentication when communicating with the upload endpoint.")
+	fs.StringVar(&cfg.ApiKey, "api-key", "", "API Key for authentication when communicating with the upload endpoint.")
+	fs.IntVar(&cfg.MaxUploads, "max-uploads", defaultMaxUploads, "Maximum number of files uploaded at the same time.")
+	fs.IntVar(&cfg.UploadLimit, "upload-limit", 0, "Maximum total upload bandwidth in kilobytes per second, shared by all uploads. 0 means unlimited.")
+	fs.IntVar(&cfg.PartSize, "part-size", defaultPartSizeMB, "Part size in megabytes for multipart uploads. Larger files are uploaded in parts that are retried and resumed individually.")
+	fs.BoolVar(&cfg.ContentMD5, "content-md5", false, "Also compute an MD5 per file and send it as Content-MD5. SHA-256 checksums are always sent.")
+	fs.IntVar(&cfg.SpoolMaxMB, "spool-max-mb", defaultSpoolMaxMB, "Maximum disk space in megabytes for sessions waiting to be uploaded. Oldest sessions are dropped first. 0 means unlimited.")
+	fs.DurationVar(&cfg.SpoolMaxAge, "spool-max-age", defaultSpoolMaxAge, "Drop sessions still waiting to be uploaded after this long (e.g. 72h). 0 means unlimited.")
+}
+
+// validateUploadFlags checks the values of the flags from registerUploadFlags.
+func validateUploadFlags(cfg *cliConfig) error {
+	switch {
+	case cfg.MaxUploads < 1:
+		return fmt.Errorf("--max-uploads must be at least 1 (got %d)", cfg.MaxUploads)
+	case cfg.UploadLimit < 0:
+		return fmt.Errorf("--upload-limit must not be negative (got %d)", cfg.UploadLimit)
+	case cfg.PartSize < 5:
+		return fmt.Errorf("--part-size must be at least 5 MB (got %d)", cfg.PartSize)
+	case cfg.SpoolMaxMB < 0:
+		return fmt.Errorf("--spool-max-mb must not be negative (got %d)", cfg.SpoolMaxMB)
+	case cfg.SpoolMaxAge < 0:
+		return fmt.Errorf("--spool-max-age must not be negative (got %s)", cfg.SpoolMaxAge)
+	}
+	return nil
 }
 
 // startServices initializes loggers, recorder, uploader, and background listeners/poller.
 // It returns a service bundle with a cancellable context controlling all background work.
-func startServices(cfg *cliConfig, dataDir, internalLogPath, eventsPath string, ffmpegPath string) (*serviceBundle, error) {
+func startServices(cfg *cliConfig, dataDir, internalLogPath string, ffmpegPath string, bandwidth *uploader.BandwidthLimiter) (*serviceBundle, error) {
 	// Internal logger first: everything else can log into it.
 	intLog, err := logger.NewLogger(internalLogPath)
 	if err != nil {
@@ -194,51 +515,175 @@ func startServices(cfg *cliConfig, dataDir, internalLogPath, eventsPath string,
 		Logger:     intLog,
 	}
 	sessionInfo.PopulateDeviceInfo(cfg.Engine)
+	metadata, err := info.ParseMetadata(cfg.Metadata) // already validated
+	if err != nil {
+		_ = intLog.Close()
+		return nil, fmt.Errorf("parse --meta-data: %w", err)
+	}
+	sessionInfo.SetMetadata(metadata)
 	intLog.Info(fmt.Sprintf("SessionInfo Populated: %+v", sessionInfo))
 
+	// Upload journal: persists upload progress so the session can be resumed after a crash.
+	// A replay buffer is never uploaded itself, so it gets none.
+	var journal *uploader.Journal
+	if cfg.Buffer == 0 {
+		journal, err = uploader.CreateJournal(dataDir, cfg.SessionID, sessionInfo)
+		if err != nil {
+			intLog.Error(fmt.Sprintf("create upload journal failed: %v", err))
+			_ = intLog.Close()
+			return nil, fmt.Errorf("create upload journal: %w", err)
+		}
+	}
+
 	// =====================
 	// Log session ID
-	intLog.Info(fmt.Sprintf("user inputs: %+v", cfg))
+	intLog.Info(fmt.Sprintf("user inputs: %s", cfg))
 
-	// Structured event logger (ndjson).
-	evLog, err := events.NewParquetEventLogger(eventsPath)
+	// Session manifest: the index of everything recorded into dataDir.
+	man, err := manifest.New(dataDir, cfg.SessionID, sessionInfo, cfg.redacted())
+	if err != nil {
+		intLog.Error(fmt.Sprintf("create session manifest failed: %v", err))
+		_ = intLog.Close()
+		return nil, fmt.Errorf("create session manifest: %w", err)
+	}
+
+	// In replay buffer mode segments are short and only the last few of them
+	// (and of the event files) are kept on disk.
+	segment, bufferSegments := recorder.SegmentDuration, 0
+	if cfg.Buffer > 0 {
+		segment = recorder.BufferSegmentDuration
+		bufferSegments = int((cfg.Buffer + segment - 1) / segment)
+	}
+
+	// Structured event logger, writing every format of --event-sinks. Parquet
+	// gets one file per HLS segment (events_007.parquet next to output_007.ts),
+	// uploaded by the poller as soon as it is complete. A replay buffer keeps
+	// the event files of its segments and of the one being recorded.
+	formats, _ := events.ParseFormats(cfg.EventSinks)     // already validated
+	policy, _ := events.ParseOverflowPolicy(cfg.Overflow) // already validated
+	keepEventFiles := 0
+	if bufferSegments > 0 {
+		keepEventFiles = bufferSegments + 1
+	}
+	sinks, err := events.OpenSinks(dataDir, formats, segment, eventFileMaxBytes, keepEventFiles)
+	if err != nil {
+		intLog.Error(fmt.Sprintf("create event sinks failed: %v", err))
+		_ = intLog.Close()
+		return nil, fmt.Errorf("create event sinks: %w", err)
+	}
+	evLog, err := events.NewEventLogger(events.Options{
+		Policy:       policy,
+		QueueSize:    cfg.QueueSize,
+		BlockTimeout: cfg.BlockWait,
+		SpillPath:    filepath.Join(dataDir, "events_spill.ndjson"),
+		Logger:       intLog,
+	}, sinks...)
 	if err != nil {
 		intLog.Error(fmt.Sprintf("create event logger failed: %v", err))
 		_ = intLog.Close()
 		return nil, fmt.Errorf("create event logger: %w", err)
 	}
-	intLog.Info("Event logger initialized")
+	intLog.Info(fmt.Sprintf("Event logger initialized (%s)", strings.Join(formats, ", ")))
+
+	// Producers log through logTo. In replay buffer mode it watches for the
+	// events that save the buffer.
+	var (
+		logTo events.EventLoggerInterface = evLog
+		buf   *replayBuffer
+	)
+	if cfg.Buffer > 0 {
+		watcher := &buffer.Watcher{
+			EventLogger: evLog,
+			Dir:         dataDir,
+			Segment:     segment,
+			Triggers:    bufferTriggers(cfg.BufferTriggers),
+		}
+		logTo = watcher
+		buf = &replayBuffer{
+			cfg:         cfg,
+			watcher:     watcher,
+			manifest:    man,
+			sessionInfo: sessionInfo,
+			bandwidth:   bandwidth,
+			logger:      intLog,
+		}
+		intLog.Info(fmt.Sprintf("Replay buffer: last %d segment(s) of %s, saved on %s", bufferSegments, segment, strings.Join(append(watcher.Triggers, models.EventTypeBufferSaveRequested.String()), ", ")))
+	}
+
+	// Local IPC endpoint: bind now so a busy port fails the startup.
+	var ipcServer *ipc.Server
+	if cfg.IPCAddr != "" {
+		tokens, err := ipc.ParseTokens(cfg.IPCTokens) // already validated
+		if err != nil {
+			_ = evLog.Close()
+			_ = intLog.Close()
+			return nil, fmt.Errorf("parse --ipc-tokens: %w", err)
+		}
+		ipcServer = &ipc.Server{
+			Addr:        cfg.IPCAddr,
+			Tokens:      tokens,
+			EventLogger: logTo,
+			Logger:      intLog,
+		}
+		if err := ipcServer.Listen(); err != nil {
+			intLog.Error(fmt.Sprintf("start IPC endpoint failed: %v", err))
+			_ = evLog.Close()
+			_ = intLog.Close()
+			return nil, err
+		}
+	}
 
 	// Recorder configured to write HLS into dataDir and log FFmpeg output to internal logger.
 	rec := &recorder.Recorder{
-		Title:       cfg.Title,
-		DirPath:     dataDir,
-		FFmpegPath:  ffmpegPath,
-		Logger:      intLog,
-		EventLogger: evLog,
+		Title:          cfg.Title,
+		DirPath:        dataDir,
+		FFmpegPath:     ffmpegPath,
+		Logger:         intLog,
+		EventLogger:    logTo,
+		Segment:        segment,
+		BufferSegments: bufferSegments,
 	}
 
-	// Uploader: maintains in-memory set of uploaded files; logs into internal logger.
-	upl := &uploader.Uploader{
-		DirPath:             dataDir,
-		EndpointURL:         cfg.Endpoint,
-		ApiID:               cfg.ApiID,
-		ApiKey:              cfg.ApiKey,
-		SessionID:           cfg.SessionID,
-		UploadedFiles:       make(map[string]bool),
-		Logger:              intLog,
-		InternalLogFilePath: internalLogPath,
-		SessionInfo:         sessionInfo,
+	// Uploader: tracks uploaded files in memory and in the journal; logs into internal logger.
+	// None for a replay buffer; the sessions saved from it are uploaded by buf.
+	var upl *uploader.Uploader
+	if buf == nil {
+		storage, err := newStorage(cfg, intLog)
+		if err != nil {
+			intLog.Error(fmt.Sprintf("create storage failed: %v", err))
+			if ipcServer != nil {
+				_ = ipcServer.Close()
+			}
+			_ = evLog.Close()
+			_ = intLog.Close()
+			return nil, fmt.Errorf("create storage: %w", err)
+		}
+		upl = &uploader.Uploader{
+			DirPath:             dataDir,
+			Storage:             storage,
+			SessionID:           cfg.SessionID,
+			UploadedFiles:       make(map[string]bool),
+			Logger:              intLog,
+			InternalLogFilePath: internalLogPath,
+			SessionInfo:         sessionInfo,
+			Journal:             journal,
+			MaxConcurrent:       cfg.MaxUploads,
+			Bandwidth:           bandwidth,
+			PartSize:            int64(cfg.PartSize) * 1024 * 1024,
+			ContentMD5:          cfg.ContentMD5,
+		}
+		intLog.Info("Uploader initialized")
 	}
-	intLog.Info("Uploader initialized")
 
 	// Cancellable context controlling background tasks.
 	ctx, cancel := context.WithCancel(context.Background())
 
 	// Input listener (keyboard/mouse/etc.).
+	markerKey, _ := marker.ParseHotkey(cfg.MarkerKey) // already validated
 	mnkInputListener := &input.MNKInputListener{
-		EventLogger: evLog,
-		Logger:      intLog,
+		EventLogger:  logTo,
+		Logger:       intLog,
+		MarkerHotkey: markerKey,
 	}
 	go func() {
 		intLog.Info("Input listener starting")
@@ -247,9 +692,11 @@ func startServices(cfg *cliConfig, dataDir, internalLogPath, eventsPath string,
 	}()
 
 	// Gamepad input listener.
+	markerCombo, _ := marker.ParseCombo(cfg.MarkerCombo, gamepadButtons()) // already validated
 	ginp := &input.GamepadInputListener{
-		EventLogger: evLog,
+		EventLogger: logTo,
 		Logger:      intLog,
+		MarkerCombo: markerCombo,
 	}
 	go func() {
 		intLog.Info("Input listener starting")
@@ -259,7 +706,7 @@ func startServices(cfg *cliConfig, dataDir, internalLogPath, eventsPath string,
 
 	// Console listener (stdin lines => events).
 	con := &console.ConsoleListener{
-		EventLogger: evLog,
+		EventLogger: logTo,
 		Logger:      intLog,
 	}
 	go func() {
@@ -268,36 +715,50 @@ func startServices(cfg *cliConfig, dataDir, internalLogPath, eventsPath string,
 		intLog.Info("Console listener stopped")
 	}()
 
-	// Uploader poller: periodically upload .ts segments as they appear.
-	go func(poll int) {
-		intLog.Info(fmt.Sprintf("Uploader poller starting (interval=%ds)", poll))
-		ticker := time.NewTicker(time.Duration(poll) * time.Second)
+	// IPC endpoint (localhost HTTP => events).
+	if ipcServer != nil {
+		go func() {
+			intLog.Info("IPC endpoint starting")
+			ipcServer.Serve(ctx)
+			intLog.Info("IPC endpoint stopped")
+		}()
+	}
 
-		intLog.Info("Starting session info...")
-		upl.StartSessionInfo()
+	// Watchdog: supervise the game process owning the recorded window, once
+	// the window was found and the recording started.
+	dog := &watchdog.Watchdog{
+		EventLogger: logTo,
+		Logger:      intLog,
+		HangAfter:   cfg.HangTimeout,
+		DumpDirs:    crashDumpDirs(cfg.CrashDumps),
+	}
+	if cfg.HangTimeout == 0 {
+		dog.HangAfter = -1 // disabled
+	}
 
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ctx.Done():
-				intLog.Info("Uploader poller stopping (context canceled)")
-				return
-			case <-ticker.C:
-				upl.UploadTS()
-			}
-		}
-	}(cfg.PollSeconds)
+	// Replay buffer: save it when triggered. It is never uploaded itself.
+	if buf != nil {
+		go func() {
+			intLog.Info("Replay buffer watcher starting")
+			buf.run(ctx)
+			intLog.Info("Replay buffer watcher stopped")
+		}()
+	}
 
 	return &serviceBundle{
 		ctx:                  ctx,
 		cancel:               cancel,
 		rec:                  rec,
 		upl:                  upl,
+		manifest:             man,
 		eventLogger:          evLog,
+		logTo:                logTo,
 		internalLogger:       intLog,
 		mnkInputListener:     mnkInputListener,
 		gamepadInputListener: ginp,
 		consoleListener:      con,
+		buffer:               buf,
+		watchdog:             dog,
 	}, nil
 }
 
@@ -305,10 +766,11 @@ func startServices(cfg *cliConfig, dataDir, internalLogPath, eventsPath string,
 //
 // 1) Cancel background goroutines
 // 2) Close event logger
-// 3) Close internal logger
-// 4) Upload remaining files (skip internal log)
-// 5) Upload internal log last
-// 6) Wait for all uploads to finish
+// 3) In replay buffer mode, save the buffer if still due and upload the saved sessions
+// 4) If the recording never started, upload nothing
+// 5) Update the session manifest, end the session and upload remaining files (skip internal log)
+// 6) Close internal logger
+// 7) Upload internal log last, complete the journal if all was delivered, write the final manifest
 func shutdown(svcs *serviceBundle) error {
 	var firstErr error
 	catch := func(err error) {
@@ -330,19 +792,38 @@ func shutdown(svcs *serviceBundle) error {
 		svcs.internalLogger.Info("Event logger closed")
 	}
 
-	// 3) upload all remaining non-log files
-	if svcs.upl != nil {
+	// 3) replay buffer: the saved sessions are uploaded before the internal log closes
+	if svcs.buffer != nil {
+		svcs.buffer.finish()
+	}
+
+	// 4) nothing was recorded (e.g. the window never appeared): no remote session
+	// is created, and the completed journal lets the next run clean it up.
+	upl := svcs.upl
+	if upl != nil && !svcs.uploading {
+		svcs.internalLogger.Info("Recording never started; session not uploaded")
+		if err := upl.Journal.MarkCompleted(); err != nil {
+			svcs.internalLogger.Warn(fmt.Sprintf("update upload journal failed: %v", err))
+		}
+		upl = nil
+	}
+
+	// 5) upload all remaining non-log files, starting with an up-to-date manifest
+	if upl != nil {
+		saveManifest(svcs.manifest, upl.Journal, svcs.internalLogger)
+
 		svcs.internalLogger.Info("Ending session info...")
-		svcs.upl.EndSessionInfo()
+		upl.EndSessionInfo()
 
 		svcs.internalLogger.Info("Uploading remaining non-log files...")
-		svcs.upl.UploadRemaining()
+		upl.UploadRemaining()
 		// Wait for all non-log uploads to finish
-		svcs.upl.WG.Wait()
+		upl.WG.Wait()
 		svcs.internalLogger.Info("All non-log uploads finished")
+		upl.LogSummary()
 	}
 
-	// 4) close internal logger AFTER all other uploads
+	// 6) close internal logger AFTER all other uploads
 	if svcs.internalLogger != nil {
 		svcs.internalLogger.Info("Closing Internal Logger. *EXPECTED EXIT*")
 		if err := svcs.internalLogger.Close(); err != nil {
@@ -351,16 +832,189 @@ func shutdown(svcs *serviceBundle) error {
 		}
 	}
 
-	// 5) upload the internal log file last
-	if svcs.upl != nil {
-		svcs.upl.UploadLogFile()
+	// 7) upload the internal log file last
+	if upl != nil {
+		upl.UploadLogFile()
 		// Wait again for the log file upload to complete
-		svcs.upl.WG.Wait()
+		upl.WG.Wait()
+		if !upl.FinishSession() {
+			fmt.Printf("Session %s not fully uploaded; spooled in %s for the next run or `upload-pending`\n", upl.SessionID, upl.DirPath)
+		}
+		// The internal logger is closed; errors go to stderr.
+		saveManifest(svcs.manifest, upl.Journal, nil)
 	}
 
 	return firstErr
 }
 
+// saveManifest refreshes the session manifest from the playlist and the upload
+// journal and writes it. Failures are logged to log, or to stderr if log is nil.
+func saveManifest(m *manifest.Manifest, journal *uploader.Journal, log logger.LoggerInterface) {
+	err := m.Refresh(journal)
+	if err == nil {
+		err = m.Save()
+	}
+	switch {
+	case err == nil:
+	case log != nil:
+		log.Warn(fmt.Sprintf("update session manifest failed: %v", err))
+	default:
+		fmt.Fprintf(os.Stderr, "update session manifest failed: %v\n", err)
+	}
+}
+
+// resumeUnfinishedSessions uploads every session directory under baseDir whose
+// journal is not completed, reusing the session's original ID and SessionInfo.
+func resumeUnfinishedSessions(cfg *cliConfig, baseDir string, bandwidth *uploader.BandwidthLimiter) {
+	dirs, err := uploader.FindUnfinishedSessions(baseDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to find unfinished sessions: %v\n", err)
+		return
+	}
+	for _, dir := range dirs {
+		if err := resumeSession(cfg, dir, bandwidth); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to resume session in %s: %v\n", dir, err)
+		}
+	}
+}
+
+// resumeSession runs the upload half of the shutdown sequence for an interrupted session.
+// The session's internal log is reopened in append mode and uploaded last.
+func resumeSession(cfg *cliConfig, dir string, bandwidth *uploader.BandwidthLimiter) error {
+	journal, err := uploader.LoadJournal(dir)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Resuming upload of unfinished session %s\n", journal.SessionID)
+	done, err := deliverSession(cfg, dir, journal, bandwidth, nil)
+	if err != nil {
+		return err
+	}
+	if done {
+		fmt.Printf("Session %s fully uploaded\n", journal.SessionID)
+	} else {
+		fmt.Printf("Session %s still has pending uploads; will retry on next launch\n", journal.SessionID)
+	}
+	return nil
+}
+
+// deliverSession creates and ends the session described by journal, uploads
+// every file in dir with the internal log last, and reports whether
+// everything was delivered. onFileDone, if set, is called after each file.
+func deliverSession(cfg *cliConfig, dir string, journal *uploader.Journal, bandwidth *uploader.BandwidthLimiter, onFileDone func(string, uploader.FileResult)) (bool, error) {
+	internalLogPath := filepath.Join(dir, "internal.log")
+	intLog, err := logger.NewLogger(internalLogPath)
+	if err != nil {
+		return false, fmt.Errorf("open internal logger: %w", err)
+	}
+	intLog.Info(fmt.Sprintf("Uploading session %s from %s", journal.SessionID, dir))
+
+	storage, err := newStorage(cfg, intLog)
+	if err != nil {
+		_ = intLog.Close()
+		return false, fmt.Errorf("create storage: %w", err)
+	}
+	upl := &uploader.Uploader{
+		DirPath:             dir,
+		Storage:             storage,
+		SessionID:           journal.SessionID,
+		UploadedFiles:       make(map[string]bool),
+		Logger:              intLog,
+		InternalLogFilePath: internalLogPath,
+		SessionInfo:         journal.SessionInfo,
+		Journal:             journal,
+		MaxConcurrent:       cfg.MaxUploads,
+		Bandwidth:           bandwidth,
+		PartSize:            int64(cfg.PartSize) * 1024 * 1024,
+		ContentMD5:          cfg.ContentMD5,
+		OnFileDone:          onFileDone,
+	}
+
+	man, _ := manifest.Load(dir) // nil for sessions recorded before session.json existed
+	if man != nil {
+		saveManifest(man, journal, intLog)
+	}
+
+	upl.StartSessionInfo()
+	upl.EndSessionInfo()
+	upl.UploadRemaining()
+	upl.WG.Wait()
+	upl.LogSummary()
+
+	intLog.Info("Closing Internal Logger. *EXPECTED EXIT*")
+	if err := intLog.Close(); err != nil {
+		return false, fmt.Errorf("close internal logger: %w", err)
+	}
+
+	upl.UploadLogFile()
+	upl.WG.Wait()
+
+	done := upl.FinishSession()
+	if man != nil {
+		saveManifest(man, journal, nil)
+	}
+	return done, nil
+}
+
+// newStorage builds the upload backend selected by --storage (polytube.io at
+// --endpoint by default), logging into log.
+func newStorage(cfg *cliConfig, log logger.LoggerInterface) (uploader.Storage, error) {
+	return uploader.NewStorage(cfg.Storage, uploader.StorageOptions{
+		Endpoint: cfg.Endpoint,
+		ApiID:    cfg.ApiID,
+		ApiKey:   cfg.ApiKey,
+		Logger:   log,
+	})
+}
+
+// enforceSpoolQuota drops spooled sessions beyond --spool-max-mb/--spool-max-age,
+// never touching the session directory keep.
+func enforceSpoolQuota(cfg *cliConfig, baseDir, keep string) {
+	quota := uploader.SpoolQuota{
+		MaxBytes: int64(cfg.SpoolMaxMB) * 1024 * 1024,
+		MaxAge:   cfg.SpoolMaxAge,
+	}
+	removed, err := uploader.EnforceSpoolQuota(baseDir, quota, keep)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to enforce spool quota: %v\n", err)
+	}
+	for _, s := range removed {
+		fmt.Printf("Dropped spooled session %s (%d MB, created %s): over spool quota\n", s.SessionID, s.Size/(1024*1024), s.CreatedAt.Format(time.RFC3339))
+	}
+}
+
+// cleanDataDir removes what earlier runs left in baseDir except the session
+// directories in keep: delivered sessions (their journal is completed), folders
+// without a recording and stray files. A folder with a recording but no journal
+// is kept, since it can still be uploaded with the upload command.
+func cleanDataDir(baseDir string, keep []string) error {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		return fmt.Errorf("read dir: %w", err)
+	}
+
+	kept := make(map[string]bool, len(keep))
+	for _, dir := range keep {
+		kept[filepath.Clean(dir)] = true
+	}
+
+	for _, entry := range entries {
+		entryPath := filepath.Join(baseDir, entry.Name())
+		if kept[filepath.Clean(entryPath)] {
+			continue
+		}
+		if entry.IsDir() && isSessionDir(entryPath) {
+			if j, err := uploader.LoadJournal(entryPath); err != nil || !j.Completed {
+				continue
+			}
+		}
+		if err := os.RemoveAll(entryPath); err != nil {
+			return fmt.Errorf("remove %s: %w", entryPath, err)
+		}
+	}
+	return nil
+}
+
 func ensureAndWipeDir(path string) error {
 	// Ensure directory exists
 	if err := os.MkdirAll(path, 0o755); err != nil {
@@ -0,0 +1,258 @@
+//go:build windows
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/manifest"
+	"polytube/replay/internal/uploader"
+)
+
+// runUpload implements `polytube.exe upload`: it uploads a previously recorded
+// directory, e.g. one recorded offline and copied to another machine.
+//
+// The directory may be a session directory (<out>/data/<session-id>), a folder
+// holding the recording files directly, or an --out directory, in which case
+// every session under <out>/data is uploaded. A session's SessionInfo and ID
+// come from its upload journal; folders without one get a new journal built
+// from the flags. It returns the process exit code.
+func runUpload(args []string) int {
+	cfg := &cliConfig{}
+	fs := flag.NewFlagSet("upload", flag.ContinueOnError)
+	registerConfigFlag(fs, cfg)
+	fs.StringVar(&cfg.OutPath, "out", "", "Recorded directory to upload: an --out directory or a single session directory.")
+	fs.StringVar(&cfg.SessionID, "session-id", "", "Session ID for a folder without an upload journal. Auto generated if empty.")
+	fs.StringVar(&cfg.Tags, "tags", "", "Tags for a folder without an upload journal.")
+	fs.StringVar(&cfg.AppName, "app-name", "<Unassigned>", "App name for a folder without an upload journal.")
+	fs.StringVar(&cfg.AppVersion, "app-version", "<Unassigned>", "App version for a folder without an upload journal.")
+	fs.StringVar(&cfg.Engine, "engine", "<Unassigned>", "Engine for a folder without an upload journal.")
+	fs.StringVar(&cfg.Metadata, "meta-data", "{}", "JSON metadata for a folder without an upload journal.")
+	registerUploadFlags(fs, cfg)
+	setCommandUsage(fs, "upload --out <dir> [flags]")
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return flagErrorCode(err)
+	}
+
+	if cfg.OutPath == "" && fs.NArg() == 1 {
+		cfg.OutPath = fs.Arg(0)
+	}
+	if err := cfg.validate("upload"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	storage, err := newStorage(cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --storage: %v\n", err)
+		return 2
+	}
+	if err := storage.Ready(); err != nil {
+		fmt.Fprintf(os.Stderr, "storage not configured: %v\n", err)
+		return 2
+	}
+
+	dirs, err := findRecordedSessions(cfg.OutPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n", err)
+		return 1
+	}
+	if len(dirs) > 1 && cfg.SessionID != "" {
+		fmt.Fprintln(os.Stderr, "--session-id can only be used when uploading a single session")
+		return 2
+	}
+
+	if err := uploader.CheckConnectivity(storage); err != nil {
+		fmt.Fprintf(os.Stderr, "storage unreachable: %v\n", err)
+		return 1
+	}
+
+	bandwidth := uploader.NewBandwidthLimiter(int64(cfg.UploadLimit) * 1024)
+	exit := 0
+	for _, dir := range dirs {
+		if !uploadRecordedSession(cfg, dir, bandwidth) {
+			exit = 1
+		}
+	}
+	return exit
+}
+
+// uploadRecordedSession uploads one session directory with progress output and
+// reports whether everything was delivered.
+func uploadRecordedSession(cfg *cliConfig, dir string, bandwidth *uploader.BandwidthLimiter) bool {
+	journal, err := uploader.LoadJournal(dir)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		journal, err = newUploadJournal(cfg, dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create upload journal in %s: %v\n", dir, err)
+			return false
+		}
+	case err != nil:
+		fmt.Fprintf(os.Stderr, "failed to read upload journal in %s: %v\n", dir, err)
+		return false
+	}
+	if journal.Completed {
+		fmt.Printf("Session %s in %s was already uploaded\n", journal.SessionID, dir)
+		return true
+	}
+
+	progress := newUploadProgress(dir, journal)
+	fmt.Printf("Uploading session %s from %s (%d file(s), %.1f MB)\n",
+		journal.SessionID, dir, progress.totalFiles, megabytes(progress.totalBytes))
+
+	start := time.Now()
+	done, err := deliverSession(cfg, dir, journal, bandwidth, progress.fileDone)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to upload session %s: %v\n", journal.SessionID, err)
+		return false
+	}
+
+	fmt.Printf("Uploaded %d/%d file(s), %.1f MB in %s\n",
+		progress.uploaded, progress.totalFiles, megabytes(progress.uploadedBytes), time.Since(start).Round(time.Second))
+	if !done {
+		fmt.Printf("Session %s still has pending uploads; run the command again to retry\n", journal.SessionID)
+	}
+	return done
+}
+
+// newUploadJournal creates a journal for a folder recorded without one, with a
+// SessionInfo built from the flags. Device fields are left empty because this
+// machine is not necessarily the one that recorded.
+func newUploadJournal(cfg *cliConfig, dir string) (*uploader.Journal, error) {
+	sessionID := cfg.SessionID
+	if sessionID == "" {
+		sessionID = uuid.New().String()
+	}
+	appName, appVersion, engine := cfg.AppName, cfg.AppVersion, cfg.Engine
+	sessionInfo := info.SessionInfo{
+		AppName:    &appName,
+		AppVersion: &appVersion,
+		Tags:       info.ParseTags(cfg.Tags),
+		Engine:     &engine,
+	}
+	metadata, err := info.ParseMetadata(cfg.Metadata)
+	if err != nil {
+		return nil, fmt.Errorf("parse --meta-data: %w", err)
+	}
+	sessionInfo.SetMetadata(metadata)
+	fmt.Printf("No upload journal in %s; uploading as new session %s\n", dir, sessionID)
+	return uploader.CreateJournal(dir, sessionID, sessionInfo)
+}
+
+// findRecordedSessions resolves path to the session directories to upload.
+func findRecordedSessions(path string) ([]string, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %w", path, err)
+	}
+	if !fi.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", path)
+	}
+	if isSessionDir(path) {
+		return []string{path}, nil
+	}
+
+	dataDir := filepath.Join(path, "data")
+	if isSessionDir(dataDir) {
+		// Recorded by a version that wrote straight into <out>/data.
+		return []string{dataDir}, nil
+	}
+	entries, err := os.ReadDir(dataDir)
+	if err != nil {
+		return nil, fmt.Errorf("no recording found in %s", path)
+	}
+	var dirs []string
+	for _, entry := range entries {
+		dir := filepath.Join(dataDir, entry.Name())
+		if entry.IsDir() && isSessionDir(dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, fmt.Errorf("no recording found in %s", path)
+	}
+	return dirs, nil
+}
+
+// isSessionDir reports whether dir holds a recording: an upload journal, a
+// session manifest, a playlist, segments, events or an internal log.
+func isSessionDir(dir string) bool {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return false
+	}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+		name := entry.Name()
+		switch {
+		case name == uploader.JournalFileName, name == manifest.FileName, name == "internal.log":
+			return true
+		case strings.HasSuffix(name, ".m3u8"), strings.HasSuffix(name, ".ts"), strings.HasSuffix(name, ".parquet"):
+			return true
+		}
+	}
+	return false
+}
+
+// uploadProgress prints one line per finished file.
+type uploadProgress struct {
+	mu            sync.Mutex
+	totalFiles    int
+	totalBytes    int64
+	finished      int
+	uploaded      int
+	uploadedBytes int64
+}
+
+// newUploadProgress counts the files in dir that still need uploading.
+func newUploadProgress(dir string, journal *uploader.Journal) *uploadProgress {
+	p := &uploadProgress{}
+	entries, _ := os.ReadDir(dir)
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), uploader.JournalFileName) {
+			continue
+		}
+		fi, err := entry.Info()
+		if err != nil || journal.IsDone(entry.Name(), fi.Size(), fi.ModTime()) {
+			continue
+		}
+		p.totalFiles++
+		p.totalBytes += fi.Size()
+	}
+	return p
+}
+
+func (p *uploadProgress) fileDone(path string, r uploader.FileResult) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.finished++
+	size := int64(0)
+	if fi, err := os.Stat(path); err == nil {
+		size = fi.Size()
+	}
+	if r.Status == uploader.FileStatusFailed {
+		fmt.Printf("[%d/%d] %s FAILED after %d attempt(s): %v\n", p.finished, p.totalFiles, filepath.Base(path), r.Attempts, r.Err)
+		return
+	}
+	p.uploaded++
+	p.uploadedBytes += size
+	fmt.Printf("[%d/%d] %s uploaded (%.1f MB, %.1f/%.1f MB total)\n",
+		p.finished, p.totalFiles, filepath.Base(path), megabytes(size), megabytes(p.uploadedBytes), megabytes(p.totalBytes))
+}
+
+func megabytes(n int64) float64 {
+	return float64(n) / (1024 * 1024)
+}
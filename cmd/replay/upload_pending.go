@@ -0,0 +1,75 @@
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"polytube/replay/internal/uploader"
+)
+
+// runUploadPending implements `polytube.exe upload-pending`: it drains the
+// sessions spooled under <out>/data without recording anything. It returns
+// the process exit code: 0 when the spool is empty afterwards, 1 otherwise.
+func runUploadPending(args []string) int {
+	cfg := &cliConfig{}
+	fs := flag.NewFlagSet("upload-pending", flag.ContinueOnError)
+	registerConfigFlag(fs, cfg)
+	fs.StringVar(&cfg.OutPath, "out", "", "Directory passed as --out to the recordings whose sessions should be uploaded.")
+	registerUploadFlags(fs, cfg)
+	setCommandUsage(fs, "upload-pending --out <dir> [upload flags]")
+	if err := parseConfig(fs, cfg, args); err != nil {
+		return flagErrorCode(err)
+	}
+
+	if err := cfg.validate("upload-pending"); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	baseDataDir := filepath.Join(cfg.OutPath, "data")
+	storage, err := newStorage(cfg, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid --storage: %v\n", err)
+		return 2
+	}
+	if err := storage.Ready(); err != nil {
+		fmt.Fprintf(os.Stderr, "storage not configured: %v\n", err)
+		return 2
+	}
+
+	enforceSpoolQuota(cfg, baseDataDir, "")
+	pending, err := uploader.ListPendingSessions(baseDataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to scan data directory: %v\n", err)
+		return 1
+	}
+	if len(pending) == 0 {
+		fmt.Println("No pending sessions.")
+		return 0
+	}
+	fmt.Printf("%d pending session(s) in %s\n", len(pending), baseDataDir)
+
+	if err := uploader.CheckConnectivity(storage); err != nil {
+		fmt.Fprintf(os.Stderr, "storage unreachable, sessions stay spooled: %v\n", err)
+		return 1
+	}
+
+	bandwidth := uploader.NewBandwidthLimiter(int64(cfg.UploadLimit) * 1024)
+	resumeUnfinishedSessions(cfg, baseDataDir, bandwidth)
+
+	left, err := uploader.FindUnfinishedSessions(baseDataDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to scan data directory: %v\n", err)
+		return 1
+	}
+	if len(left) > 0 {
+		fmt.Printf("%d session(s) still pending\n", len(left))
+		return 1
+	}
+	fmt.Println("All pending sessions uploaded.")
+	return 0
+}
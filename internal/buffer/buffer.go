@@ -0,0 +1,139 @@
+// Package buffer implements the replay buffer mode of the recorder ("save the
+// last N minutes"). Instead of keeping and uploading the whole session, the
+// recorder keeps only the last few minutes of HLS segments on disk and the
+// event logger only the matching events_###.parquet files. Nothing is uploaded
+// until something worth keeping happens: an event of a trigger type such as
+// a MARKER, a crash, or an explicit BUFFER_SAVE_REQUESTED ("/save-buffer" on
+// stdin or the IPC endpoint). Save then copies the buffer into a new,
+// self-contained session directory that is uploaded like any other session.
+//
+// Segment N of the buffer covers the same time as events window N (see
+// events.NewParquetSink), so the events of a saved buffer are the ones of its
+// segments, with SessionMs rebased to the first saved segment.
+package buffer
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// NewSaveRequest returns a BUFFER_SAVE_REQUESTED event with an optional note.
+func NewSaveRequest(note string, source models.EventSource) models.Event {
+	return models.Event{
+		Timestamp:  utils.NowEpochSeconds(),
+		EventType:  models.EventTypeBufferSaveRequested.String(),
+		EventLevel: models.EventLevelLog.String(),
+		Content:    strings.TrimSpace(note),
+		Value:      0,
+		Source:     string(source),
+	}
+}
+
+// settle is how long after the end of a segment Ready waits for its events
+// file, which the event logger finishes at its next flush (every second).
+const settle = 2 * time.Second
+
+// Watcher passes events on to EventLogger and collects the events of trigger
+// types, so the buffer can be saved once the video covering them is on disk.
+// It is an events.EventLoggerInterface, so it is handed to the listeners in
+// place of the event logger.
+type Watcher struct {
+	EventLogger events.EventLoggerInterface
+	Dir         string        // buffer directory the recorder writes to
+	Segment     time.Duration // HLS segment length of the buffer
+	Triggers    []string      // event types that save the buffer, besides BUFFER_SAVE_REQUESTED
+
+	mu      sync.Mutex
+	started float64        // timestamp of RECORDING_STARTED; zero before it
+	pending []models.Event // triggers not saved yet
+}
+
+// LogEvent logs e and remembers it if it triggers a save.
+func (w *Watcher) LogEvent(e models.Event) {
+	w.EventLogger.LogEvent(e)
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	switch {
+	case e.EventType == models.EventTypeRecordingStarted.String():
+		w.started = e.Timestamp
+	case w.isTrigger(e.EventType):
+		w.pending = append(w.pending, e)
+	}
+}
+
+// Close closes EventLogger.
+func (w *Watcher) Close() error {
+	return w.EventLogger.Close()
+}
+
+// isTrigger reports whether events of type t save the buffer.
+func (w *Watcher) isTrigger(t string) bool {
+	if t == models.EventTypeBufferSaveRequested.String() {
+		return true
+	}
+	for _, trigger := range w.Triggers {
+		if trigger == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Ready returns the triggers not saved yet once the segment and events file
+// covering the last of them are complete, and forgets them. With ended set
+// (the recording stopped, so every segment is complete) it returns them
+// right away. It returns nil while there is nothing to save yet.
+func (w *Watcher) Ready(ended bool) []models.Event {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.pending) == 0 {
+		return nil
+	}
+	if !ended {
+		last := w.pending[len(w.pending)-1]
+		segment := w.segmentOf(last.Timestamp)
+		segmentEnd := time.Duration(segment+1) * w.Segment
+		if w.started == 0 || w.sessionTime(utils.NowEpochSeconds()) < segmentEnd+settle {
+			return nil
+		}
+		entries, err := readPlaylist(w.Dir)
+		if err != nil || len(entries) == 0 || entries[len(entries)-1].Sequence < segment {
+			return nil
+		}
+	}
+	triggers := w.pending
+	w.pending = nil
+	return triggers
+}
+
+// segmentOf returns the segment recorded at timestamp ts.
+func (w *Watcher) segmentOf(ts float64) int {
+	if w.started == 0 || ts <= w.started {
+		return 0
+	}
+	return int(w.sessionTime(ts) / w.Segment)
+}
+
+// sessionTime returns the time from RECORDING_STARTED to timestamp ts.
+func (w *Watcher) sessionTime(ts float64) time.Duration {
+	return time.Duration((ts - w.started) * float64(time.Second))
+}
+
+// TriggerNames returns the distinct types of triggers, in order, for
+// describing why a buffer was saved.
+func TriggerNames(triggers []models.Event) []string {
+	var names []string
+	seen := make(map[string]bool)
+	for _, t := range triggers {
+		if !seen[t.EventType] {
+			seen[t.EventType] = true
+			names = append(names, t.EventType)
+		}
+	}
+	return names
+}
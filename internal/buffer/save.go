@@ -0,0 +1,223 @@
+package buffer
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// PlaylistFile is the HLS playlist of a buffer and of a saved buffer.
+const PlaylistFile = "playlist.m3u8"
+
+// SaveOptions configures Save.
+type SaveOptions struct {
+	Dir     string        // buffer directory the recorder writes to
+	Dest    string        // session directory to create
+	Segment time.Duration // HLS segment length of the buffer
+}
+
+// Saved describes a saved buffer.
+type Saved struct {
+	Segments int           // video segments copied
+	Events   int           // events copied
+	Start    time.Duration // session time of the first segment in the recording
+	Duration time.Duration // length of the video
+}
+
+// Save copies the buffer in opts.Dir into opts.Dest as a session of its own:
+// the segments listed in the playlist, renumbered from output_000.ts, a
+// finished (VOD) playlist, and the events of those segments in rolled
+// events_###.parquet files with SessionMs counted from the first segment.
+// When the recording's RECORDING_STARTED has already left the buffer, one is
+// added at the start, with the original session time of the buffer start in
+// its fields as "buffer_start_ms".
+//
+// The recorder keeps running while Save copies, so a segment deleted before it
+// was copied is skipped.
+func Save(opts SaveOptions) (Saved, error) {
+	if opts.Dir == "" || opts.Dest == "" || opts.Segment <= 0 {
+		return Saved{}, errors.New("buffer: Dir, Dest and Segment are required")
+	}
+	entries, err := readPlaylist(opts.Dir)
+	if err != nil {
+		return Saved{}, fmt.Errorf("read buffer playlist: %w", err)
+	}
+	if err := os.MkdirAll(opts.Dest, 0o755); err != nil {
+		return Saved{}, fmt.Errorf("create %s: %w", opts.Dest, err)
+	}
+
+	var copied []playlistEntry
+	for _, e := range entries {
+		name := fmt.Sprintf("output_%03d.ts", len(copied))
+		err := utils.CopyFile(filepath.Join(opts.Dir, e.File), filepath.Join(opts.Dest, name))
+		if errors.Is(err, os.ErrNotExist) && len(copied) == 0 {
+			continue // dropped out of the buffer meanwhile
+		}
+		if err != nil {
+			return Saved{}, fmt.Errorf("copy %s: %w", e.File, err)
+		}
+		copied = append(copied, playlistEntry{File: name, Sequence: e.Sequence, Duration: e.Duration})
+	}
+	if len(copied) == 0 {
+		return Saved{}, errors.New("buffer is empty: no segments recorded yet")
+	}
+	if err := writePlaylist(filepath.Join(opts.Dest, PlaylistFile), copied); err != nil {
+		return Saved{}, fmt.Errorf("write playlist: %w", err)
+	}
+
+	// The recorder forces a keyframe on every segment boundary, so segment N
+	// starts at N*Segment; the end follows from the real segment durations.
+	saved := Saved{
+		Segments: len(copied),
+		Start:    time.Duration(copied[0].Sequence) * opts.Segment,
+	}
+	for _, e := range copied {
+		saved.Duration += time.Duration(e.Duration * float64(time.Second))
+	}
+	end := saved.Start + saved.Duration
+
+	list, err := readEvents(opts.Dir)
+	if err != nil {
+		return saved, fmt.Errorf("read buffer events: %w", err)
+	}
+	list = rebase(list, saved.Start, end)
+	saved.Events = len(list)
+	if err := writeEvents(opts.Dest, opts.Segment, list); err != nil {
+		return saved, fmt.Errorf("write events: %w", err)
+	}
+	return saved, nil
+}
+
+// rebase returns the events of list from start to end of the recording, with
+// SessionMs counted from start, and a RECORDING_STARTED at the beginning if
+// list lacks one.
+func rebase(list []models.Event, start, end time.Duration) []models.Event {
+	startMs, endMs := start.Milliseconds(), end.Milliseconds()
+	var out []models.Event
+	hasStart := false
+	for _, e := range list {
+		if e.SessionMs >= endMs || (e.SessionMs < startMs && startMs > 0) {
+			continue
+		}
+		hasStart = hasStart || e.EventType == models.EventTypeRecordingStarted.String()
+		e.SessionMs -= startMs
+		out = append(out, e)
+	}
+	if hasStart || len(list) == 0 {
+		return out
+	}
+	// The timestamps of all events share the anchor of their SessionMs.
+	anchor := list[0].Timestamp - float64(list[0].SessionMs)/1000
+	started := models.Event{
+		Timestamp:  anchor + float64(startMs)/1000,
+		EventType:  models.EventTypeRecordingStarted.String(),
+		Attributes: fmt.Sprintf(`{"buffer_start_ms":%d}`, startMs),
+		Source:     string(models.EventSourceSystem),
+	}
+	return append([]models.Event{started}, out...)
+}
+
+// readEvents reads the finished event files of the buffer. Files deleted
+// while reading (they dropped out of the buffer) are skipped.
+func readEvents(dir string) ([]models.Event, error) {
+	files, err := events.SessionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	var list []models.Event
+	for _, f := range files {
+		fileEvents, err := events.ReadFile(f)
+		if err != nil {
+			if _, statErr := os.Stat(f); os.IsNotExist(statErr) {
+				continue
+			}
+			return list, err
+		}
+		list = append(list, fileEvents...)
+	}
+	return list, nil
+}
+
+// writeEvents writes list to rolled event files in dir, one per segment.
+func writeEvents(dir string, segment time.Duration, list []models.Event) error {
+	sink, err := events.NewParquetSink(filepath.Join(dir, events.ParquetFile), segment, 0, 0)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, e := range list {
+		if err := sink.Write(e); err != nil {
+			errs = append(errs, err)
+			break
+		}
+	}
+	return errors.Join(append(errs, sink.Close())...)
+}
+
+// playlistEntry is one segment of an HLS media playlist.
+type playlistEntry struct {
+	File     string
+	Sequence int     // media sequence number; segment N of the recording
+	Duration float64 // seconds
+}
+
+// readPlaylist returns the segments listed in the playlist of dir.
+func readPlaylist(dir string) ([]playlistEntry, error) {
+	f, err := os.Open(filepath.Join(dir, PlaylistFile))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		entries  []playlistEntry
+		sequence int
+		duration float64
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#"):
+		default:
+			entries = append(entries, playlistEntry{File: filepath.Base(line), Sequence: sequence, Duration: duration})
+			sequence++
+			duration = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// writePlaylist writes a finished HLS playlist of entries.
+func writePlaylist(path string, entries []playlistEntry) error {
+	target := 0.0
+	for _, e := range entries {
+		target = max(target, math.Ceil(e.Duration))
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-MEDIA-SEQUENCE:0\n#EXT-X-PLAYLIST-TYPE:VOD\n", int(target))
+	for _, e := range entries {
+		fmt.Fprintf(&b, "#EXTINF:%f,\n%s\n", e.Duration, e.File)
+	}
+	b.WriteString("#EXT-X-ENDLIST\n")
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
@@ -1,44 +1,61 @@
-// Package console reads piped stdin lines and logs them as "console" events
-// into the NDJSON event log.
+// Package console reads piped stdin lines and logs them as events into the
+// event log. Lines are JSON objects describing an event (type, level, content,
+// value, timestamp, custom fields) or plain text; see ParseLine. The stdout
+// and stderr of a game started with --exec are read the same way.
 //
 // Example usage:
 //
 //	some_game.exe | replay.exe --title "Game" --out "C:\output" ...
 //
-// Example logged event:
+// Example lines:
 //
-//	{"type":"console","timestamp":"2025-10-04T15:00:00Z","payload":"Player joined"}
+//	Player joined
+//	WARNING: shader compile took 212ms
+//	{"type":"level loaded","content":"forest_01","value":3.2,"fields":{"seed":"81723"}}
 package console
 
 import (
 	"bufio"
 	"context"
 	"fmt"
+	"io"
 	"os"
 	"strings"
 
 	"polytube/replay/internal/events"
 	"polytube/replay/internal/logger"
-	"polytube/replay/pkg/models"
-	"polytube/replay/utils"
 )
 
 // ConsoleListener reads stdin lines and logs them as events.
 type ConsoleListener struct {
 	EventLogger events.EventLoggerInterface
 	Logger      logger.LoggerInterface
+	Input       io.Reader // lines to read; nil reads stdin
+	Name        string    // of Input in the internal log; "stdin" if empty
+	Stderr      bool      // Input is an error stream; see ParseErrorLine
 }
 
 // Start blocks and reads from stdin until the context is canceled.
-// Each non-empty line becomes an event of type "console".
+// Each non-empty line becomes one event (see ParseLine).
 func (c *ConsoleListener) Start(ctx context.Context) {
 	if c.EventLogger == nil || c.Logger == nil {
 		return
 	}
+	input, name := c.Input, c.Name
+	if input == nil {
+		input = os.Stdin
+	}
+	if name == "" {
+		name = "stdin"
+	}
+	parse := ParseLine
+	if c.Stderr {
+		parse = ParseErrorLine
+	}
 
-	c.Logger.Info("console listener: started reading from stdin")
+	c.Logger.Info(fmt.Sprintf("console listener: started reading from %s", name))
 
-	reader := bufio.NewReader(os.Stdin)
+	reader := bufio.NewReader(input)
 
 	for {
 		select {
@@ -51,7 +68,7 @@ func (c *ConsoleListener) Start(ctx context.Context) {
 			if err != nil {
 				// EOF or broken pipe — safe to stop
 				if err.Error() == "EOF" {
-					c.Logger.Info("console listener: stdin closed (EOF)")
+					c.Logger.Info(fmt.Sprintf("console listener: %s closed (EOF)", name))
 				} else {
 					c.Logger.Warn(fmt.Sprintf("console listener: read error: %v", err))
 				}
@@ -63,12 +80,9 @@ func (c *ConsoleListener) Start(ctx context.Context) {
 				continue
 			}
 
-			event := models.Event{
-				Timestamp:  utils.NowEpochSeconds(),
-				EventType:  models.EventTypeConsoleLog.String(),
-				EventLevel: models.EventLevelLog.String(),
-				Content:    line,
-				Value:      0,
+			event, err := parse(line)
+			if err != nil {
+				c.Logger.Warn(fmt.Sprintf("console listener: invalid event line, logged as plain text: %v", err))
 			}
 
 			c.EventLogger.LogEvent(event)
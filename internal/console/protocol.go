@@ -0,0 +1,109 @@
+package console
+
+import (
+	"errors"
+	"strings"
+
+	"polytube/replay/internal/buffer"
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/marker"
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// Line protocol
+//
+// Every stdin line that is a JSON object is a structured event in the format
+// of events.DecodeEvent (type, level, content, value, timestamp, fields); any
+// other top-level key is a custom field too.
+//
+// Any other line is plain text and becomes a CONSOLE_LOG event with the whole
+// line as content. A leading "ERROR:", "[Error]", "WARNING:", "[WARN]", ... sets
+// the level, so existing engine logs get their warnings and errors classified.
+//
+// Lines of an error stream (the stderr of a game started with --exec) are
+// parsed the same way, but plain text without a level prefix is a WARNING.
+//
+// "/marker" or "/marker <note>" drops a MARKER event with the optional note,
+// and "/save-buffer [note]" logs a BUFFER_SAVE_REQUESTED event, which saves
+// the replay buffer when recording with --buffer.
+
+// ParseLine converts one stdin line into an event. JSON objects are decoded as
+// structured events; anything else is plain text. A JSON object that breaks
+// the protocol is returned as a plain event together with the error, so the
+// line is never lost.
+func ParseLine(line string) (models.Event, error) {
+	return parseLine(line, models.EventLevelLog)
+}
+
+// ParseErrorLine is ParseLine for a line of an error stream: plain text
+// without a level prefix is a WARNING.
+func ParseErrorLine(line string) (models.Event, error) {
+	return parseLine(line, models.EventLevelWarning)
+}
+
+// parseLine parses a line, giving plain text without a level prefix level.
+func parseLine(line string, level models.EventLevel) (models.Event, error) {
+	if note, ok := slashCommand(line, "/marker"); ok {
+		return marker.New(note, marker.TriggerCommand, models.EventSourceConsole), nil
+	}
+	if note, ok := slashCommand(line, "/save-buffer"); ok {
+		return buffer.NewSaveRequest(note, models.EventSourceConsole), nil
+	}
+	if strings.HasPrefix(line, "{") {
+		event, err := events.DecodeEvent([]byte(line), false)
+		switch {
+		case err == nil:
+			event.Source = string(models.EventSourceConsole)
+			return event, nil
+		case !errors.Is(err, events.ErrNotObject):
+			return parsePlain(line, level), err
+		}
+	}
+	return parsePlain(line, level), nil
+}
+
+// slashCommand recognizes "<name> [note]" and returns the note.
+func slashCommand(line, name string) (string, bool) {
+	rest, ok := strings.CutPrefix(line, name)
+	if !ok || rest != "" && rest[0] != ' ' && rest[0] != '\t' {
+		return "", false
+	}
+	return strings.TrimSpace(rest), true
+}
+
+// parsePlain builds a CONSOLE_LOG event from a plain-text line, at level
+// unless the line starts with one.
+func parsePlain(line string, level models.EventLevel) models.Event {
+	eventLevel := level.String()
+	if l, ok := levelPrefix(line); ok {
+		eventLevel = l
+	}
+	return models.Event{
+		Timestamp:  utils.NowEpochSeconds(),
+		EventType:  models.EventTypeConsoleLog.String(),
+		EventLevel: eventLevel,
+		Content:    line,
+		Value:      0,
+		Source:     string(models.EventSourceConsole),
+	}
+}
+
+// levelPrefix recognizes the level of "ERROR: msg", "[Warning] msg" and the like.
+func levelPrefix(line string) (string, bool) {
+	var word string
+	if strings.HasPrefix(line, "[") {
+		end := strings.IndexByte(line, ']')
+		if end < 0 {
+			return "", false
+		}
+		word = line[1:end]
+	} else {
+		end := strings.IndexByte(line, ':')
+		if end < 0 {
+			return "", false
+		}
+		word = line[:end]
+	}
+	return events.NormalizeLevel(word)
+}
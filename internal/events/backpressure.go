@@ -0,0 +1,187 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"polytube/replay/internal/logger"
+	"polytube/replay/pkg/models"
+)
+
+// OverflowPolicy decides what LogEvent does when the event queue is full.
+// Whatever the policy, lost events are counted and reported in the log as an
+// EVENTS_DROPPED event as soon as there is room again.
+type OverflowPolicy string
+
+const (
+	// OverflowDropNewest drops the event being logged (never blocks).
+	OverflowDropNewest OverflowPolicy = "drop-newest"
+	// OverflowDropOldest drops the oldest queued event to make room (never blocks).
+	OverflowDropOldest OverflowPolicy = "drop-oldest"
+	// OverflowBlock waits up to Options.BlockTimeout for room, then drops the event.
+	OverflowBlock OverflowPolicy = "block"
+	// OverflowSpill appends events to a file on disk until the writer catches up.
+	OverflowSpill OverflowPolicy = "spill"
+)
+
+// OverflowPolicies lists the valid policies.
+var OverflowPolicies = []OverflowPolicy{OverflowDropNewest, OverflowDropOldest, OverflowBlock, OverflowSpill}
+
+const (
+	DefaultQueueSize    = 4096
+	DefaultBlockTimeout = 100 * time.Millisecond
+)
+
+// ParseOverflowPolicy validates a policy name.
+func ParseOverflowPolicy(s string) (OverflowPolicy, error) {
+	for _, p := range OverflowPolicies {
+		if string(p) == s {
+			return p, nil
+		}
+	}
+	return "", fmt.Errorf("unknown overflow policy %q (use drop-newest, drop-oldest, block or spill)", s)
+}
+
+// Options configures the queue in front of an event logger.
+type Options struct {
+	Policy       OverflowPolicy         // zero value is OverflowDropNewest
+	QueueSize    int                    // zero uses DefaultQueueSize
+	BlockTimeout time.Duration          // OverflowBlock only; zero uses DefaultBlockTimeout
+	SpillPath    string                 // OverflowSpill only; file for events that don't fit
+	Logger       logger.LoggerInterface // receives write errors and drop reports; may be nil
+}
+
+func (o Options) withDefaults() Options {
+	if o.Policy == "" {
+		o.Policy = OverflowDropNewest
+	}
+	if o.QueueSize <= 0 {
+		o.QueueSize = DefaultQueueSize
+	}
+	if o.BlockTimeout <= 0 {
+		o.BlockTimeout = DefaultBlockTimeout
+	}
+	return o
+}
+
+// spillDrainMax is the most events one drain of the spill file returns, so
+// producers never wait on a large file being read.
+const spillDrainMax = 1024
+
+// spillFile holds events that did not fit into the queue, as JSON lines.
+// While it is non-empty every new event goes to it too, so events keep their
+// order: the writer drains the queue first, then the spill file.
+type spillFile struct {
+	path string
+
+	mu      sync.Mutex
+	f       *os.File
+	w       *bufio.Writer
+	readAt  int64 // offset of the first event not yet drained
+	pending int   // events written and not yet drained
+}
+
+// offer numbers e with seq and sends it to ch, or appends it to the spill file
+// when events are already waiting there or ch is full. Both happen in one step
+// under s.mu, so an event can't overtake spilled ones while they are drained.
+func (s *spillFile) offer(ch chan<- models.Event, seq *atomic.Int64, e models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.Sequence = seq.Add(1)
+	if s.pending == 0 {
+		select {
+		case ch <- e:
+			return nil
+		default:
+		}
+	}
+	return s.addLocked(e)
+}
+
+// addLocked appends e to the spill file. s.mu must be held.
+func (s *spillFile) addLocked(e models.Event) error {
+	if s.f == nil {
+		f, err := os.OpenFile(s.path, os.O_CREATE|os.O_RDWR|os.O_TRUNC, 0o644)
+		if err != nil {
+			return fmt.Errorf("open spill file: %w", err)
+		}
+		s.f, s.w = f, bufio.NewWriter(f)
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	if _, err := s.w.Write(append(b, '\n')); err != nil {
+		return fmt.Errorf("write spill file: %w", err)
+	}
+	s.pending++
+	return nil
+}
+
+// drain returns the oldest spilled events, at most spillDrainMax of them, and
+// empties the file once all are drained.
+func (s *spillFile) drain() ([]models.Event, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == 0 {
+		return nil, nil
+	}
+	if err := s.w.Flush(); err != nil {
+		return nil, fmt.Errorf("flush spill file: %w", err)
+	}
+	var events []models.Event
+	r := bufio.NewReader(io.NewSectionReader(s.f, s.readAt, math.MaxInt64-s.readAt))
+	for s.pending > 0 && len(events) < spillDrainMax {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			// Unreadable: give up on what is left rather than stall.
+			s.pending = 0
+			return events, errors.Join(fmt.Errorf("read spill file: %w", err), s.resetLocked())
+		}
+		s.readAt += int64(len(line))
+		s.pending--
+		var e models.Event
+		if err := json.Unmarshal(line, &e); err != nil {
+			return events, fmt.Errorf("read spill file: %w", err)
+		}
+		events = append(events, e)
+	}
+	if s.pending == 0 {
+		return events, s.resetLocked()
+	}
+	return events, nil
+}
+
+// resetLocked empties the spill file. s.mu must be held.
+func (s *spillFile) resetLocked() error {
+	s.readAt = 0
+	s.w.Reset(s.f)
+	if err := s.f.Truncate(0); err != nil {
+		return fmt.Errorf("truncate spill file: %w", err)
+	}
+	_, err := s.f.Seek(0, io.SeekStart)
+	return err
+}
+
+// close closes and removes the spill file.
+func (s *spillFile) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	if rmErr := os.Remove(s.path); err == nil && !os.IsNotExist(rmErr) {
+		err = rmErr
+	}
+	return err
+}
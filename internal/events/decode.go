@@ -0,0 +1,248 @@
+package events
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// JSON event format
+//
+// Games send events as JSON objects, on stdin (see package console) or over
+// the local IPC endpoint (see package ipc):
+//
+//	{"type": "PLAYER_DIED", "level": "WARNING", "content": "fell off map", "value": 3,
+//	 "timestamp": 1760000000.25, "fields": {"map": "dust", "cause": "fall"}}
+//
+// All keys are optional. The column names of events.parquet (eventType,
+// eventLevel, attributes) are accepted as aliases of type, level and fields.
+//
+//	type       event type; normalized to UPPER_SNAKE_CASE ("level loaded" -> LEVEL_LOADED). Default CONSOLE_LOG.
+//	level      LOG, WARNING or ERROR (INFO/WARN/ERR/FATAL and any case accepted). Default LOG.
+//	content    free text; non-string values are stored as JSON.
+//	value      number (or numeric string), e.g. an FPS sample. Default 0.
+//	timestamp  epoch seconds (milliseconds if > 1e11) or an RFC 3339 string, at most a day ahead. Default: time received.
+//	fields     object of custom key/values, stored as JSON in the attributes column.
+
+// reservedTypes are produced by the recorder itself and cannot be sent by a game.
+var reservedTypes = map[string]bool{
+	models.EventTypeInputLog.String():         true,
+	models.EventTypeRecordingStarted.String(): true,
+	models.EventTypeEventsDropped.String():    true,
+	models.EventTypeGameCrashed.String():      true,
+	models.EventTypeGameHung.String():         true,
+	models.EventTypeGameExited.String():       true,
+}
+
+// keyAliases maps accepted top-level keys to their canonical name.
+var keyAliases = map[string]string{
+	"type": "type", "eventType": "type",
+	"level": "level", "eventLevel": "level",
+	"content":   "content",
+	"value":     "value",
+	"timestamp": "timestamp",
+	"fields":    "fields", "attributes": "fields",
+}
+
+// ErrNotObject is returned by DecodeEvent when the input is not a JSON object.
+var ErrNotObject = errors.New("event must be a JSON object")
+
+// assignedKeys are event log columns filled in by the recorder, never by a game.
+var assignedKeys = map[string]bool{"sessionMs": true, "sequence": true, "source": true}
+
+// DecodeEvent decodes one JSON event. Unknown top-level keys are custom fields,
+// unless strict is set, in which case they are an error.
+func DecodeEvent(data []byte, strict bool) (models.Event, error) {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return models.Event{}, fmt.Errorf("%w: %v", ErrNotObject, err)
+	}
+	return decodeEvent(raw, strict)
+}
+
+// decodeEvent maps a decoded JSON object onto models.Event.
+func decodeEvent(obj map[string]json.RawMessage, strict bool) (models.Event, error) {
+	event := models.Event{
+		Timestamp:  utils.NowEpochSeconds(),
+		EventType:  models.EventTypeConsoleLog.String(),
+		EventLevel: models.EventLevelLog.String(),
+	}
+
+	raw := make(map[string]json.RawMessage, len(obj))
+	fields := make(map[string]json.RawMessage)
+	for k, v := range obj {
+		name, ok := keyAliases[k]
+		switch {
+		case ok:
+			if _, dup := raw[name]; dup {
+				return event, fmt.Errorf("%q given twice", name)
+			}
+			raw[name] = v
+		case strict && assignedKeys[k]:
+			return event, fmt.Errorf("%q is assigned by the recorder", k)
+		case strict:
+			return event, fmt.Errorf("unknown key %q (put custom values in \"fields\")", k)
+		default:
+			fields[k] = v
+		}
+	}
+
+	if v, ok := raw["type"]; ok {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return event, errors.New(`"type" must be a string`)
+		}
+		if t := normalizeType(s); t != "" {
+			event.EventType = t
+		}
+		if reservedTypes[event.EventType] {
+			return event, fmt.Errorf("event type %s is reserved", event.EventType)
+		}
+		if !models.IsAllowedEventType(event.EventType) {
+			return event, fmt.Errorf("event type %s is not registered (see --event-types)", event.EventType)
+		}
+	}
+
+	if v, ok := raw["level"]; ok {
+		var s string
+		if err := json.Unmarshal(v, &s); err != nil {
+			return event, errors.New(`"level" must be a string`)
+		}
+		level, ok := NormalizeLevel(s)
+		if !ok {
+			return event, fmt.Errorf("unknown level %q (use LOG, WARNING or ERROR)", s)
+		}
+		event.EventLevel = level
+	}
+
+	if v, ok := raw["content"]; ok {
+		var s string
+		if err := json.Unmarshal(v, &s); err == nil {
+			event.Content = s
+		} else if string(v) != "null" {
+			event.Content = string(v)
+		}
+	}
+
+	if v, ok := raw["value"]; ok {
+		value, err := parseNumber(v)
+		if err != nil {
+			return event, fmt.Errorf(`"value": %w`, err)
+		}
+		event.Value = value
+	}
+
+	if v, ok := raw["timestamp"]; ok {
+		ts, err := parseTimestamp(v)
+		if err != nil {
+			return event, fmt.Errorf(`"timestamp": %w`, err)
+		}
+		event.Timestamp = ts
+	}
+
+	if v, ok := raw["fields"]; ok && string(v) != "null" {
+		var custom map[string]json.RawMessage
+		if err := json.Unmarshal(v, &custom); err != nil {
+			return event, errors.New(`"fields" must be an object`)
+		}
+		for k, v := range custom {
+			fields[k] = v
+		}
+	}
+	if len(fields) > 0 {
+		attrs, err := json.Marshal(fields) // map keys are sorted, so output is stable
+		if err != nil {
+			return event, fmt.Errorf("encode fields: %w", err)
+		}
+		event.Attributes = string(attrs)
+	}
+	return event, nil
+}
+
+// normalizeType turns "level loaded" or "player-died" into LEVEL_LOADED / PLAYER_DIED.
+func normalizeType(s string) string {
+	var b strings.Builder
+	underscore := false
+	for _, r := range strings.TrimSpace(s) {
+		switch {
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			if underscore && b.Len() > 0 {
+				b.WriteByte('_')
+			}
+			underscore = false
+			b.WriteRune(unicode.ToUpper(r))
+		default:
+			underscore = true
+		}
+	}
+	return b.String()
+}
+
+// levelAliases maps accepted level spellings (upper case) to event levels.
+var levelAliases = map[string]string{
+	"LOG":       models.EventLevelLog.String(),
+	"INFO":      models.EventLevelLog.String(),
+	"DEBUG":     models.EventLevelLog.String(),
+	"WARNING":   models.EventLevelWarning.String(),
+	"WARN":      models.EventLevelWarning.String(),
+	"ERROR":     models.EventLevelError.String(),
+	"ERR":       models.EventLevelError.String(),
+	"FATAL":     models.EventLevelError.String(),
+	"EXCEPTION": models.EventLevelError.String(),
+}
+
+// NormalizeLevel maps a level spelling (LOG, info, Warn, ERR, ...) to an event level.
+func NormalizeLevel(s string) (string, bool) {
+	level, ok := levelAliases[strings.ToUpper(strings.TrimSpace(s))]
+	return level, ok
+}
+
+func parseNumber(v json.RawMessage) (float64, error) {
+	var f float64
+	if err := json.Unmarshal(v, &f); err == nil {
+		return f, nil
+	}
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		if f, err := strconv.ParseFloat(strings.TrimSpace(s), 64); err == nil {
+			return f, nil
+		}
+	}
+	return 0, errors.New("must be a number")
+}
+
+// maxTimestampAhead is how far in the future a client timestamp may be.
+const maxTimestampAhead = 24 * time.Hour
+
+// parseTimestamp returns epoch seconds, the unit of models.Event.Timestamp.
+func parseTimestamp(v json.RawMessage) (float64, error) {
+	var f float64
+	var s string
+	if err := json.Unmarshal(v, &s); err == nil {
+		t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(s))
+		if err != nil {
+			return 0, errors.New("must be epoch seconds or an RFC 3339 time")
+		}
+		f = float64(t.UnixNano()) / 1e9
+	} else {
+		f, err = parseNumber(v)
+		if err != nil || f <= 0 || math.IsInf(f, 0) || math.IsNaN(f) {
+			return 0, errors.New("must be epoch seconds or an RFC 3339 time")
+		}
+		if f > 1e11 { // milliseconds
+			f /= 1000
+		}
+	}
+	if f > utils.NowEpochSeconds()+maxTimestampAhead.Seconds() {
+		return 0, errors.New("more than a day in the future")
+	}
+	return f, nil
+}
@@ -1,15 +1,15 @@
 package events
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"polytube/replay/pkg/models"
-
-	"github.com/xitongsys/parquet-go-source/local"
-	"github.com/xitongsys/parquet-go/parquet"
-	"github.com/xitongsys/parquet-go/source"
-	"github.com/xitongsys/parquet-go/writer"
+	"polytube/replay/utils"
 )
 
 // EventLoggerInterface defines a basic event logger
@@ -18,66 +18,192 @@ type EventLoggerInterface interface {
 	Close() error
 }
 
-// ParquetEventLogger uses a background goroutine and channel for non-blocking logging
-type ParquetEventLogger struct {
-	writer *writer.ParquetWriter
-	file   source.ParquetFile
+// Sink stores the events of an EventLogger, e.g. as parquet, NDJSON, CSV or
+// SQLite. Its methods are only called from the logger's goroutine, in order.
+type Sink interface {
+	Write(e models.Event) error
+	// Flush makes the events written so far readable, about once a second.
+	// sessionMs is the current session time, or -1 before the recording started.
+	Flush(sessionMs int64) error
+	// Close writes what is left and releases the sink.
+	Close() error
+}
+
+// maxHeldBack bounds how many events logged before RECORDING_STARTED are held
+// back; beyond it they are timed relative to the logger's creation instead.
+const maxHeldBack = 50000
 
-	ch   chan models.Event
-	done chan struct{}
+// EventLogger uses a background goroutine and channel for non-blocking logging
+// to one or more sinks, so several formats can be written at once.
+//
+// It stamps every event with its Sequence (arrival order) and SessionMs (ms
+// since the RECORDING_STARTED event). Events arriving before RECORDING_STARTED
+// are held back until it is logged, so they get a correct negative SessionMs.
+//
+// When the queue is full, opts.Policy decides what happens (see OverflowPolicy).
+// Lost events are counted and reported as an EVENTS_DROPPED event; write
+// errors go to opts.Logger. All sinks see the same events in the same order.
+type EventLogger struct {
+	sinks []Sink
+	opts  Options
+
+	ch        chan models.Event
+	done      chan struct{}
+	stopped   chan struct{}
+	closeOnce sync.Once
+	closeErr  error
+	sequence  atomic.Int64
+	dropped   atomic.Int64 // lost since the last EVENTS_DROPPED event
+	created   float64      // epoch seconds; SessionMs anchor if RECORDING_STARTED never comes
+	spill     *spillFile   // OverflowSpill only
+
+	// Written by the loop goroutine only.
+	totalDropped int64
+	writeErrors  int64
+	firstErr     error
+	lastErrLog   time.Time
 }
 
-// NewParquetEventLogger creates a new buffered parquet event logger
-func NewParquetEventLogger(path string) (*ParquetEventLogger, error) {
-	fw, err := local.NewLocalFileWriter(path)
-	if err != nil {
-		return nil, fmt.Errorf("create parquet file: %w", err)
+// NewEventLogger creates a buffered event logger writing to sinks. The logger
+// owns the sinks and closes them in Close.
+func NewEventLogger(opts Options, sinks ...Sink) (*EventLogger, error) {
+	opts = opts.withDefaults()
+	if len(sinks) == 0 {
+		return nil, errors.New("no event sinks")
 	}
-
-	pw, err := writer.NewParquetWriter(fw, new(models.Event), 4)
-	if err != nil {
-		_ = fw.Close()
-		return nil, fmt.Errorf("create parquet writer: %w", err)
+	if opts.Policy == OverflowSpill && opts.SpillPath == "" {
+		return nil, errors.New("spill policy needs a spill file path")
 	}
 
-	pw.CompressionType = parquet.CompressionCodec_SNAPPY
-	pw.RowGroupSize = 128 * 1024 * 1024 // 128MB
-	pw.PageSize = 8 * 1024              // 8KB
-
-	l := &ParquetEventLogger{
-		writer: pw,
-		file:   fw,
-		ch:     make(chan models.Event, 4096), // channel buffer size
-		done:   make(chan struct{}),
+	l := &EventLogger{
+		sinks:   sinks,
+		opts:    opts,
+		ch:      make(chan models.Event, opts.QueueSize),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+		created: float64(time.Now().UnixNano()) / 1e9,
+	}
+	if opts.Policy == OverflowSpill {
+		l.spill = &spillFile{path: opts.SpillPath}
 	}
 
 	go l.loop()
 	return l, nil
 }
 
-// loop continuously writes events to the parquet writer and flushes periodically
-func (l *ParquetEventLogger) loop() {
+// NewParquetEventLogger creates a buffered event logger writing a single parquet file.
+func NewParquetEventLogger(path string, opts Options) (*EventLogger, error) {
+	sink, err := NewParquetSink(path, 0, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return NewEventLogger(opts, sink)
+}
+
+// loop continuously writes events to the sinks and flushes periodically
+func (l *EventLogger) loop() {
+	defer close(l.stopped)
 	ticker := time.NewTicker(time.Second)
 	defer ticker.Stop()
 
+	var (
+		anchored bool
+		anchor   float64
+		held     []models.Event
+	)
+	write := func(e models.Event) {
+		e.SessionMs = int64(math.Round((e.Timestamp - anchor) * 1000))
+		// A client clock running ahead must not move the sinks into a
+		// future time window (see Rolling event files).
+		if now := int64(math.Round((utils.NowEpochSeconds() - anchor) * 1000)); e.SessionMs > now {
+			e.SessionMs = now
+		}
+		for _, s := range l.sinks {
+			l.check("write event", s.Write(e))
+		}
+	}
+	setAnchor := func(ts float64) {
+		anchored, anchor = true, ts
+		for _, e := range held {
+			write(e)
+		}
+		held = nil
+	}
+	handle := func(e models.Event) {
+		switch {
+		case anchored:
+			write(e)
+		case e.EventType == models.EventTypeRecordingStarted.String():
+			setAnchor(e.Timestamp)
+			write(e)
+		case len(held) >= maxHeldBack:
+			setAnchor(l.created)
+			write(e)
+		default:
+			held = append(held, e)
+		}
+	}
+	// catchUp writes spilled events once the queue is empty, then reports
+	// drops if the queue has room again. The spill file is drained a chunk at
+	// a time; new events go to it until it is empty, so the queue stays empty.
+	catchUp := func() {
+		for l.spill != nil && len(l.ch) == 0 {
+			spilled, err := l.spill.drain()
+			l.check("drain spill file", err)
+			if len(spilled) == 0 {
+				break
+			}
+			for _, e := range spilled {
+				handle(e)
+			}
+		}
+		if len(l.ch) <= cap(l.ch)/2 {
+			if e, ok := l.droppedEvent(); ok {
+				handle(e)
+			}
+		}
+	}
+
 	for {
 		select {
 		case e := <-l.ch:
-			_ = l.writer.Write(e) // best-effort write, errors can be logged if needed
+			handle(e)
+			if len(l.ch) == 0 {
+				catchUp()
+			}
 
 		case <-ticker.C:
-			_ = l.writer.Flush(true)
+			catchUp()
+			now := int64(-1)
+			if anchored {
+				now = max(int64(math.Round((utils.NowEpochSeconds()-anchor)*1000)), 0)
+			}
+			for _, s := range l.sinks {
+				l.check("flush events", s.Flush(now))
+			}
 
 		case <-l.done:
 			// drain remaining events
 			for {
 				select {
 				case e := <-l.ch:
-					_ = l.writer.Write(e)
+					handle(e)
 				default:
-					_ = l.writer.Flush(true)
-					_ = l.writer.WriteStop()
-					_ = l.file.Close()
+					catchUp()
+					// Report drops even if the queue never had room again.
+					if e, ok := l.droppedEvent(); ok {
+						handle(e)
+					}
+					if !anchored {
+						setAnchor(l.created)
+					}
+					for _, s := range l.sinks {
+						l.check("close event sink", s.Close())
+					}
+					if l.spill != nil {
+						l.check("remove spill file", l.spill.close())
+					}
+					l.report()
 					return
 				}
 			}
@@ -85,17 +211,116 @@ func (l *ParquetEventLogger) loop() {
 	}
 }
 
-// LogEvent enqueues an event non-blockingly
-func (l *ParquetEventLogger) LogEvent(e models.Event) {
+// droppedEvent builds the EVENTS_DROPPED event for events lost since the last one.
+func (l *EventLogger) droppedEvent() (models.Event, bool) {
+	n := l.dropped.Swap(0)
+	if n == 0 {
+		return models.Event{}, false
+	}
+	l.totalDropped += n
+	if l.opts.Logger != nil {
+		l.opts.Logger.Warn(fmt.Sprintf("event logger: queue full, %d event(s) dropped (policy %s)", n, l.opts.Policy))
+	}
+	return models.Event{
+		Timestamp:  utils.NowEpochSeconds(),
+		EventType:  models.EventTypeEventsDropped.String(),
+		EventLevel: models.EventLevelWarning.String(),
+		Content:    string(l.opts.Policy),
+		Value:      float64(n),
+		Sequence:   l.sequence.Add(1),
+		Source:     string(models.EventSourceSystem),
+	}, true
+}
+
+// check records a write error and logs it, at most once per second.
+func (l *EventLogger) check(op string, err error) {
+	if err == nil {
+		return
+	}
+	l.writeErrors++
+	if l.firstErr == nil {
+		l.firstErr = fmt.Errorf("%s: %w", op, err)
+	}
+	if l.opts.Logger != nil && time.Since(l.lastErrLog) >= time.Second {
+		l.lastErrLog = time.Now()
+		l.opts.Logger.Error(fmt.Sprintf("event logger: %s failed: %v (%d error(s) so far)", op, err, l.writeErrors))
+	}
+}
+
+// report logs the totals when the logger stops.
+func (l *EventLogger) report() {
+	if l.opts.Logger == nil {
+		return
+	}
+	if l.totalDropped > 0 {
+		l.opts.Logger.Warn(fmt.Sprintf("event logger: %d event(s) dropped in total (policy %s)", l.totalDropped, l.opts.Policy))
+	}
+	if l.writeErrors > 0 {
+		l.opts.Logger.Error(fmt.Sprintf("event logger: %d write error(s); first: %v", l.writeErrors, l.firstErr))
+	}
+}
+
+// LogEvent enqueues an event. When the queue is full the overflow policy
+// decides whether the event is dropped, replaces the oldest one, waits or is
+// spilled to disk.
+func (l *EventLogger) LogEvent(e models.Event) {
+	// Keep order while spilled events are waiting.
+	if l.spill != nil {
+		if err := l.spill.offer(l.ch, &l.sequence, e); err != nil {
+			l.dropped.Add(1)
+			if l.opts.Logger != nil {
+				l.opts.Logger.Error(fmt.Sprintf("event logger: %v", err))
+			}
+		}
+		return
+	}
+	e.Sequence = l.sequence.Add(1)
+
 	select {
 	case l.ch <- e:
+		return
+	default:
+	}
+
+	switch l.opts.Policy {
+	case OverflowDropOldest:
+		for i := 0; i < 3; i++ {
+			select {
+			case <-l.ch:
+				l.dropped.Add(1)
+			default:
+			}
+			select {
+			case l.ch <- e:
+				return
+			default:
+			}
+		}
+		l.dropped.Add(1)
+	case OverflowBlock:
+		timer := time.NewTimer(l.opts.BlockTimeout)
+		defer timer.Stop()
+		select {
+		case l.ch <- e:
+		case <-timer.C:
+			l.dropped.Add(1)
+		case <-l.stopped:
+			l.dropped.Add(1)
+		}
 	default:
-		// channel full: drop event to avoid blocking
+		l.dropped.Add(1)
 	}
 }
 
-// Close signals the writer goroutine to stop and flush remaining events
-func (l *ParquetEventLogger) Close() error {
-	close(l.done)
-	return nil
+// Close stops the writer goroutine after it has written the remaining events
+// and finished the file. It returns the first write error, if any.
+func (l *EventLogger) Close() error {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		<-l.stopped
+		if l.firstErr != nil {
+			l.closeErr = fmt.Errorf("event log incomplete: %d write error(s); first: %w", l.writeErrors, l.firstErr)
+		}
+	})
+	return l.closeErr
 }
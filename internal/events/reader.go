@@ -0,0 +1,147 @@
+package events
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/reader"
+
+	"polytube/replay/pkg/models"
+)
+
+// column is one Event column and how to store a value read from it.
+type column struct {
+	name string
+	set  func(e *models.Event, v any)
+}
+
+// columns lists the Event columns of every schema version (see models.EventSchemaVersion).
+var columns = []column{
+	{"timestamp", func(e *models.Event, v any) { e.Timestamp, _ = v.(float64) }},
+	{"eventType", func(e *models.Event, v any) { e.EventType, _ = v.(string) }},
+	{"eventLevel", func(e *models.Event, v any) { e.EventLevel, _ = v.(string) }},
+	{"content", func(e *models.Event, v any) { e.Content, _ = v.(string) }},
+	{"value", func(e *models.Event, v any) { e.Value, _ = v.(float64) }},
+	{"attributes", func(e *models.Event, v any) { e.Attributes, _ = v.(string) }},
+	{"sessionMs", func(e *models.Event, v any) { e.SessionMs, _ = v.(int64) }},
+	{"sequence", func(e *models.Event, v any) { e.Sequence, _ = v.(int64) }},
+	{"source", func(e *models.Event, v any) { e.Source, _ = v.(string) }},
+}
+
+// ReadFile reads every event of a parquet event file. Files of older schema
+// versions are read too: the columns they lack are left zero, except SessionMs,
+// which is derived from the timestamps (see ReadSession).
+func ReadFile(path string) ([]models.Event, error) {
+	events, hasSessionMs, err := readFile(path)
+	if err == nil && !hasSessionMs {
+		deriveSessionMs(events)
+	}
+	return events, err
+}
+
+// readFile reads path column by column and reports whether it has sessionMs.
+func readFile(path string) ([]models.Event, bool, error) {
+	fr, err := local.NewLocalFileReader(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("open %s: %w", path, err)
+	}
+	defer fr.Close()
+
+	pr, err := reader.NewParquetColumnReader(fr, 1)
+	if err != nil {
+		return nil, false, fmt.Errorf("read %s: %w", path, err)
+	}
+	defer pr.ReadStop()
+
+	n := pr.GetNumRows()
+	events := make([]models.Event, n)
+	if n == 0 {
+		return events, true, nil
+	}
+	root := pr.SchemaHandler.GetRootExName()
+	hasSessionMs := false
+	for _, c := range columns {
+		colPath := root + "\x01" + c.name
+		if _, err := pr.SchemaHandler.ConvertToInPathStr(colPath); err != nil {
+			continue // not in this schema version
+		}
+		values, _, _, err := pr.ReadColumnByPath(colPath, n)
+		if err != nil {
+			return nil, false, fmt.Errorf("read %s: column %s: %w", path, c.name, err)
+		}
+		if int64(len(values)) != n {
+			return nil, false, fmt.Errorf("read %s: column %s has %d of %d values", path, c.name, len(values), n)
+		}
+		for i, v := range values {
+			c.set(&events[i], v)
+		}
+		hasSessionMs = hasSessionMs || c.name == "sessionMs"
+	}
+	return events, hasSessionMs, nil
+}
+
+// SessionFiles returns the parquet event files of a session directory in time
+// order: the rolled events_###.parquet files, or events.parquet of sessions
+// recorded before event files were rolled.
+func SessionFiles(dir string) ([]string, error) {
+	path := filepath.Join(dir, ParquetFile)
+	files, err := RolledFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		if _, err := os.Stat(path); err == nil {
+			files = []string{path}
+		}
+	}
+	return files, nil
+}
+
+// ReadSession reads all events of a session directory in order. For files
+// written before schema version 3, SessionMs is derived from the timestamp of
+// RECORDING_STARTED (or of the first event).
+func ReadSession(dir string) ([]models.Event, error) {
+	files, err := SessionFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+	if len(files) == 0 {
+		return nil, fmt.Errorf("no event files in %s", dir)
+	}
+	var (
+		events []models.Event
+		derive bool
+	)
+	for _, f := range files {
+		fileEvents, hasSessionMs, err := readFile(f)
+		if err != nil {
+			return events, err
+		}
+		events = append(events, fileEvents...)
+		derive = derive || !hasSessionMs
+	}
+	if derive {
+		deriveSessionMs(events)
+	}
+	return events, nil
+}
+
+// deriveSessionMs fills in SessionMs for files without the column.
+func deriveSessionMs(events []models.Event) {
+	if len(events) == 0 {
+		return
+	}
+	anchor := events[0].Timestamp
+	for _, e := range events {
+		if e.EventType == models.EventTypeRecordingStarted.String() {
+			anchor = e.Timestamp
+			break
+		}
+	}
+	for i := range events {
+		events[i].SessionMs = int64(math.Round((events[i].Timestamp - anchor) * 1000))
+	}
+}
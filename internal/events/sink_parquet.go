@@ -0,0 +1,300 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xitongsys/parquet-go-source/local"
+	"github.com/xitongsys/parquet-go/parquet"
+	"github.com/xitongsys/parquet-go/source"
+	"github.com/xitongsys/parquet-go/writer"
+
+	"polytube/replay/pkg/models"
+)
+
+// Rolling event files
+//
+// With a roll interval set (see NewParquetSink), the log for "dir/events.parquet" is split into
+// dir/events_000.parquet, dir/events_001.parquet, ... File N holds the events
+// whose SessionMs falls into [N*RollInterval, (N+1)*RollInterval); events from
+// before RECORDING_STARTED go into file 0. With RollInterval equal to the HLS
+// segment length, events_007.parquet covers the same time as output_007.ts:
+// the recorder forces a keyframe at every N*segment, so HLS cuts exactly there.
+//
+// A file is written as <name>.tmp and renamed once its footer is written, so
+// every file with the final name is a complete parquet file that can be
+// uploaded right away. When a file reaches the roll size before its window
+// ends, the rest of the window continues in events_007_1.parquet, _2, ...
+//
+// Events are never written to an earlier window: an event arriving late (e.g.
+// with a client timestamp in the past) goes into the current file. Nor to a
+// later one: EventLogger caps SessionMs at the current session time.
+//
+// With keep set, only the files of the last keep windows stay on disk, like
+// the segments of a replay buffer; older files are deleted as windows pass.
+
+// tmpSuffix marks a rolled file that is still being written.
+const tmpSuffix = ".tmp"
+
+// parquetSink writes events as parquet, optionally rolled into one file per
+// time window.
+type parquetSink struct {
+	path         string
+	rollInterval time.Duration
+	rollSize     int64
+	keep         int64 // windows kept on disk; 0 keeps all
+
+	file   *parquetFile // nil between rolled files
+	window int64        // time window of the current or last rolled file
+	part   int          // size rolls within window
+}
+
+// NewParquetSink creates a parquet sink for path. With rollInterval > 0 the log
+// is rolled into one file per interval, and within an interval whenever a file
+// reaches rollSize bytes (zero for no limit). With keep > 0 only the files of
+// the last keep intervals are kept.
+func NewParquetSink(path string, rollInterval time.Duration, rollSize int64, keep int) (Sink, error) {
+	s := &parquetSink{path: path, rollInterval: rollInterval, rollSize: rollSize, keep: int64(keep)}
+	// Open the first file now so a bad path fails here, not in the loop.
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *parquetSink) Write(e models.Event) error {
+	var err error
+	if s.rollInterval > 0 {
+		if w := s.windowOf(e.SessionMs); w > s.window {
+			err = s.finish()
+			s.window, s.part = w, 0
+			err = errors.Join(err, s.prune())
+		}
+	}
+	if s.file == nil {
+		if openErr := s.open(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+	}
+	return errors.Join(err, s.file.pw.Write(e))
+}
+
+func (s *parquetSink) Flush(sessionMs int64) error {
+	if s.file == nil {
+		return nil
+	}
+	if err := s.file.pw.Flush(true); err != nil {
+		return err
+	}
+	if s.rollInterval <= 0 {
+		return nil
+	}
+	// Finish the file as soon as its window is over, even if no further
+	// event comes, so it can be uploaded.
+	switch {
+	case sessionMs >= 0 && s.windowOf(sessionMs) > s.window:
+		s.window, s.part = s.windowOf(sessionMs), 0
+		return errors.Join(s.finish(), s.prune())
+	case s.rollSize > 0 && s.file.size() >= s.rollSize:
+		s.part++
+		return s.finish()
+	}
+	return nil
+}
+
+func (s *parquetSink) Close() error {
+	return s.finish()
+}
+
+// open creates the file for the current window (or the only file when not rolling).
+func (s *parquetSink) open() error {
+	path, final := s.path, s.path
+	if s.rollInterval > 0 {
+		final = rolledName(s.path, s.window, s.part)
+		path = final + tmpSuffix
+	}
+	f, err := createParquetFile(path, final)
+	if err != nil {
+		return err
+	}
+	s.file = f
+	return nil
+}
+
+// finish completes the current file, if any.
+func (s *parquetSink) finish() error {
+	if s.file == nil {
+		return nil
+	}
+	f := s.file
+	s.file = nil
+	if err := f.pw.Flush(true); err != nil {
+		_ = f.fw.Close()
+		return fmt.Errorf("flush %s: %w", filepath.Base(f.final), err)
+	}
+	return f.finish()
+}
+
+// prune deletes the finished files of windows that are no longer kept.
+func (s *parquetSink) prune() error {
+	if s.keep <= 0 || s.window < s.keep {
+		return nil
+	}
+	files, err := rolledFiles(s.path)
+	if err != nil {
+		return err
+	}
+	var errs []error
+	for _, f := range files {
+		if f.window > s.window-s.keep {
+			break
+		}
+		if err := os.Remove(f.path); err != nil && !os.IsNotExist(err) {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// windowOf returns the rolling window of a SessionMs; earlier events belong to window 0.
+func (s *parquetSink) windowOf(sessionMs int64) int64 {
+	if sessionMs <= 0 {
+		return 0
+	}
+	return sessionMs / s.rollInterval.Milliseconds()
+}
+
+// parquetFile is one open parquet output file.
+type parquetFile struct {
+	path  string // written here
+	final string // renamed to this when finished; same as path if not rolling
+	fw    source.ParquetFile
+	pw    *writer.ParquetWriter
+}
+
+// createParquetFile opens a parquet writer for final, writing to path.
+func createParquetFile(path, final string) (*parquetFile, error) {
+	fw, err := local.NewLocalFileWriter(path)
+	if err != nil {
+		return nil, fmt.Errorf("create parquet file: %w", err)
+	}
+
+	pw, err := writer.NewParquetWriter(fw, new(models.Event), 4)
+	if err != nil {
+		_ = fw.Close()
+		return nil, fmt.Errorf("create parquet writer: %w", err)
+	}
+
+	pw.CompressionType = parquet.CompressionCodec_SNAPPY
+	pw.RowGroupSize = 128 * 1024 * 1024 // 128MB
+	pw.PageSize = 8 * 1024              // 8KB
+	return &parquetFile{path: path, final: final, fw: fw, pw: pw}, nil
+}
+
+// size returns the number of bytes written to disk so far.
+func (f *parquetFile) size() int64 {
+	fi, err := os.Stat(f.path)
+	if err != nil {
+		return 0
+	}
+	return fi.Size()
+}
+
+// finish writes the footer, closes the file and gives it its final name.
+func (f *parquetFile) finish() error {
+	if err := f.pw.WriteStop(); err != nil {
+		_ = f.fw.Close()
+		return fmt.Errorf("finish %s: %w", filepath.Base(f.final), err)
+	}
+	if err := f.fw.Close(); err != nil {
+		return fmt.Errorf("close %s: %w", filepath.Base(f.final), err)
+	}
+	if f.path != f.final {
+		if err := os.Rename(f.path, f.final); err != nil {
+			return fmt.Errorf("rename %s: %w", filepath.Base(f.final), err)
+		}
+	}
+	return nil
+}
+
+// rolledName returns the name of part of window for the log at path.
+func rolledName(path string, window int64, part int) string {
+	ext := filepath.Ext(path)
+	name := fmt.Sprintf("%s_%03d", strings.TrimSuffix(path, ext), window)
+	if part > 0 {
+		name += fmt.Sprintf("_%d", part)
+	}
+	return name + ext
+}
+
+// RolledFiles returns the finished rolled files of the log at path (e.g.
+// dir/events.parquet) in time order. Files still being written are skipped.
+func RolledFiles(path string) ([]string, error) {
+	files, err := rolledFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	paths := make([]string, len(files))
+	for i, f := range files {
+		paths[i] = f.path
+	}
+	return paths, nil
+}
+
+// rolledFile is a finished rolled file and its place in the log.
+type rolledFile struct {
+	path         string
+	window, part int64
+}
+
+// rolledFiles returns the finished rolled files of the log at path in time order.
+func rolledFiles(path string) ([]rolledFile, error) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(filepath.Base(path), ext)
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	var files []rolledFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base+"_") || !strings.HasSuffix(name, ext) {
+			continue
+		}
+		var r rolledFile
+		suffix := strings.TrimSuffix(strings.TrimPrefix(name, base+"_"), ext)
+		window, part, hasPart := strings.Cut(suffix, "_")
+		if _, err := fmt.Sscan(window, &r.window); err != nil || !isDigits(window) {
+			continue
+		}
+		if hasPart {
+			if _, err := fmt.Sscan(part, &r.part); err != nil || !isDigits(part) {
+				continue
+			}
+		}
+		r.path = filepath.Join(filepath.Dir(path), name)
+		files = append(files, r)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if files[i].window != files[j].window {
+			return files[i].window < files[j].window
+		}
+		return files[i].part < files[j].part
+	})
+	return files, nil
+}
+
+func isDigits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
@@ -0,0 +1,84 @@
+//go:build cgo
+
+package events
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	_ "github.com/mattn/go-sqlite3"
+
+	"polytube/replay/pkg/models"
+)
+
+// sqliteSupported reports whether this build can write SQLite (it needs cgo).
+const sqliteSupported = true
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS events (
+	timestamp  REAL    NOT NULL,
+	eventType  TEXT    NOT NULL,
+	eventLevel TEXT    NOT NULL,
+	content    TEXT    NOT NULL,
+	value      REAL    NOT NULL,
+	attributes TEXT    NOT NULL,
+	sessionMs  INTEGER NOT NULL,
+	sequence   INTEGER NOT NULL,
+	source     TEXT    NOT NULL
+);
+CREATE INDEX IF NOT EXISTS events_session_ms ON events (sessionMs);
+CREATE INDEX IF NOT EXISTS events_type ON events (eventType);`
+
+// sqliteSink inserts events into the events table. Inserts are batched into
+// one transaction per second, which is committed on every Flush.
+type sqliteSink struct {
+	db   *sql.DB
+	tx   *sql.Tx
+	stmt *sql.Stmt
+}
+
+// NewSQLiteSink opens (or creates) the SQLite database at path and writes
+// events to its events table.
+func NewSQLiteSink(path string) (Sink, error) {
+	db, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return nil, fmt.Errorf("open sqlite database: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("create events table: %w", err)
+	}
+	return &sqliteSink{db: db}, nil
+}
+
+func (s *sqliteSink) Write(e models.Event) error {
+	if s.tx == nil {
+		tx, err := s.db.Begin()
+		if err != nil {
+			return fmt.Errorf("begin transaction: %w", err)
+		}
+		stmt, err := tx.Prepare(`INSERT INTO events (timestamp, eventType, eventLevel, content, value, attributes, sessionMs, sequence, source) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+		if err != nil {
+			_ = tx.Rollback()
+			return fmt.Errorf("prepare insert: %w", err)
+		}
+		s.tx, s.stmt = tx, stmt
+	}
+	_, err := s.stmt.Exec(e.Timestamp, e.EventType, e.EventLevel, e.Content, e.Value, e.Attributes, e.SessionMs, e.Sequence, e.Source)
+	return err
+}
+
+func (s *sqliteSink) Flush(int64) error {
+	if s.tx == nil {
+		return nil
+	}
+	_ = s.stmt.Close()
+	err := s.tx.Commit()
+	s.tx, s.stmt = nil, nil
+	return err
+}
+
+func (s *sqliteSink) Close() error {
+	return errors.Join(s.Flush(-1), s.db.Close())
+}
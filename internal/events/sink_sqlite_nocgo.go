@@ -0,0 +1,13 @@
+//go:build !cgo
+
+package events
+
+import "errors"
+
+// sqliteSupported reports whether this build can write SQLite (it needs cgo).
+const sqliteSupported = false
+
+// NewSQLiteSink fails: SQLite support needs a build with cgo.
+func NewSQLiteSink(path string) (Sink, error) {
+	return nil, errors.New("sqlite: this build has no SQLite support (build with CGO_ENABLED=1)")
+}
@@ -0,0 +1,116 @@
+package events
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+
+	"polytube/replay/pkg/models"
+)
+
+// ndjsonSink writes one JSON object per line. Lines are flushed to the file
+// every second, so after a crash the file is readable up to the last second.
+type ndjsonSink struct {
+	f *os.File
+	w *bufio.Writer
+}
+
+// ndjsonRecord is an Event with its attributes inlined as a JSON object.
+type ndjsonRecord struct {
+	models.Event
+	Attributes json.RawMessage `json:"attributes,omitempty"`
+}
+
+// NewNDJSONSink creates (or truncates) path and writes events to it as NDJSON.
+func NewNDJSONSink(path string) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create ndjson file: %w", err)
+	}
+	return &ndjsonSink{f: f, w: bufio.NewWriter(f)}, nil
+}
+
+// MarshalEvent encodes e as one NDJSON line (without the newline), with its
+// attributes as a JSON object.
+func MarshalEvent(e models.Event) ([]byte, error) {
+	rec := ndjsonRecord{Event: e}
+	if e.Attributes != "" && json.Valid([]byte(e.Attributes)) {
+		rec.Attributes = json.RawMessage(e.Attributes)
+	}
+	return json.Marshal(rec)
+}
+
+func (s *ndjsonSink) Write(e models.Event) error {
+	b, err := MarshalEvent(e)
+	if err != nil {
+		return err
+	}
+	_, err = s.w.Write(append(b, '\n'))
+	return err
+}
+
+func (s *ndjsonSink) Flush(int64) error {
+	return s.w.Flush()
+}
+
+func (s *ndjsonSink) Close() error {
+	err := s.w.Flush()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// csvColumns is the header row of the CSV sink, in the order of the parquet columns.
+var csvColumns = []string{"timestamp", "eventType", "eventLevel", "content", "value", "attributes", "sessionMs", "sequence", "source"}
+
+// csvSink writes one row per event with a header row, flushed every second.
+type csvSink struct {
+	f *os.File
+	w *csv.Writer
+}
+
+// NewCSVSink creates (or truncates) path and writes events to it as CSV.
+func NewCSVSink(path string) (Sink, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("create csv file: %w", err)
+	}
+	s := &csvSink{f: f, w: csv.NewWriter(f)}
+	if err := s.w.Write(csvColumns); err != nil {
+		_ = f.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *csvSink) Write(e models.Event) error {
+	return s.w.Write([]string{
+		strconv.FormatFloat(e.Timestamp, 'f', -1, 64),
+		e.EventType,
+		e.EventLevel,
+		e.Content,
+		strconv.FormatFloat(e.Value, 'f', -1, 64),
+		e.Attributes,
+		strconv.FormatInt(e.SessionMs, 10),
+		strconv.FormatInt(e.Sequence, 10),
+		e.Source,
+	})
+}
+
+func (s *csvSink) Flush(int64) error {
+	s.w.Flush()
+	return s.w.Error()
+}
+
+func (s *csvSink) Close() error {
+	s.w.Flush()
+	err := s.w.Error()
+	if cerr := s.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,86 @@
+package events
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Event log formats, selectable with --event-sinks. Each writes one file in
+// the session directory.
+const (
+	FormatParquet = "parquet"
+	FormatNDJSON  = "ndjson"
+	FormatCSV     = "csv"
+	FormatSQLite  = "sqlite"
+)
+
+// Files written by each format.
+const (
+	ParquetFile = "events.parquet" // rolled into events_###.parquet when recording; see RolledFiles
+	NDJSONFile  = "events.ndjson"
+	CSVFile     = "events.csv"
+	SQLiteFile  = "events.db"
+)
+
+// Formats lists the valid formats.
+var Formats = []string{FormatParquet, FormatNDJSON, FormatCSV, FormatSQLite}
+
+// ParseFormats parses a comma-separated list of formats, e.g. "parquet,ndjson".
+func ParseFormats(s string) ([]string, error) {
+	var formats []string
+	seen := make(map[string]bool)
+	for _, f := range strings.Split(s, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		switch {
+		case f == "" || seen[f]:
+			continue
+		case f == FormatSQLite && !sqliteSupported:
+			return nil, errors.New("sqlite: this build has no SQLite support (build with CGO_ENABLED=1)")
+		case f != FormatParquet && f != FormatNDJSON && f != FormatCSV && f != FormatSQLite:
+			return nil, fmt.Errorf("unknown format %q (use %s)", f, strings.Join(Formats, ", "))
+		}
+		seen[f] = true
+		formats = append(formats, f)
+	}
+	if len(formats) == 0 {
+		return nil, errors.New("at least one format is required")
+	}
+	return formats, nil
+}
+
+// OpenSinks creates one sink per format in dir. Parquet is rolled every
+// rollInterval (and rollSize bytes), keeping the files of the last keep
+// intervals if keep > 0, see NewParquetSink. If any sink cannot be created,
+// the ones already open are closed.
+func OpenSinks(dir string, formats []string, rollInterval time.Duration, rollSize int64, keep int) ([]Sink, error) {
+	var sinks []Sink
+	for _, f := range formats {
+		var (
+			s   Sink
+			err error
+		)
+		switch f {
+		case FormatParquet:
+			s, err = NewParquetSink(filepath.Join(dir, ParquetFile), rollInterval, rollSize, keep)
+		case FormatNDJSON:
+			s, err = NewNDJSONSink(filepath.Join(dir, NDJSONFile))
+		case FormatCSV:
+			s, err = NewCSVSink(filepath.Join(dir, CSVFile))
+		case FormatSQLite:
+			s, err = NewSQLiteSink(filepath.Join(dir, SQLiteFile))
+		default:
+			err = fmt.Errorf("unknown format %q", f)
+		}
+		if err != nil {
+			for _, open := range sinks {
+				_ = open.Close()
+			}
+			return nil, fmt.Errorf("%s sink: %w", f, err)
+		}
+		sinks = append(sinks, s)
+	}
+	return sinks, nil
+}
@@ -0,0 +1,216 @@
+package events
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"polytube/replay/pkg/models"
+)
+
+// Filter selects events by type, level, content and session time. Zero fields
+// match everything.
+type Filter struct {
+	Types   []string       // event types; "level loaded" matches LEVEL_LOADED
+	Levels  []string       // event levels; aliases such as WARN or ERR are accepted
+	Content string         // case-insensitive substring of the content
+	From    *time.Duration // session time of the first event to keep
+	To      *time.Duration // session time after which events are dropped
+}
+
+// Match reports whether e passes the filter.
+func (f Filter) Match(e models.Event) bool {
+	if len(f.Types) > 0 && !matchAny(f.Types, e.EventType, normalizeType) {
+		return false
+	}
+	if len(f.Levels) > 0 && !matchAny(f.Levels, e.EventLevel, normalizeLevelName) {
+		return false
+	}
+	if f.Content != "" && !strings.Contains(strings.ToLower(e.Content), strings.ToLower(f.Content)) {
+		return false
+	}
+	at := time.Duration(e.SessionMs) * time.Millisecond
+	if f.From != nil && at < *f.From {
+		return false
+	}
+	if f.To != nil && at > *f.To {
+		return false
+	}
+	return true
+}
+
+// Apply returns the events that pass the filter.
+func (f Filter) Apply(events []models.Event) []models.Event {
+	var out []models.Event
+	for _, e := range events {
+		if f.Match(e) {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// matchAny reports whether s equals any of list after normalizing both.
+func matchAny(list []string, s string, normalize func(string) string) bool {
+	s = normalize(s)
+	for _, v := range list {
+		if normalize(v) == s {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeLevelName is NormalizeLevel for console levels and upper case for
+// the input device levels (MOUSE, KEYBOARD, ...).
+func normalizeLevelName(s string) string {
+	if level, ok := NormalizeLevel(s); ok {
+		return level
+	}
+	return strings.ToUpper(strings.TrimSpace(s))
+}
+
+// ButtonCount is how often a key or button was pressed.
+type ButtonCount struct {
+	Name    string `json:"name"`
+	Device  string `json:"device"` // KEYBOARD, MOUSE or JOYPAD
+	Presses int    `json:"presses"`
+}
+
+// MessageCount is how often a console message was logged.
+type MessageCount struct {
+	Message string `json:"message"`
+	Count   int    `json:"count"`
+}
+
+// MarkerNote is a marker dropped during the session.
+type MarkerNote struct {
+	SessionMs int64  `json:"session_ms"`
+	Note      string `json:"note,omitempty"`
+}
+
+// Summary condenses the events of a session for a quick sanity check.
+type Summary struct {
+	Events         int            `json:"events"`
+	ByType         map[string]int `json:"by_type"`
+	DurationMs     int64          `json:"duration_ms"` // from RECORDING_STARTED to the last event
+	KeyPresses     int            `json:"key_presses"`
+	KeysPerMinute  []int          `json:"keys_per_minute"` // key presses in each minute of the session
+	TopButtons     []ButtonCount  `json:"top_buttons"`
+	ConsoleErrors  int            `json:"console_errors"`
+	ConsoleWarns   int            `json:"console_warnings"`
+	TopErrors      []MessageCount `json:"top_errors,omitempty"`
+	Markers        []MarkerNote   `json:"markers,omitempty"`
+	DroppedEvents  int            `json:"dropped_events"`    // reported by EVENTS_DROPPED
+	SequenceGaps   int64          `json:"sequence_gaps"`     // events missing from the sequence
+	RecordingStart bool           `json:"recording_started"` // false if RECORDING_STARTED is missing
+}
+
+// maxTop bounds the TopButtons and TopErrors lists.
+const maxTop = 10
+
+// Summarize computes the summary of events read from one session.
+//
+// A press is a key or button going down: an INPUT_LOG event with value 1 whose
+// previous value was not 1, so auto-repeat is not counted twice. Gamepad
+// sticks and triggers are axes, not buttons, and are skipped.
+func Summarize(events []models.Event) Summary {
+	s := Summary{Events: len(events), ByType: make(map[string]int)}
+	down := make(map[string]bool)
+	presses := make(map[string]*ButtonCount)
+	errorCounts := make(map[string]int)
+	var lastSeq, maxMs int64
+
+	for _, e := range events {
+		s.ByType[e.EventType]++
+		if e.SessionMs > maxMs {
+			maxMs = e.SessionMs
+		}
+		if e.Sequence > 0 {
+			if lastSeq > 0 && e.Sequence > lastSeq+1 {
+				s.SequenceGaps += e.Sequence - lastSeq - 1
+			}
+			lastSeq = max(lastSeq, e.Sequence)
+		}
+
+		switch e.EventType {
+		case models.EventTypeRecordingStarted.String():
+			s.RecordingStart = true
+		case models.EventTypeEventsDropped.String():
+			s.DroppedEvents += int(e.Value)
+		case models.EventTypeMarker.String():
+			s.Markers = append(s.Markers, MarkerNote{SessionMs: e.SessionMs, Note: e.Content})
+		case models.EventTypeInputLog.String():
+			if IsAxis(e) {
+				continue
+			}
+			key := e.EventLevel + "\x00" + e.Content
+			pressed := e.Value == 1
+			if pressed && !down[key] {
+				b := presses[key]
+				if b == nil {
+					b = &ButtonCount{Name: e.Content, Device: e.EventLevel}
+					presses[key] = b
+				}
+				b.Presses++
+				if e.EventLevel == models.EventLevelKeyboard.String() {
+					s.KeyPresses++
+					if e.SessionMs >= 0 {
+						minute := int(e.SessionMs / 60000)
+						for len(s.KeysPerMinute) <= minute {
+							s.KeysPerMinute = append(s.KeysPerMinute, 0)
+						}
+						s.KeysPerMinute[minute]++
+					}
+				}
+			}
+			down[key] = pressed
+		default:
+			switch e.EventLevel {
+			case models.EventLevelError.String():
+				s.ConsoleErrors++
+				errorCounts[e.Content]++
+			case models.EventLevelWarning.String():
+				s.ConsoleWarns++
+			}
+		}
+	}
+	s.DurationMs = maxMs
+
+	for _, b := range presses {
+		s.TopButtons = append(s.TopButtons, *b)
+	}
+	sort.Slice(s.TopButtons, func(i, j int) bool {
+		a, b := s.TopButtons[i], s.TopButtons[j]
+		if a.Presses != b.Presses {
+			return a.Presses > b.Presses
+		}
+		return a.Name < b.Name
+	})
+	if len(s.TopButtons) > maxTop {
+		s.TopButtons = s.TopButtons[:maxTop]
+	}
+
+	for msg, n := range errorCounts {
+		s.TopErrors = append(s.TopErrors, MessageCount{Message: msg, Count: n})
+	}
+	sort.Slice(s.TopErrors, func(i, j int) bool {
+		a, b := s.TopErrors[i], s.TopErrors[j]
+		if a.Count != b.Count {
+			return a.Count > b.Count
+		}
+		return a.Message < b.Message
+	})
+	if len(s.TopErrors) > maxTop {
+		s.TopErrors = s.TopErrors[:maxTop]
+	}
+	return s
+}
+
+// IsAxis reports whether an input event is a gamepad stick or trigger.
+func IsAxis(e models.Event) bool {
+	if e.EventLevel != models.EventLevelJoypad.String() {
+		return false
+	}
+	return strings.Contains(e.Content, "Stick") || strings.Contains(e.Content, "Trigger") || strings.HasPrefix(e.Content, "Axis")
+}
@@ -0,0 +1,172 @@
+package export
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/pkg/models"
+)
+
+// ClipOptions configures Clips and CutClips.
+type ClipOptions struct {
+	SessionDir string        // recorded session directory
+	Dest       string        // directory for the clips; created if missing
+	FFmpegPath string        // cuts the video
+	Before     time.Duration // video kept before each marker
+	After      time.Duration // video kept after each marker
+	Exact      bool          // re-encode so clips start on the exact frame; see CutMP4
+	Offset     time.Duration // added to event times to line them up with the video
+	Types      []string      // event types to cut around; nil means MARKER
+}
+
+func (o ClipOptions) withDefaults() ClipOptions {
+	if len(o.Types) == 0 {
+		o.Types = []string{models.EventTypeMarker.String()}
+	}
+	return o
+}
+
+// Clip is the part of a session around one marker.
+type Clip struct {
+	Name   string         // file name without extension, e.g. marker_001_00-12-34
+	Marker models.Event   // the event the clip was cut around
+	Start  time.Duration  // video time of the first frame
+	End    time.Duration  // video time after the last frame
+	Events []models.Event // events inside the clip, with SessionMs relative to Start
+	Video  string         // MP4 written by CutClips
+	Log    string         // NDJSON event slice written by CutClips
+}
+
+// Clips returns a clip for every event in list whose type is one of
+// opts.Types, in session order. Clips of markers close to each other overlap;
+// each marker gets its own.
+func Clips(list []models.Event, opts ClipOptions) []Clip {
+	opts = opts.withDefaults()
+	filter := events.Filter{Types: opts.Types}
+	var clips []Clip
+	for _, m := range list {
+		if !filter.Match(m) {
+			continue
+		}
+		at := time.Duration(m.SessionMs)*time.Millisecond + opts.Offset
+		c := Clip{
+			Name:   fmt.Sprintf("%s_%03d_%s", strings.ToLower(m.EventType), len(clips)+1, clipTime(at)),
+			Marker: m,
+			Start:  max(at-opts.Before, 0),
+			End:    max(at+opts.After, 0),
+		}
+		for _, e := range list {
+			t := time.Duration(e.SessionMs)*time.Millisecond + opts.Offset
+			if t < c.Start || t > c.End {
+				continue
+			}
+			e.SessionMs = (t - c.Start).Milliseconds()
+			c.Events = append(c.Events, e)
+		}
+		clips = append(clips, c)
+	}
+	return clips
+}
+
+// clipTime formats a video time for file names: 00-12-34.
+func clipTime(d time.Duration) string {
+	s := int(max(d, 0) / time.Second)
+	return fmt.Sprintf("%02d-%02d-%02d", s/3600, s/60%60, s%60)
+}
+
+// CutClips writes every clip of the session in opts.SessionDir to opts.Dest:
+// <name>.mp4 with the video and <name>.ndjson with the events inside it, their
+// sessionMs counted from the start of the clip. It returns the clips written;
+// a session without markers returns none and no error.
+func CutClips(opts ClipOptions) ([]Clip, error) {
+	opts = opts.withDefaults()
+	if opts.SessionDir == "" || opts.Dest == "" {
+		return nil, errors.New("export: SessionDir and Dest are required")
+	}
+	list, err := events.ReadSession(opts.SessionDir)
+	if err != nil {
+		return nil, fmt.Errorf("read events: %w", err)
+	}
+	clips := Clips(list, opts)
+	if len(clips) == 0 {
+		return nil, nil
+	}
+	playlist := filepath.Join(opts.SessionDir, PlaylistFile)
+	segments, err := PlaylistSegments(playlist)
+	if err != nil {
+		return nil, fmt.Errorf("read video: %w", err)
+	}
+	if len(segments) == 0 {
+		return nil, fmt.Errorf("read video: %s lists no segments", playlist)
+	}
+
+	if err := os.MkdirAll(opts.Dest, 0o755); err != nil {
+		return nil, fmt.Errorf("create %s: %w", opts.Dest, err)
+	}
+	for i := range clips {
+		c := &clips[i]
+		c.Video = filepath.Join(opts.Dest, c.Name+".mp4")
+		if err := CutMP4(opts.FFmpegPath, playlist, c.Video, c.Start, c.End-c.Start, opts.Exact); err != nil {
+			return clips[:i], fmt.Errorf("cut %s: %w", c.Name, err)
+		}
+		c.Log = filepath.Join(opts.Dest, c.Name+".ndjson")
+		if err := writeNDJSON(c.Log, c.Events); err != nil {
+			return clips[:i], fmt.Errorf("write %s: %w", c.Log, err)
+		}
+	}
+	return clips, nil
+}
+
+// CutMP4 copies length of the video of an HLS playlist from start on into an
+// MP4 file. Without exact the video is not re-encoded: the clip has to begin
+// at the keyframe before start (up to two seconds earlier at the recorder's
+// keyframe interval) and an edit list hides the extra frames, which some
+// players ignore. With exact it is re-encoded and begins at start.
+func CutMP4(ffmpegPath, playlist, dest string, start, length time.Duration, exact bool) error {
+	args := []string{
+		"-ss", seconds(start),
+		"-i", playlist,
+		"-t", seconds(length),
+		"-an",
+	}
+	if exact {
+		args = append(args, "-c:v", "libx264", "-preset", "veryfast", "-crf", "23", "-pix_fmt", "yuv420p")
+	} else {
+		args = append(args, "-c", "copy")
+	}
+	return runFFmpeg(ffmpegPath, append(args, "-movflags", "+faststart", dest)...)
+}
+
+// seconds formats d as FFmpeg seconds with millisecond precision.
+func seconds(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
+
+// writeNDJSON writes list to path, one event per line.
+func writeNDJSON(path string, list []models.Event) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for _, e := range list {
+		b, err := events.MarshalEvent(e)
+		if err != nil {
+			f.Close()
+			return err
+		}
+		w.Write(append(b, '\n'))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,83 @@
+// Package export turns a recorded session directory (playlist.m3u8,
+// output_###.ts and the event files) into files that can be viewed without
+// polytube.io: an offline HTML replay viewer with the video, an input
+// timeline and the console log synchronized to playback, an MP4 with the
+// input state drawn onto the video, and short clips around markers.
+//
+// Event times are SessionMs, milliseconds since RECORDING_STARTED, which is
+// logged right after FFmpeg starts, so they map onto the video timeline
+// up to FFmpeg's start-up time. An offset shifts the events for recordings where
+// that lag is noticeable.
+package export
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// PlaylistFile is the HLS playlist the recorder writes into a session directory.
+const PlaylistFile = "playlist.m3u8"
+
+// PlaylistSegments returns the segment files listed in an HLS playlist, in order.
+func PlaylistSegments(playlist string) ([]string, error) {
+	f, err := os.Open(playlist)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var segments []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		segments = append(segments, filepath.Base(line))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read playlist: %w", err)
+	}
+	return segments, nil
+}
+
+// RemuxMP4 copies the video of an HLS playlist into an MP4 file without
+// re-encoding. The moov atom is moved to the front so browsers can seek before
+// the whole file is loaded.
+func RemuxMP4(ffmpegPath, playlist, dest string) error {
+	return runFFmpeg(ffmpegPath,
+		"-i", playlist,
+		"-c", "copy",
+		"-movflags", "+faststart",
+		dest,
+	)
+}
+
+// runFFmpeg runs ffmpeg with the given arguments, overwriting existing outputs,
+// and returns the tail of its output in the error if it fails.
+func runFFmpeg(ffmpegPath string, args ...string) error {
+	if ffmpegPath == "" {
+		return errors.New("ffmpeg path is required")
+	}
+	cmd := exec.Command(ffmpegPath, append([]string{"-hide_banner", "-loglevel", "error", "-y"}, args...)...)
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Run(); err != nil {
+		msg := strings.TrimSpace(out.String())
+		if len(msg) > 2000 {
+			msg = "..." + msg[len(msg)-2000:]
+		}
+		if msg == "" {
+			return fmt.Errorf("ffmpeg: %w", err)
+		}
+		return fmt.Errorf("ffmpeg: %w: %s", err, msg)
+	}
+	return nil
+}
@@ -0,0 +1,177 @@
+package export
+
+import (
+	_ "embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// VideoFormat is how the video is stored in an HTML bundle.
+type VideoFormat string
+
+const (
+	// VideoMP4 remuxes the HLS segments into one video.mp4, which every
+	// browser plays. Needs FFmpeg.
+	VideoMP4 VideoFormat = "mp4"
+	// VideoHLS copies playlist.m3u8 and the segments as they are. Only
+	// browsers with native HLS support (Safari, Edge) play it.
+	VideoHLS VideoFormat = "hls"
+)
+
+// ParseVideoFormat validates a video format name.
+func ParseVideoFormat(s string) (VideoFormat, error) {
+	switch VideoFormat(s) {
+	case VideoMP4, VideoHLS:
+		return VideoFormat(s), nil
+	}
+	return "", fmt.Errorf("unknown video format %q (use mp4 or hls)", s)
+}
+
+// HTMLFile is the page of an HTML bundle.
+const HTMLFile = "index.html"
+
+// Detail is one name/value line shown in the header of the viewer.
+type Detail struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// HTMLOptions configures HTML.
+type HTMLOptions struct {
+	SessionDir string        // recorded session directory
+	Dest       string        // bundle directory; created if missing
+	FFmpegPath string        // remuxes the video; VideoMP4 only
+	Video      VideoFormat   // zero value is VideoMP4
+	Title      string        // page title, e.g. the session ID
+	Details    []Detail      // shown under the title: game, build, machine, ...
+	Offset     time.Duration // added to event times to line them up with the video
+}
+
+//go:embed viewer.html
+var viewerHTML string
+
+var viewerTemplate = template.Must(template.New("viewer").Parse(viewerHTML))
+
+// viewerData is everything the page needs, embedded into it as JSON so the
+// bundle works from file:// where browsers block fetching local files.
+type viewerData struct {
+	Title            string       `json:"title"`
+	Details          []Detail     `json:"details"`
+	Video            string       `json:"video"`
+	VideoType        string       `json:"videoType"`
+	DurationMs       int64        `json:"durationMs"` // last event; the player knows the video duration
+	OffsetMs         int64        `json:"offsetMs"`
+	RecordingStarted bool         `json:"recordingStarted"`
+	Spans            []Span       `json:"spans"`
+	Axes             []AxisSample `json:"axes"`
+	Log              []logEntry   `json:"log"`
+}
+
+// logEntry is one line of the console pane: any event but input.
+type logEntry struct {
+	Ms         int64   `json:"ms"`
+	Type       string  `json:"type"`
+	Level      string  `json:"level"`
+	Content    string  `json:"content"`
+	Value      float64 `json:"value"`
+	Source     string  `json:"source"`
+	Attributes string  `json:"attributes"`
+}
+
+// HTML writes an offline replay viewer for the session in opts.SessionDir to
+// opts.Dest: index.html with the events embedded, next to the video. It
+// returns the path of index.html.
+func HTML(opts HTMLOptions) (string, error) {
+	if opts.Video == "" {
+		opts.Video = VideoMP4
+	}
+	if opts.SessionDir == "" || opts.Dest == "" {
+		return "", errors.New("export: SessionDir and Dest are required")
+	}
+
+	list, err := events.ReadSession(opts.SessionDir)
+	if err != nil {
+		return "", fmt.Errorf("read events: %w", err)
+	}
+	playlist := filepath.Join(opts.SessionDir, PlaylistFile)
+	segments, err := PlaylistSegments(playlist)
+	if err != nil {
+		return "", fmt.Errorf("read video: %w", err)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("read video: %s lists no segments", playlist)
+	}
+
+	if err := os.MkdirAll(opts.Dest, 0o755); err != nil {
+		return "", fmt.Errorf("create %s: %w", opts.Dest, err)
+	}
+	data := viewerData{
+		Title:    opts.Title,
+		Details:  opts.Details,
+		OffsetMs: opts.Offset.Milliseconds(),
+		Log:      []logEntry{},
+	}
+	switch opts.Video {
+	case VideoMP4:
+		data.Video, data.VideoType = "video.mp4", "video/mp4"
+		if err := RemuxMP4(opts.FFmpegPath, playlist, filepath.Join(opts.Dest, data.Video)); err != nil {
+			return "", fmt.Errorf("remux video: %w", err)
+		}
+	case VideoHLS:
+		data.Video, data.VideoType = PlaylistFile, "application/vnd.apple.mpegurl"
+		for _, name := range append([]string{PlaylistFile}, segments...) {
+			if err := utils.CopyFile(filepath.Join(opts.SessionDir, name), filepath.Join(opts.Dest, name)); err != nil {
+				return "", fmt.Errorf("copy video: %w", err)
+			}
+		}
+	default:
+		return "", fmt.Errorf("export: unknown video format %q", opts.Video)
+	}
+
+	for _, e := range list {
+		data.DurationMs = max(data.DurationMs, e.SessionMs)
+		switch e.EventType {
+		case models.EventTypeInputLog.String():
+			continue
+		case models.EventTypeRecordingStarted.String():
+			data.RecordingStarted = true
+		}
+		data.Log = append(data.Log, logEntry{
+			Ms:         e.SessionMs,
+			Type:       e.EventType,
+			Level:      e.EventLevel,
+			Content:    e.Content,
+			Value:      e.Value,
+			Source:     e.Source,
+			Attributes: e.Attributes,
+		})
+	}
+	// The viewer binary-searches the log by time; client timestamps can
+	// arrive out of order.
+	sort.SliceStable(data.Log, func(i, j int) bool { return data.Log[i].Ms < data.Log[j].Ms })
+	data.Spans = InputSpans(list, data.DurationMs)
+	data.Axes = Axes(list)
+
+	path := filepath.Join(opts.Dest, HTMLFile)
+	f, err := os.Create(path)
+	if err != nil {
+		return "", fmt.Errorf("create %s: %w", path, err)
+	}
+	if err := viewerTemplate.Execute(f, data); err != nil {
+		f.Close()
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	if err := f.Close(); err != nil {
+		return "", fmt.Errorf("write %s: %w", path, err)
+	}
+	return path, nil
+}
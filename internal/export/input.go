@@ -0,0 +1,62 @@
+package export
+
+import (
+	"sort"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/pkg/models"
+)
+
+// Span is an interval during which a key or button was held down.
+type Span struct {
+	Device  string `json:"device"` // KEYBOARD, MOUSE or JOYPAD
+	Key     string `json:"key"`
+	StartMs int64  `json:"start"` // session time of the press
+	EndMs   int64  `json:"end"`   // session time of the release
+}
+
+// AxisSample is the value of a gamepad stick or trigger from MS on.
+type AxisSample struct {
+	Key   string  `json:"key"`
+	Ms    int64   `json:"ms"`
+	Value float64 `json:"value"`
+}
+
+// InputSpans pairs the presses and releases of the INPUT_LOG events into
+// spans, ordered by start time. Keys still held at the last event are released
+// at endMs. Gamepad axes are not buttons and are skipped (see Axes).
+func InputSpans(list []models.Event, endMs int64) []Span {
+	var spans []Span
+	open := make(map[string]int) // device + key -> index into spans
+	for _, e := range list {
+		if e.EventType != models.EventTypeInputLog.String() || events.IsAxis(e) {
+			continue
+		}
+		id := e.EventLevel + "\x00" + e.Content
+		i, held := open[id]
+		switch {
+		case e.Value == 1 && !held:
+			open[id] = len(spans)
+			spans = append(spans, Span{Device: e.EventLevel, Key: e.Content, StartMs: e.SessionMs, EndMs: -1})
+		case e.Value != 1 && held:
+			spans[i].EndMs = e.SessionMs
+			delete(open, id)
+		}
+	}
+	for _, i := range open {
+		spans[i].EndMs = max(endMs, spans[i].StartMs)
+	}
+	sort.SliceStable(spans, func(i, j int) bool { return spans[i].StartMs < spans[j].StartMs })
+	return spans
+}
+
+// Axes returns the gamepad stick and trigger values of the INPUT_LOG events.
+func Axes(list []models.Event) []AxisSample {
+	var samples []AxisSample
+	for _, e := range list {
+		if e.EventType == models.EventTypeInputLog.String() && events.IsAxis(e) {
+			samples = append(samples, AxisSample{Key: e.Content, Ms: e.SessionMs, Value: e.Value})
+		}
+	}
+	return samples
+}
@@ -0,0 +1,604 @@
+package export
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// Layout is a panel of the input overlay.
+type Layout string
+
+const (
+	// LayoutKeyboard draws a keyboard and the mouse buttons. Held keys light
+	// up; the other keys are colored by how often they were pressed in the
+	// session, as a heatmap.
+	LayoutKeyboard Layout = "keyboard"
+	// LayoutController draws a gamepad: buttons, bumpers, the trigger travel
+	// and the position of both sticks.
+	LayoutController Layout = "controller"
+)
+
+// Layouts lists the valid layouts in their default order.
+var Layouts = []Layout{LayoutKeyboard, LayoutController}
+
+// ParseLayouts parses a comma-separated list of layouts, drawn side by side in
+// that order.
+func ParseLayouts(s string) ([]Layout, error) {
+	var layouts []Layout
+	for _, name := range strings.Split(s, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		switch Layout(name) {
+		case LayoutKeyboard, LayoutController:
+			layouts = append(layouts, Layout(name))
+		default:
+			return nil, fmt.Errorf("unknown layout %q (use keyboard or controller)", name)
+		}
+	}
+	if len(layouts) == 0 {
+		return nil, errors.New("no layout given (use keyboard, controller or both)")
+	}
+	return layouts, nil
+}
+
+// Corner is where the overlay panels are placed on the video.
+type Corner string
+
+const (
+	CornerBottomLeft  Corner = "bottom-left"
+	CornerBottomRight Corner = "bottom-right"
+	CornerTopLeft     Corner = "top-left"
+	CornerTopRight    Corner = "top-right"
+)
+
+// ParseCorner validates a corner name.
+func ParseCorner(s string) (Corner, error) {
+	switch c := Corner(strings.ToLower(strings.TrimSpace(s))); c {
+	case CornerBottomLeft, CornerBottomRight, CornerTopLeft, CornerTopRight:
+		return c, nil
+	}
+	return "", fmt.Errorf("unknown position %q (use bottom-left, bottom-right, top-left or top-right)", s)
+}
+
+// OverlayOptions configures Overlay and OverlayFilter.
+type OverlayOptions struct {
+	SessionDir string        // recorded session directory
+	Dest       string        // MP4 file to write; the filter script is written next to it
+	FFmpegPath string        // renders the video
+	Layouts    []Layout      // panels, side by side; nil draws all Layouts
+	Corner     Corner        // zero value is CornerBottomLeft
+	Scale      float64       // size of the panels; zero means 1
+	Offset     time.Duration // added to event times to line them up with the video
+	FontFile   string        // TrueType font for the key labels; empty uses FFmpeg's default font
+}
+
+func (o OverlayOptions) withDefaults() OverlayOptions {
+	if len(o.Layouts) == 0 {
+		o.Layouts = Layouts
+	}
+	if o.Corner == "" {
+		o.Corner = CornerBottomLeft
+	}
+	if o.Scale <= 0 {
+		o.Scale = 1
+	}
+	return o
+}
+
+// The video is scaled to the size the recorder writes before the overlay is
+// drawn, so the panels have the same size on every recording.
+const (
+	overlayWidth  = 1280
+	overlayHeight = 720
+	overlayUnit   = 24 // pixels per key at Scale 1
+	overlayMargin = 16 // pixels between the panels and the edge of the video
+)
+
+// Overlay renders the video of a session to opts.Dest with the input state
+// drawn on top. The FFmpeg filter script (see OverlayFilter) is kept next to
+// the MP4 as <name>.filter.txt so it can be tweaked and rerun by hand. It
+// returns the path of the filter script.
+func Overlay(opts OverlayOptions) (string, error) {
+	opts = opts.withDefaults()
+	if opts.SessionDir == "" || opts.Dest == "" {
+		return "", errors.New("export: SessionDir and Dest are required")
+	}
+	list, err := events.ReadSession(opts.SessionDir)
+	if err != nil {
+		return "", fmt.Errorf("read events: %w", err)
+	}
+	playlist := filepath.Join(opts.SessionDir, PlaylistFile)
+	segments, err := PlaylistSegments(playlist)
+	if err != nil {
+		return "", fmt.Errorf("read video: %w", err)
+	}
+	if len(segments) == 0 {
+		return "", fmt.Errorf("read video: %s lists no segments", playlist)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Dest), 0o755); err != nil {
+		return "", fmt.Errorf("create %s: %w", filepath.Dir(opts.Dest), err)
+	}
+	scriptPath := strings.TrimSuffix(opts.Dest, filepath.Ext(opts.Dest)) + ".filter.txt"
+	if err := os.WriteFile(scriptPath, []byte(OverlayFilter(list, opts)), 0o644); err != nil {
+		return "", fmt.Errorf("write filter script: %w", err)
+	}
+	err = runFFmpeg(opts.FFmpegPath,
+		"-i", playlist,
+		"-/filter:v", scriptPath, // read the filter graph from the file (FFmpeg 7+)
+		"-an",
+		"-c:v", "libx264",
+		"-preset", "veryfast",
+		"-crf", "23",
+		"-pix_fmt", "yuv420p",
+		"-movflags", "+faststart",
+		opts.Dest,
+	)
+	if err != nil {
+		return scriptPath, fmt.Errorf("render video: %w", err)
+	}
+	return scriptPath, nil
+}
+
+// OverlayFilter returns the FFmpeg filter graph that draws the input state of
+// list onto the video: a chain of drawbox and drawtext filters whose enable
+// expressions switch them on while a key is held or a stick is in a position.
+func OverlayFilter(list []models.Event, opts OverlayOptions) string {
+	opts = opts.withDefaults()
+	var endMs int64
+	for _, e := range list {
+		endMs = max(endMs, e.SessionMs)
+	}
+	spans := InputSpans(list, endMs)
+	axes := Axes(list)
+
+	f := &filterScript{unit: overlayUnit * opts.Scale, fontFile: opts.FontFile, offset: opts.Offset}
+	f.add(fmt.Sprintf("setpts=PTS-STARTPTS,scale=%d:%d,setsar=1", overlayWidth, overlayHeight))
+
+	// Lay the panels out side by side from the corner.
+	right := opts.Corner == CornerBottomRight || opts.Corner == CornerTopRight
+	bottom := opts.Corner == CornerBottomLeft || opts.Corner == CornerBottomRight
+	x := float64(overlayMargin)
+	if right {
+		x = overlayWidth - overlayMargin
+	}
+	for _, layout := range opts.Layouts {
+		w, h := keyboardSize.w, keyboardSize.h
+		if layout == LayoutController {
+			w, h = controllerSize.w, controllerSize.h
+		}
+		w, h = (w+2*panelPadding)*f.unit, (h+2*panelPadding)*f.unit
+		if right {
+			x -= w
+		}
+		f.x0 = x + panelPadding*f.unit
+		f.y0 = overlayMargin + panelPadding*f.unit
+		if bottom {
+			f.y0 = overlayHeight - overlayMargin - h + panelPadding*f.unit
+		}
+		f.panel(w, h)
+		switch layout {
+		case LayoutKeyboard:
+			f.keyboard(spans)
+		case LayoutController:
+			f.controller(spans, axes)
+		}
+		if right {
+			x -= overlayMargin
+		} else {
+			x += w + overlayMargin
+		}
+	}
+	f.add("format=yuv420p")
+	return f.String()
+}
+
+// panelPadding is the space around the keys of a panel, in keys.
+const panelPadding = 0.3
+
+// rect is a rectangle in keys, relative to the top left corner of a panel.
+type rect struct{ x, y, w, h float64 }
+
+// overlayKey is a key or button of a panel and the input names it shows.
+type overlayKey struct {
+	label  string
+	device string // MOUSE or JOYPAD; empty for the keyboard (KEYBOARD or UNKNOWN_DEVICE)
+	names  []string
+	rect
+}
+
+// keyboardSize is the size of the keyboard panel in keys.
+var keyboardSize = rect{w: 18, h: 5}
+
+// keyboardKeys is a compact US keyboard plus the mouse buttons. Keys the
+// listener has no name for are logged as their virtual-key code ("0xBA").
+var keyboardKeys = func() []overlayKey {
+	var keys []overlayKey
+	row := func(y, x float64, labels ...string) {
+		for _, l := range labels {
+			keys = append(keys, overlayKey{label: l, names: []string{"VK_" + l}, rect: rect{x, y, 1, 1}})
+			x++
+		}
+	}
+	key := func(label string, x, y, w float64, names ...string) {
+		keys = append(keys, overlayKey{label: label, names: names, rect: rect{x, y, w, 1}})
+	}
+	key("Esc", 0, 0, 1, "VK_ESCAPE")
+	row(0, 1, "1", "2", "3", "4", "5", "6", "7", "8", "9", "0")
+	key("-", 11, 0, 1, "0xBD")
+	key("=", 12, 0, 1, "0xBB")
+	key("Bksp", 13, 0, 1.5, "VK_BACK")
+	key("Tab", 0, 1, 1.5, "VK_TAB")
+	row(1, 1.5, "Q", "W", "E", "R", "T", "Y", "U", "I", "O", "P")
+	key("[", 11.5, 1, 1, "0xDB")
+	key("]", 12.5, 1, 1, "0xDD")
+	key(`\`, 13.5, 1, 1, "0xDC")
+	key("Caps", 0, 2, 1.75, "VK_CAPITAL")
+	row(2, 1.75, "A", "S", "D", "F", "G", "H", "J", "K", "L")
+	key(";", 10.75, 2, 1, "0xBA")
+	key("'", 11.75, 2, 1, "0xDE")
+	key("Enter", 12.75, 2, 1.75, "VK_RETURN")
+	key("Shift", 0, 3, 2.25, "VK_SHIFT", "0xA0", "0xA1")
+	row(3, 2.25, "Z", "X", "C", "V", "B", "N", "M")
+	key(",", 9.25, 3, 1, "0xBC")
+	key(".", 10.25, 3, 1, "0xBE")
+	key("/", 11.25, 3, 1, "0xBF")
+	key("Up", 12.5, 3, 1, "VK_UP")
+	key("Ctrl", 0, 4, 1.5, "VK_CONTROL", "0xA2", "0xA3")
+	key("Alt", 1.5, 4, 1.5, "VK_MENU", "0xA4", "0xA5")
+	key("Space", 3, 4, 7, "VK_SPACE")
+	key("Lt", 11.5, 4, 1, "VK_LEFT")
+	key("Dn", 12.5, 4, 1, "VK_DOWN")
+	key("Rt", 13.5, 4, 1, "VK_RIGHT")
+	mouse := func(label string, x float64, name string) {
+		keys = append(keys, overlayKey{label: label, device: models.EventLevelMouse.String(), names: []string{name}, rect: rect{x, 0, 1, 2}})
+	}
+	mouse("L", 15, "VK_LBUTTON")
+	mouse("M", 16, "VK_MBUTTON")
+	mouse("R", 17, "VK_RBUTTON")
+	return keys
+}()
+
+// controllerSize is the size of the controller panel in keys.
+var controllerSize = rect{w: 10, h: 6.4}
+
+// controllerButtons are the gamepad buttons, named as by the gamepad listener.
+var controllerButtons = func() []overlayKey {
+	b := func(label, name string, x, y, w, h float64) overlayKey {
+		return overlayKey{label: label, device: models.EventLevelJoypad.String(), names: []string{name}, rect: rect{x, y, w, h}}
+	}
+	return []overlayKey{
+		b("LB", "LeftBumper", 0.5, 0, 2, 0.7),
+		b("RB", "RightBumper", 7.5, 0, 2, 0.7),
+		b("Back", "Back", 3.5, 1.8, 1.2, 0.7),
+		b("Start", "Start", 5.3, 1.8, 1.2, 0.7),
+		b("Home", "Home", 4.4, 2.7, 1.2, 0.7),
+		b("Y", "Y", 7.9, 1.7, 0.8, 0.8),
+		b("X", "X", 7.1, 2.5, 0.8, 0.8),
+		b("B", "B", 8.7, 2.5, 0.8, 0.8),
+		b("A", "A", 7.9, 3.3, 0.8, 0.8),
+		b("^", "DpadUp", 2.6, 3.7, 0.8, 0.8),
+		b("<", "DpadLeft", 1.8, 4.5, 0.8, 0.8),
+		b(">", "DpadRight", 3.4, 4.5, 0.8, 0.8),
+		b("v", "DpadDown", 2.6, 5.3, 0.8, 0.8),
+	}
+}()
+
+// controllerSticks are the stick areas and the button of pressing the stick.
+var controllerSticks = []struct {
+	x, y   string // axis names
+	button string
+	area   rect
+}{
+	{"LeftStickX", "LeftStickY", "LeftStick", rect{0.5, 1.7, 2, 2}},
+	{"RightStickX", "RightStickY", "RightStick", rect{5.5, 4.1, 2, 2}},
+}
+
+// controllerTriggers are the trigger bars, filled by how far a trigger is pulled.
+var controllerTriggers = []struct {
+	label, axis string
+	bar         rect
+}{
+	{"LT", "LeftTrigger", rect{0.5, 0.8, 2, 0.6}},
+	{"RT", "RightTrigger", rect{7.5, 0.8, 2, 0.6}},
+}
+
+// Colors of the overlay.
+const (
+	colorPanel = "black@0.45"
+	colorKey   = "0x3c3c3c@0.75"
+	colorHeld  = "0x5aa9ff@0.9"
+	colorText  = "white"
+	colorDot   = "white@0.95"
+)
+
+const (
+	stickSteps = 5   // positions per stick axis, and trigger levels
+	stickDot   = 0.6 // size of the stick position dot in keys
+)
+
+// interval is a time range of the video in seconds. A negative end means open.
+type interval struct{ start, end float64 }
+
+// maxTerms caps the between() terms of one enable expression; longer lists are
+// split over several filters to keep FFmpeg's expression parser shallow.
+const maxTerms = 200
+
+// filterScript builds the filter chain. Coordinates are in keys relative to
+// the current panel origin (x0, y0, in pixels).
+type filterScript struct {
+	b        strings.Builder
+	unit     float64
+	x0, y0   float64
+	fontFile string
+	offset   time.Duration
+}
+
+func (f *filterScript) String() string { return f.b.String() + "\n" }
+
+// add appends a filter to the chain.
+func (f *filterScript) add(filter string) {
+	if f.b.Len() > 0 {
+		f.b.WriteString(",\n")
+	}
+	f.b.WriteString(filter)
+}
+
+// seconds converts a session time to video time.
+func (f *filterScript) seconds(ms int64) float64 {
+	return float64(ms)/1000 + f.offset.Seconds()
+}
+
+// panel draws the background of a panel of w x h pixels including padding.
+func (f *filterScript) panel(w, h float64) {
+	pad := panelPadding * f.unit
+	f.add(fmt.Sprintf("drawbox=x=%.0f:y=%.0f:w=%.0f:h=%.0f:color=%s:t=fill", f.x0-pad, f.y0-pad, w, h, colorPanel))
+}
+
+// box draws r (inset by a gap between keys) while any of intervals is
+// active, or always if intervals is nil. thickness is "fill" or a pixel width.
+func (f *filterScript) box(r rect, color, thickness string, intervals []interval) {
+	gap := max(1, f.unit*0.06)
+	args := fmt.Sprintf("drawbox=x=%.0f:y=%.0f:w=%.0f:h=%.0f:color=%s:t=%s",
+		f.x0+r.x*f.unit+gap, f.y0+r.y*f.unit+gap, r.w*f.unit-2*gap, r.h*f.unit-2*gap, color, thickness)
+	if intervals == nil {
+		f.add(args)
+		return
+	}
+	for _, enable := range enableExprs(intervals) {
+		f.add(args + ":enable='" + enable + "'")
+	}
+}
+
+// text draws a label centered in r.
+func (f *filterScript) text(r rect, label string) {
+	size := min(f.unit*0.42, r.h*f.unit*0.6)
+	args := fmt.Sprintf("drawtext=text=%s:expansion=none:fontcolor=%s:fontsize=%.0f:x=%.0f-tw/2:y=%.0f-th/2",
+		utils.EscapeFilterValue(label), colorText, size, f.x0+(r.x+r.w/2)*f.unit, f.y0+(r.y+r.h/2)*f.unit)
+	if f.fontFile != "" {
+		args += ":fontfile=" + utils.EscapeFilterValue(f.fontFile)
+	}
+	f.add(args)
+}
+
+// keyboard draws the keyboard panel.
+func (f *filterScript) keyboard(spans []Span) {
+	held := make([][]interval, len(keyboardKeys))
+	most := 0
+	for i, k := range keyboardKeys {
+		held[i] = f.keySpans(k, spans)
+		most = max(most, len(held[i]))
+	}
+	for i, k := range keyboardKeys {
+		f.box(k.rect, heatColor(len(held[i]), most), "fill", nil)
+		if len(held[i]) > 0 {
+			f.box(k.rect, colorHeld, "fill", held[i])
+		}
+		f.text(k.rect, k.label)
+	}
+}
+
+// controller draws the controller panel.
+func (f *filterScript) controller(spans []Span, axes []AxisSample) {
+	for _, t := range controllerTriggers {
+		f.box(t.bar, colorKey, "fill", nil)
+		samples := axisSamples(axes, t.axis)
+		normalizeTrigger(samples)
+		levels := f.levels(samples, 0, func(v float64) int { return quantize(v, 0, 1) })
+		for level := 1; level < stickSteps; level++ {
+			if in := levels[level]; len(in) > 0 {
+				bar := t.bar
+				bar.w *= float64(level) / (stickSteps - 1)
+				f.box(bar, colorHeld, "fill", in)
+			}
+		}
+		f.text(t.bar, t.label)
+	}
+	for _, b := range controllerButtons {
+		f.box(b.rect, colorKey, "fill", nil)
+		if held := f.keySpans(b, spans); len(held) > 0 {
+			f.box(b.rect, colorHeld, "fill", held)
+		}
+		f.text(b.rect, b.label)
+	}
+	for _, s := range controllerSticks {
+		f.box(s.area, colorKey, "fill", nil)
+		press := overlayKey{device: models.EventLevelJoypad.String(), names: []string{s.button}}
+		if held := f.keySpans(press, spans); len(held) > 0 {
+			f.box(s.area, colorHeld, fmt.Sprintf("%.0f", max(2, f.unit*0.12)), held)
+		}
+		// The dot moves over a grid of stickSteps x stickSteps positions.
+		cells := f.stickCells(axisSamples(axes, s.x), axisSamples(axes, s.y))
+		order := make([]int, 0, len(cells))
+		for cell := range cells {
+			order = append(order, cell)
+		}
+		sort.Ints(order)
+		step := s.area.w / stickSteps
+		for _, cell := range order {
+			cx := s.area.x + (float64(cell%stickSteps)+0.5)*step
+			cy := s.area.y + (float64(cell/stickSteps)+0.5)*step
+			f.box(rect{cx - stickDot/2, cy - stickDot/2, stickDot, stickDot}, colorDot, "fill", cells[cell])
+		}
+	}
+}
+
+// keySpans returns the intervals during which any of the key's names was held.
+func (f *filterScript) keySpans(k overlayKey, spans []Span) []interval {
+	var in []interval
+	for _, s := range spans {
+		if k.device == "" && (s.Device == models.EventLevelMouse.String() || s.Device == models.EventLevelJoypad.String()) {
+			continue
+		}
+		if k.device != "" && s.Device != k.device {
+			continue
+		}
+		for _, name := range k.names {
+			if s.Key == name {
+				in = append(in, interval{f.seconds(s.StartMs), f.seconds(s.EndMs)})
+				break
+			}
+		}
+	}
+	return mergeIntervals(in)
+}
+
+// levels splits the time into intervals by the level of the samples' values.
+// The level is initial from the start of the video to the first sample; the
+// last level stays on.
+func (f *filterScript) levels(samples []AxisSample, initial int, toLevel func(float64) int) map[int][]interval {
+	out := make(map[int][]interval)
+	level, since := initial, 0.0
+	for _, s := range samples {
+		next := toLevel(s.Value)
+		if next == level {
+			continue
+		}
+		at := f.seconds(s.Ms)
+		out[level] = append(out[level], interval{since, at})
+		level, since = next, at
+	}
+	out[level] = append(out[level], interval{since, -1})
+	return out
+}
+
+// stickCells returns, for each grid cell (y*stickSteps+x) of a stick, the
+// intervals during which the stick was in it.
+func (f *filterScript) stickCells(xs, ys []AxisSample) map[int][]interval {
+	merged := make([]AxisSample, 0, len(xs)+len(ys))
+	for _, s := range xs {
+		merged = append(merged, AxisSample{Key: "x", Ms: s.Ms, Value: s.Value})
+	}
+	for _, s := range ys {
+		merged = append(merged, AxisSample{Key: "y", Ms: s.Ms, Value: s.Value})
+	}
+	sort.SliceStable(merged, func(i, j int) bool { return merged[i].Ms < merged[j].Ms })
+
+	// Encode the cell of both axes as the value of one series.
+	var x, y float64
+	cells := make([]AxisSample, len(merged))
+	for i, s := range merged {
+		if s.Key == "x" {
+			x = s.Value
+		} else {
+			y = s.Value
+		}
+		cells[i] = AxisSample{Ms: s.Ms, Value: float64(quantize(y, -1, 1)*stickSteps + quantize(x, -1, 1))}
+	}
+	center := quantize(0, -1, 1)*stickSteps + quantize(0, -1, 1)
+	return f.levels(cells, center, func(v float64) int { return int(v) })
+}
+
+// axisSamples returns the samples of one axis.
+func axisSamples(axes []AxisSample, key string) []AxisSample {
+	var out []AxisSample
+	for _, a := range axes {
+		if a.Key == key {
+			out = append(out, a)
+		}
+	}
+	return out
+}
+
+// normalizeTrigger maps trigger values to 0 (released) .. 1 (pulled). Some
+// gamepads report triggers from -1 at rest, others from 0.
+func normalizeTrigger(samples []AxisSample) {
+	for _, s := range samples {
+		if s.Value < -0.01 {
+			for i := range samples {
+				samples[i].Value = (samples[i].Value + 1) / 2
+			}
+			return
+		}
+	}
+}
+
+// quantize maps v in [lo, hi] to one of stickSteps steps.
+func quantize(v, lo, hi float64) int {
+	v = min(max(v, lo), hi)
+	return int(math.Round((v - lo) / (hi - lo) * (stickSteps - 1)))
+}
+
+// heatColor shades a key from gray (never pressed) to red (pressed the most).
+func heatColor(n, most int) string {
+	if n == 0 || most == 0 {
+		return colorKey
+	}
+	h := math.Sqrt(float64(n) / float64(most)) // so rarely used keys still show
+	lerp := func(a, b float64) int { return int(math.Round(a + (b-a)*h)) }
+	return fmt.Sprintf("0x%02x%02x%02x@0.75", lerp(0x3c, 0xe0), lerp(0x3c, 0x40), lerp(0x3c, 0x30))
+}
+
+// mergeIntervals sorts intervals and joins overlapping ones.
+func mergeIntervals(in []interval) []interval {
+	if len(in) == 0 {
+		return nil
+	}
+	sort.Slice(in, func(i, j int) bool { return in[i].start < in[j].start })
+	out := []interval{in[0]}
+	for _, iv := range in[1:] {
+		last := &out[len(out)-1]
+		switch {
+		case last.end < 0:
+		case iv.start <= last.end:
+			if iv.end < 0 || iv.end > last.end {
+				last.end = iv.end
+			}
+		default:
+			out = append(out, iv)
+		}
+	}
+	return out
+}
+
+// enableExprs builds the enable expressions of intervals, at most maxTerms
+// intervals per expression.
+func enableExprs(intervals []interval) []string {
+	var exprs []string
+	for len(intervals) > 0 {
+		n := min(len(intervals), maxTerms)
+		terms := make([]string, n)
+		for i, iv := range intervals[:n] {
+			if iv.end < 0 {
+				terms[i] = fmt.Sprintf("gte(t,%.3f)", iv.start)
+			} else {
+				terms[i] = fmt.Sprintf("between(t,%.3f,%.3f)", iv.start, iv.end)
+			}
+		}
+		exprs = append(exprs, strings.Join(terms, "+"))
+		intervals = intervals[n:]
+	}
+	return exprs
+}
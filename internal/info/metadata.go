@@ -0,0 +1,87 @@
+//go:build windows
+
+package info
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Metadata keys promoted into typed SessionInfo fields. Matching ignores case,
+// '-' and '_', so "build_number", "buildNumber" and "Build-Number" all match.
+var (
+	buildNumberKeys = []string{"buildnumber", "build"}
+	branchKeys      = []string{"branch", "gitbranch"}
+	playerIDKeys    = []string{"playerid", "player", "userid"}
+)
+
+// ParseMetadata parses the --meta-data JSON. It must be a JSON object; an
+// empty string is treated as "{}".
+func ParseMetadata(s string) (map[string]any, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return map[string]any{}, nil
+	}
+	var metadata map[string]any
+	if err := json.Unmarshal([]byte(s), &metadata); err != nil {
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			return nil, fmt.Errorf("metadata must be a JSON object, got %s", typeErr.Value)
+		}
+		return nil, fmt.Errorf("invalid metadata JSON: %w", err)
+	}
+	if metadata == nil { // "null"
+		return nil, errors.New("metadata must be a JSON object, got null")
+	}
+	return metadata, nil
+}
+
+// SetMetadata attaches the free-form metadata to the session and promotes
+// known keys (build number, branch, player id) into their typed fields. The
+// metadata itself is kept unchanged so the server sees every key.
+func (s *SessionInfo) SetMetadata(metadata map[string]any) {
+	s.Metadata = metadata
+	if v := metadataString(metadata, buildNumberKeys); v != nil {
+		s.BuildNumber = v
+	}
+	if v := metadataString(metadata, branchKeys); v != nil {
+		s.Branch = v
+	}
+	if v := metadataString(metadata, playerIDKeys); v != nil {
+		s.PlayerID = v
+	}
+}
+
+// metadataString returns the first of keys present in metadata as a string.
+// Numbers and booleans are formatted; objects, lists and empty values are ignored.
+func metadataString(metadata map[string]any, keys []string) *string {
+	for _, want := range keys {
+		for k, v := range metadata {
+			if normalizeMetadataKey(k) != want {
+				continue
+			}
+			var s string
+			switch v := v.(type) {
+			case string:
+				s = strings.TrimSpace(v)
+			case float64:
+				s = strconv.FormatFloat(v, 'f', -1, 64)
+			case bool:
+				s = strconv.FormatBool(v)
+			}
+			if s != "" {
+				return &s
+			}
+		}
+	}
+	return nil
+}
+
+func normalizeMetadataKey(k string) string {
+	k = strings.ToLower(k)
+	k = strings.ReplaceAll(k, "_", "")
+	return strings.ReplaceAll(k, "-", "")
+}
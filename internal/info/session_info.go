@@ -69,7 +69,15 @@ type SessionInfo struct {
 
 	Engine *string `json:"engine" db:"engine"`
 
-	Logger logger.LoggerInterface
+	// Promoted from Metadata when it has a known key (see SetMetadata).
+	BuildNumber *string `json:"build_number,omitempty" db:"build_number"`
+	Branch      *string `json:"branch,omitempty" db:"branch"`
+	PlayerID    *string `json:"player_id,omitempty" db:"player_id"`
+
+	// Metadata is the free-form --meta-data JSON object.
+	Metadata map[string]any `json:"metadata,omitempty" db:"metadata"`
+
+	Logger logger.LoggerInterface `json:"-"`
 }
 
 // PopulateInfo fills in all fields it can detect locally
@@ -114,6 +122,9 @@ func (s *SessionInfo) ToSearchParams() []models.SearchParam {
 	add("gpu_driver", s.GPUDriver)
 	add("gpu_vendor", s.GPUVendor)
 	add("os", s.OS)
+	add("build_number", s.BuildNumber)
+	add("branch", s.Branch)
+	add("player_id", s.PlayerID)
 
 	// Handle tags specially — multiple entries: tag=blue,tag=red
 	for _, t := range s.Tags {
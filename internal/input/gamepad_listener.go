@@ -9,6 +9,7 @@ import (
 
 	"polytube/replay/internal/events"
 	"polytube/replay/internal/logger"
+	"polytube/replay/internal/marker"
 	"polytube/replay/pkg/models"
 	"polytube/replay/utils"
 
@@ -22,6 +23,7 @@ const ANALOG_THRESHOLD = 0.1
 type GamepadInputListener struct {
 	EventLogger events.EventLoggerInterface
 	Logger      logger.LoggerInterface
+	MarkerCombo *marker.Chord // drops a MARKER event when pressed; nil disables it
 	lastStates  map[string]float64
 }
 
@@ -131,8 +133,13 @@ func (l *GamepadInputListener) logEvent(level models.EventLevel, key string, val
 		EventLevel: level.String(),
 		Content:    key,
 		Value:      value,
+		Source:     string(models.EventSourceGamepad),
 	}
 	l.EventLogger.LogEvent(event)
+	if l.MarkerCombo.Update(key, value != 0) {
+		l.EventLogger.LogEvent(marker.New("", marker.TriggerGamepad, models.EventSourceGamepad))
+		l.Logger.Info(fmt.Sprintf("Marker dropped (%s)", l.MarkerCombo))
+	}
 }
 
 var AxisNames = map[int]string{
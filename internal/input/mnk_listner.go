@@ -6,6 +6,7 @@ import (
 	"log"
 	"polytube/replay/internal/events"
 	"polytube/replay/internal/logger"
+	"polytube/replay/internal/marker"
 	"polytube/replay/pkg/models"
 	"polytube/replay/utils"
 	"unsafe"
@@ -15,8 +16,9 @@ import (
 
 // // --- InputListener ---
 type MNKInputListener struct {
-	EventLogger events.EventLoggerInterface
-	Logger      logger.LoggerInterface
+	EventLogger  events.EventLoggerInterface
+	Logger       logger.LoggerInterface
+	MarkerHotkey *marker.Chord // drops a MARKER event when pressed; nil disables it
 }
 
 func (l *MNKInputListener) Start(ctx context.Context) {
@@ -129,8 +131,13 @@ func (l *MNKInputListener) logEvent(level models.EventLevel, key string, value f
 		EventLevel: level.String(),
 		Content:    key,
 		Value:      value,
+		Source:     string(models.EventSourceMNK),
 	}
 	l.EventLogger.LogEvent(event)
+	if l.MarkerHotkey.Update(key, value != 0) {
+		l.EventLogger.LogEvent(marker.New("", marker.TriggerHotkey, models.EventSourceMNK))
+		l.Logger.Info(fmt.Sprintf("Marker dropped (%s)", l.MarkerHotkey))
+	}
 }
 
 var VKKbNames = map[uint32]string{
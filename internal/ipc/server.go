@@ -0,0 +1,362 @@
+// Package ipc accepts events from other local processes over HTTP, so games
+// started by a launcher, already-running games and test harnesses can send
+// telemetry without piping into stdin.
+//
+// The server only listens on a loopback address. Every request must carry one
+// of the configured per-source tokens:
+//
+//	POST /v1/events
+//	Authorization: Bearer <token>
+//	Content-Type: application/json
+//
+//	[{"type": "level loaded", "content": "forest_01", "value": 3.2},
+//	 {"type": "fps", "value": 58.7, "timestamp": 1760000000.25}]
+//
+// The body is a JSON array of events, an object {"events": [...]}, or a single
+// event, in the format of events.DecodeEvent. Batches are validated as a whole:
+// unknown keys, bad levels or timestamps reject the entire batch with
+// 400 Bad Request and a JSON list of the offending events, so a client never
+// has to guess which events were logged. Events get source "ipc" and the name
+// of the token's source is added to their attributes as "client".
+//
+//	POST /v1/markers  {"note": "bug here!"}  ->  200 {"accepted": 1}
+//
+// drops a MARKER event; the body (and the note) is optional.
+//
+//	POST /v1/buffer/save  {"note": "soak test stalled"}  ->  200 {"accepted": 1}
+//
+// logs a BUFFER_SAVE_REQUESTED event, which saves the replay buffer when
+// recording with --buffer; the body is optional too.
+//
+//	GET /v1/health  ->  200 {"status": "ok"} (no token required)
+package ipc
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"polytube/replay/internal/buffer"
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/logger"
+	"polytube/replay/internal/marker"
+	"polytube/replay/pkg/models"
+)
+
+const (
+	// DefaultAddr is the suggested listen address.
+	DefaultAddr = "127.0.0.1:47800"
+	// MaxBodyBytes caps the size of one request body.
+	MaxBodyBytes = 1 << 20
+	// MaxBatch caps the number of events in one request.
+	MaxBatch = 1000
+)
+
+// Server is the local HTTP event ingestion endpoint.
+type Server struct {
+	Addr        string            // loopback host:port, e.g. 127.0.0.1:47800
+	Tokens      map[string]string // token -> source name
+	EventLogger events.EventLoggerInterface
+	Logger      logger.LoggerInterface
+
+	listener net.Listener
+	srv      *http.Server
+}
+
+// Listen binds the listen address. It fails if the address is not a loopback
+// address or no token is configured, so the endpoint is never exposed.
+func (s *Server) Listen() error {
+	if s.EventLogger == nil || s.Logger == nil {
+		return errors.New("ipc: EventLogger and Logger are required")
+	}
+	if len(s.Tokens) == 0 {
+		return errors.New("ipc: at least one token is required")
+	}
+	if err := CheckLoopback(s.Addr); err != nil {
+		return err
+	}
+	ln, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return fmt.Errorf("ipc: listen on %s: %w", s.Addr, err)
+	}
+	s.listener = ln
+	return nil
+}
+
+// Serve handles requests until ctx is canceled. Listen must be called first.
+func (s *Server) Serve(ctx context.Context) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/events", s.handleEvents)
+	mux.HandleFunc("/v1/markers", s.handleMarkers)
+	mux.HandleFunc("/v1/buffer/save", s.handleBufferSave)
+	mux.HandleFunc("/v1/health", s.handleHealth)
+	s.srv = &http.Server{
+		Handler:           mux,
+		ReadHeaderTimeout: 5 * time.Second,
+		ReadTimeout:       30 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		_ = s.srv.Shutdown(shutdownCtx)
+	}()
+
+	s.Logger.Info(fmt.Sprintf("ipc: listening on http://%s", s.listener.Addr()))
+	if err := s.srv.Serve(s.listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		s.Logger.Error(fmt.Sprintf("ipc: serve: %v", err))
+	}
+}
+
+// Close releases the listen address of a server that was never served.
+func (s *Server) Close() error {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Close()
+}
+
+// CheckLoopback returns an error unless addr is host:port with a loopback host.
+func CheckLoopback(addr string) error {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return fmt.Errorf("ipc: invalid address %q: %w", addr, err)
+	}
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return fmt.Errorf("ipc: %q is not a loopback address; use 127.0.0.1 or localhost", addr)
+	}
+	return nil
+}
+
+// ParseTokens parses "source=token,source2=token2" into a token -> source map.
+func ParseTokens(s string) (map[string]string, error) {
+	tokens := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		source, token, ok := strings.Cut(pair, "=")
+		source, token = strings.TrimSpace(source), strings.TrimSpace(token)
+		if !ok || source == "" || token == "" {
+			return nil, fmt.Errorf("invalid token %q (want source=token)", pair)
+		}
+		if len(token) < 16 {
+			return nil, fmt.Errorf("token of source %q is too short (at least 16 characters)", source)
+		}
+		if _, dup := tokens[token]; dup {
+			return nil, fmt.Errorf("token of source %q is used twice", source)
+		}
+		tokens[token] = source
+	}
+	return tokens, nil
+}
+
+// rejectedEvent describes one invalid event of a batch.
+type rejectedEvent struct {
+	Index int    `json:"index"`
+	Error string `json:"error"`
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{"status": "ok"})
+}
+
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	source, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="polytube"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body larger than %d bytes", MaxBodyBytes))
+		return
+	}
+	raw, err := splitBatch(body)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(raw) > MaxBatch {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("more than %d events in one request", MaxBatch))
+		return
+	}
+
+	batch := make([]models.Event, 0, len(raw))
+	var rejected []rejectedEvent
+	for i, data := range raw {
+		event, err := events.DecodeEvent(data, true)
+		if err == nil {
+			event.Source = string(models.EventSourceIPC)
+			err = addClient(&event, source)
+		}
+		if err != nil {
+			rejected = append(rejected, rejectedEvent{Index: i, Error: err.Error()})
+			continue
+		}
+		batch = append(batch, event)
+	}
+	if len(rejected) > 0 {
+		s.Logger.Warn(fmt.Sprintf("ipc: rejected batch of %d event(s) from %s: %d invalid", len(raw), source, len(rejected)))
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid events; nothing was logged", "rejected": rejected})
+		return
+	}
+
+	for _, event := range batch {
+		s.EventLogger.LogEvent(event)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"accepted": len(batch)})
+}
+
+func (s *Server) handleMarkers(w http.ResponseWriter, r *http.Request) {
+	s.handleCommand(w, r, "marker dropped", func(note string) models.Event {
+		return marker.New(note, marker.TriggerIPC, models.EventSourceIPC)
+	})
+}
+
+func (s *Server) handleBufferSave(w http.ResponseWriter, r *http.Request) {
+	s.handleCommand(w, r, "buffer save requested", func(note string) models.Event {
+		return buffer.NewSaveRequest(note, models.EventSourceIPC)
+	})
+}
+
+// handleCommand logs the event newEvent builds from the note of an optional
+// {"note": "..."} body. done describes it for the internal log.
+func (s *Server) handleCommand(w http.ResponseWriter, r *http.Request, done string, newEvent func(note string) models.Event) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeError(w, http.StatusMethodNotAllowed, "use POST")
+		return
+	}
+	source, ok := s.authenticate(r)
+	if !ok {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="polytube"`)
+		writeError(w, http.StatusUnauthorized, "missing or invalid token")
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, MaxBodyBytes))
+	if err != nil {
+		writeError(w, http.StatusRequestEntityTooLarge, fmt.Sprintf("body larger than %d bytes", MaxBodyBytes))
+		return
+	}
+	var req struct {
+		Note string `json:"note"`
+	}
+	if strings.TrimSpace(string(body)) != "" {
+		dec := json.NewDecoder(strings.NewReader(string(body)))
+		dec.DisallowUnknownFields()
+		if err := dec.Decode(&req); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf(`body must be {"note": "..."}: %v`, err))
+			return
+		}
+	}
+
+	event := newEvent(req.Note)
+	if err := addClient(&event, source); err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	s.EventLogger.LogEvent(event)
+	s.Logger.Info(fmt.Sprintf("ipc: %s by %s", done, source))
+	writeJSON(w, http.StatusOK, map[string]any{"accepted": 1})
+}
+
+// authenticate returns the source of the request's token.
+func (s *Server) authenticate(r *http.Request) (string, bool) {
+	token := strings.TrimSpace(r.Header.Get("X-Polytube-Token"))
+	if auth := r.Header.Get("Authorization"); token == "" && len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		token = strings.TrimSpace(auth[7:])
+	}
+	if token == "" {
+		return "", false
+	}
+	// Compare against every token in constant time.
+	var source string
+	for t, src := range s.Tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			source = src
+		}
+	}
+	return source, source != ""
+}
+
+// splitBatch returns the raw events of a request body: an array, an object
+// with an "events" array, or a single event object.
+func splitBatch(body []byte) ([]json.RawMessage, error) {
+	var batch []json.RawMessage
+	trimmed := strings.TrimSpace(string(body))
+	switch {
+	case strings.HasPrefix(trimmed, "["):
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("invalid JSON array: %v", err)
+		}
+	case strings.HasPrefix(trimmed, "{"):
+		var wrapper map[string]json.RawMessage
+		if err := json.Unmarshal(body, &wrapper); err != nil {
+			return nil, fmt.Errorf("invalid JSON object: %v", err)
+		}
+		list, ok := wrapper["events"]
+		if !ok || len(wrapper) != 1 {
+			return []json.RawMessage{body}, nil
+		}
+		if err := json.Unmarshal(list, &batch); err != nil {
+			return nil, fmt.Errorf(`"events" must be an array: %v`, err)
+		}
+	default:
+		return nil, errors.New("body must be a JSON array or object")
+	}
+	if len(batch) == 0 {
+		return nil, errors.New("no events")
+	}
+	return batch, nil
+}
+
+// addClient records the token's source name in the event attributes.
+func addClient(event *models.Event, client string) error {
+	attrs := make(map[string]any)
+	if event.Attributes != "" {
+		if err := json.Unmarshal([]byte(event.Attributes), &attrs); err != nil {
+			return err
+		}
+	}
+	if _, ok := attrs["client"]; ok {
+		return errors.New(`"client" is set by the server and cannot be a field`)
+	}
+	attrs["client"] = client
+	b, err := json.Marshal(attrs)
+	if err != nil {
+		return err
+	}
+	event.Attributes = string(b)
+	return nil
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]any{"error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,140 @@
+// Package launcher starts the game for launch-and-record mode (--exec). The
+// game's stdout and stderr are read like piped stdin (see console.ParseLine),
+// so its log lines become console events without a shell pipe; plain text on
+// stderr is logged as a WARNING, or an ERROR when its prefix says so.
+package launcher
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"polytube/replay/internal/console"
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/logger"
+)
+
+// outputDelay is how long Wait keeps reading the game's output after it
+// exited, in case a process it started still holds the pipes open.
+const outputDelay = 2 * time.Second
+
+// Resolve returns the absolute path of the executable path, looked up like a
+// shell does: relative to the working directory if path names a folder,
+// otherwise in the working directory and then PATH.
+func Resolve(path string) (string, error) {
+	p, err := exec.LookPath(path)
+	if err != nil && !errors.Is(err, exec.ErrDot) { // the user named it; the working directory is fine
+		return "", err
+	}
+	return filepath.Abs(p)
+}
+
+// Game is a game process started by the recorder.
+type Game struct {
+	Path        string   // executable, resolved with Resolve
+	Args        []string // command-line arguments
+	Dir         string   // working directory; the executable's directory if empty
+	EventLogger events.EventLoggerInterface
+	Logger      logger.LoggerInterface
+
+	cmd      *exec.Cmd
+	done     chan struct{}
+	exitCode int
+	output   sync.WaitGroup
+}
+
+// Start starts the game and logs its output until it exits.
+func (g *Game) Start(ctx context.Context) error {
+	if g.Path == "" || g.EventLogger == nil || g.Logger == nil {
+		return errors.New("launcher: Path, EventLogger and Logger are required")
+	}
+	path, err := Resolve(g.Path)
+	if err != nil {
+		return fmt.Errorf("launcher: %w", err)
+	}
+	cmd := exec.Command(path, g.Args...)
+	cmd.Dir = g.Dir
+	if cmd.Dir == "" {
+		cmd.Dir = filepath.Dir(path)
+	}
+	stdoutR, stdoutW := io.Pipe()
+	stderrR, stderrW := io.Pipe()
+	cmd.Stdout, cmd.Stderr = stdoutW, stderrW
+	cmd.WaitDelay = outputDelay
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("launcher: start %s: %w", path, err)
+	}
+	g.cmd = cmd
+	g.done = make(chan struct{})
+	g.exitCode = -1
+	g.Logger.Info(fmt.Sprintf("Game started: %s (pid %d)", path, cmd.Process.Pid))
+
+	// Lines are logged until ctx is canceled. The pipes are read until the
+	// game closes them either way, so it never blocks writing its output.
+	name := filepath.Base(path)
+	for _, l := range []*console.ConsoleListener{
+		{EventLogger: g.EventLogger, Logger: g.Logger, Input: stdoutR, Name: name + " stdout"},
+		{EventLogger: g.EventLogger, Logger: g.Logger, Input: stderrR, Name: name + " stderr", Stderr: true},
+	} {
+		g.output.Add(1)
+		go func() {
+			defer g.output.Done()
+			l.Start(ctx)
+			io.Copy(io.Discard, l.Input)
+		}()
+	}
+
+	go func() {
+		defer close(g.done)
+		err := cmd.Wait()
+		stdoutW.Close()
+		stderrW.Close()
+		g.output.Wait()
+		g.exitCode = cmd.ProcessState.ExitCode()
+		switch {
+		case err == nil:
+			g.Logger.Info(fmt.Sprintf("Game exited with code %d", g.exitCode))
+		case g.exitCode >= 0:
+			g.Logger.Warn(fmt.Sprintf("Game exited with code %d (%v)", g.exitCode, err))
+		default:
+			g.Logger.Warn(fmt.Sprintf("Game wait failed: %v", err))
+		}
+	}()
+	return nil
+}
+
+// Kill ends the game if it is still running. Processes it started itself are
+// left alone.
+func (g *Game) Kill() error {
+	if err := g.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+		return fmt.Errorf("launcher: kill %s: %w", filepath.Base(g.cmd.Path), err)
+	}
+	return nil
+}
+
+// PID returns the process ID of the game.
+func (g *Game) PID() int {
+	return g.cmd.Process.Pid
+}
+
+// Done is closed when the game has exited and its output was logged.
+func (g *Game) Done() <-chan struct{} {
+	return g.done
+}
+
+// ExitCode returns the exit code of the game once Done is closed, or -1 if it
+// is unknown.
+func (g *Game) ExitCode() int {
+	select {
+	case <-g.done:
+		return g.exitCode
+	default:
+		return -1
+	}
+}
@@ -0,0 +1,348 @@
+//go:build windows
+
+// Package manifest maintains session.json, the index of one recorded session
+// directory. It ties together the files the recorder writes (playlist.m3u8,
+// output_###.ts, events_###.parquet, internal.log) with the session ID, the
+// SessionInfo, the redacted CLI configuration, the recording start and end
+// times, the HLS segment list with durations and SHA-256 hashes, the event
+// schema version and the upload status of every file.
+//
+// The manifest is rewritten atomically on every Save, so downstream tooling can
+// read it at any time and always sees a complete document.
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/uploader"
+	"polytube/replay/pkg/models"
+)
+
+const (
+	// FileName is the name of the manifest kept in every session directory.
+	FileName = "session.json"
+	// Version is the version of the manifest format.
+	Version = 1
+)
+
+// processStart anchors the monotonic timestamps of this process.
+var processStart = time.Now()
+
+// Timestamp is a point in time as both wall-clock time and milliseconds since
+// the process started, read from the monotonic clock. The monotonic value is
+// unaffected by wall-clock adjustments, so differences between two of them are
+// exact durations.
+type Timestamp struct {
+	Wall        time.Time `json:"wall"`
+	MonotonicMs int64     `json:"monotonic_ms"`
+}
+
+// Now returns the current Timestamp.
+func Now() Timestamp {
+	now := time.Now()
+	return Timestamp{Wall: now.Round(0), MonotonicMs: now.Sub(processStart).Milliseconds()}
+}
+
+// Add returns t shifted by d.
+func (t Timestamp) Add(d time.Duration) Timestamp {
+	return Timestamp{Wall: t.Wall.Add(d), MonotonicMs: t.MonotonicMs + d.Milliseconds()}
+}
+
+// Recording describes when the recording ran.
+type Recording struct {
+	Start      *Timestamp `json:"start,omitempty"`
+	End        *Timestamp `json:"end,omitempty"`
+	DurationMs int64      `json:"duration_ms,omitempty"` // End - Start from the monotonic clock
+}
+
+// Segment is one HLS segment listed in the playlist.
+type Segment struct {
+	File     string  `json:"file"`
+	Sequence int     `json:"sequence"`
+	StartSec float64 `json:"start_seconds"` // offset from the start of the recording
+	Duration float64 `json:"duration_seconds"`
+	Size     int64   `json:"size"`
+	SHA256   string  `json:"sha256,omitempty"`
+
+	modTime time.Time // of the file when SHA256 was computed; zero after Load
+}
+
+// Events describes the event log of the session: a single File (sessions
+// recorded before event files were rolled) or the rolled Files in time order.
+type Events struct {
+	File          string   `json:"file,omitempty"`
+	Files         []string `json:"files,omitempty"` // finished events_###.parquet files
+	SchemaVersion int      `json:"schema_version"`
+	CustomTypes   []string `json:"custom_types,omitempty"` // registered with --event-types
+}
+
+// FileUpload is the upload state of one file, copied from the upload journal.
+type FileUpload struct {
+	Status    uploader.FileStatus `json:"status"`
+	Size      int64               `json:"size"`
+	Attempts  int                 `json:"attempts,omitempty"`
+	Error     string              `json:"error,omitempty"`
+	UpdatedAt time.Time           `json:"updated_at"`
+}
+
+// Manifest is the content of session.json.
+type Manifest struct {
+	Version     int                   `json:"manifest_version"`
+	SessionID   string                `json:"session_id"`
+	SessionInfo info.SessionInfo      `json:"session_info"`
+	Config      any                   `json:"config,omitempty"` // CLI configuration with secrets redacted
+	Recording   Recording             `json:"recording"`
+	Game        *uploader.GameExit    `json:"game,omitempty"` // how the recorded game ended, if known
+	Playlist    string                `json:"playlist"`
+	Segments    []Segment             `json:"segments"`
+	Events      Events                `json:"events"`
+	Uploads     map[string]FileUpload `json:"uploads"` // keyed by file name
+	UpdatedAt   time.Time             `json:"updated_at"`
+
+	path string
+	mu   sync.Mutex
+}
+
+// New creates the manifest of the session recorded in dir and writes it.
+// config is stored as is, so secrets must already be redacted.
+func New(dir, sessionID string, sessionInfo info.SessionInfo, config any) (*Manifest, error) {
+	m := &Manifest{
+		Version:     Version,
+		SessionID:   sessionID,
+		SessionInfo: sessionInfo,
+		Config:      config,
+		Playlist:    "playlist.m3u8",
+		Segments:    []Segment{},
+		Events: Events{
+			SchemaVersion: models.EventSchemaVersion,
+			CustomTypes:   models.CustomEventTypes(),
+		},
+		Uploads: make(map[string]FileUpload),
+		path:    filepath.Join(dir, FileName),
+	}
+	if err := m.Save(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Load reads the manifest of a session directory.
+func Load(dir string) (*Manifest, error) {
+	path := filepath.Join(dir, FileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read manifest: %w", err)
+	}
+	m := &Manifest{path: path}
+	if err := json.Unmarshal(data, m); err != nil {
+		return nil, fmt.Errorf("parse manifest %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// MarkStarted records the recording start time.
+func (m *Manifest) MarkStarted() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := Now()
+	m.Recording.Start = &ts
+}
+
+// MarkEnded records the recording end time and duration.
+func (m *Manifest) MarkEnded() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	ts := Now()
+	m.Recording.End = &ts
+	if m.Recording.Start != nil {
+		m.Recording.DurationMs = ts.MonotonicMs - m.Recording.Start.MonotonicMs
+	}
+}
+
+// Started returns the recording start time, or nil before MarkStarted.
+func (m *Manifest) Started() *Timestamp {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.Recording.Start
+}
+
+// MarkRecorded records that the recording ran for d from start, for sessions
+// cut out of a longer recording such as a saved replay buffer.
+func (m *Manifest) MarkRecorded(start Timestamp, d time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	end := start.Add(d)
+	m.Recording = Recording{Start: &start, End: &end, DurationMs: d.Milliseconds()}
+}
+
+// SetGameExit records how the recorded game ended.
+func (m *Manifest) SetGameExit(e uploader.GameExit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Game = &e
+}
+
+// Refresh re-reads the playlist to update the segment list, hashing segments
+// not seen before (reusing checksums already in the journal), lists the
+// finished event files and copies the per-file upload state from journal.
+// journal may be nil.
+func (m *Manifest) Refresh(journal *uploader.Journal) error {
+	dir := filepath.Dir(m.path)
+	m.mu.Lock()
+	playlist := m.Playlist
+	known := make(map[string]Segment, len(m.Segments))
+	for _, s := range m.Segments {
+		known[s.File] = s
+	}
+	m.mu.Unlock()
+
+	// Hash outside the lock; only the poller and shutdown call Refresh.
+	segments, err := readPlaylist(filepath.Join(dir, playlist))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	for i := range segments {
+		s := &segments[i]
+		fi, err := os.Stat(filepath.Join(dir, s.File))
+		if err != nil {
+			continue
+		}
+		s.Size, s.modTime = fi.Size(), fi.ModTime()
+		if prev, ok := known[s.File]; ok && prev.SHA256 != "" && prev.Size == s.Size && (prev.modTime.IsZero() || prev.modTime.Equal(s.modTime)) {
+			s.SHA256 = prev.SHA256
+			continue
+		}
+		if journal != nil {
+			if sum, ok := journal.Checksum(s.File, s.Size, s.modTime); ok && sum.SHA256 != "" {
+				s.SHA256 = sum.SHA256
+				continue
+			}
+		}
+		if s.SHA256, err = hashFile(filepath.Join(dir, s.File)); err != nil {
+			return err
+		}
+	}
+
+	eventFiles, err := events.RolledFiles(filepath.Join(dir, events.ParquetFile))
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if segments != nil {
+		m.Segments = segments
+	}
+	if len(eventFiles) > 0 {
+		m.Events.Files = m.Events.Files[:0]
+		for _, path := range eventFiles {
+			m.Events.Files = append(m.Events.Files, filepath.Base(path))
+		}
+	}
+	if journal != nil {
+		if m.Uploads == nil {
+			m.Uploads = make(map[string]FileUpload)
+		}
+		for name, e := range journal.Entries() {
+			m.Uploads[name] = FileUpload{
+				Status:    e.Status,
+				Size:      e.Size,
+				Attempts:  e.Attempts,
+				Error:     e.Error,
+				UpdatedAt: e.UpdatedAt,
+			}
+		}
+	}
+	return nil
+}
+
+// Save atomically persists the manifest (write to temp file, then rename).
+func (m *Manifest) Save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.UpdatedAt = time.Now().Round(0)
+	data, err := json.MarshalIndent(m, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal manifest: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write manifest: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("replace manifest: %w", err)
+	}
+	return nil
+}
+
+// readPlaylist parses the segments of an HLS media playlist.
+func readPlaylist(path string) ([]Segment, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var (
+		segments []Segment
+		sequence int
+		start    float64
+		duration float64
+	)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"):
+			sequence, _ = strconv.Atoi(strings.TrimPrefix(line, "#EXT-X-MEDIA-SEQUENCE:"))
+		case strings.HasPrefix(line, "#EXTINF:"):
+			value, _, _ := strings.Cut(strings.TrimPrefix(line, "#EXTINF:"), ",")
+			duration, _ = strconv.ParseFloat(value, 64)
+		case strings.HasPrefix(line, "#"):
+		default:
+			segments = append(segments, Segment{
+				File:     filepath.Base(line),
+				Sequence: sequence,
+				StartSec: start,
+				Duration: duration,
+			})
+			sequence++
+			start += duration
+			duration = 0
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("read playlist: %w", err)
+	}
+	if segments == nil {
+		segments = []Segment{}
+	}
+	return segments, nil
+}
+
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", fmt.Errorf("hash %s: %w", path, err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,172 @@
+// Package marker implements session markers: bookmarks ("bug here!") that
+// testers drop while playing. A marker is a MARKER event whose content is an
+// optional note. It can be triggered by a hotkey (see ParseHotkey), a gamepad
+// button combo (see ParseCombo), the stdin command "/marker <note>" or the IPC
+// endpoint, and the clip command cuts the video around every marker.
+package marker
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// Triggers recorded in the "trigger" attribute of a marker.
+const (
+	TriggerHotkey  = "hotkey"
+	TriggerGamepad = "gamepad"
+	TriggerCommand = "command" // "/marker" on stdin
+	TriggerIPC     = "ipc"
+)
+
+// New returns a MARKER event with an optional note.
+func New(note, trigger string, source models.EventSource) models.Event {
+	attrs, _ := json.Marshal(map[string]string{"trigger": trigger})
+	return models.Event{
+		Timestamp:  utils.NowEpochSeconds(),
+		EventType:  models.EventTypeMarker.String(),
+		EventLevel: models.EventLevelLog.String(),
+		Content:    strings.TrimSpace(note),
+		Value:      0,
+		Attributes: string(attrs),
+		Source:     string(source),
+	}
+}
+
+// Chord is a combination of keys or buttons that fires once when all of them
+// are held down, and again only after one of them was released. Each element
+// of keys lists the input names that count as that key (e.g. left or right
+// Ctrl). A Chord is not safe for concurrent use; each listener owns its own.
+type Chord struct {
+	name string
+	keys [][]string
+	down map[string]bool
+	held bool // all keys were down at the last update
+}
+
+// Update records that key went down or up and reports whether that completed
+// the chord.
+func (c *Chord) Update(key string, pressed bool) bool {
+	if c == nil || !c.has(key) {
+		return false
+	}
+	if c.down == nil {
+		c.down = make(map[string]bool)
+	}
+	c.down[key] = pressed
+	all := true
+	for _, alternatives := range c.keys {
+		any := false
+		for _, name := range alternatives {
+			any = any || c.down[name]
+		}
+		all = all && any
+	}
+	fired := all && !c.held
+	c.held = all
+	return fired
+}
+
+// has reports whether key is part of the chord.
+func (c *Chord) has(key string) bool {
+	for _, alternatives := range c.keys {
+		for _, name := range alternatives {
+			if name == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// String returns the chord as it was given, e.g. "Ctrl+Shift+M".
+func (c *Chord) String() string {
+	if c == nil {
+		return "none"
+	}
+	return c.name
+}
+
+// keyAliases maps hotkey names to the names the keyboard listener logs. Keys
+// without a name in the listener are logged as their virtual-key code, such
+// as the left and right variants of the modifiers from the low-level hook.
+var keyAliases = map[string][]string{
+	"CTRL":        {"VK_CONTROL", "0xA2", "0xA3"},
+	"CONTROL":     {"VK_CONTROL", "0xA2", "0xA3"},
+	"SHIFT":       {"VK_SHIFT", "0xA0", "0xA1"},
+	"ALT":         {"VK_MENU", "0xA4", "0xA5"},
+	"ENTER":       {"VK_RETURN"},
+	"RETURN":      {"VK_RETURN"},
+	"ESC":         {"VK_ESCAPE"},
+	"ESCAPE":      {"VK_ESCAPE"},
+	"BACKSPACE":   {"VK_BACK"},
+	"DEL":         {"VK_DELETE"},
+	"PAGEUP":      {"VK_PRIOR"},
+	"PAGEDOWN":    {"VK_NEXT"},
+	"PRINTSCREEN": {"VK_SNAPSHOT"},
+}
+
+// namedKeys are the other keys a hotkey may use besides letters, digits and
+// F1-F12, as the keyboard listener names them without the VK_ prefix.
+var namedKeys = []string{"SPACE", "TAB", "BACK", "INSERT", "DELETE", "HOME", "END", "PRIOR", "NEXT", "PAUSE", "SNAPSHOT", "UP", "DOWN", "LEFT", "RIGHT", "CAPITAL"}
+
+// ParseHotkey parses a keyboard hotkey such as "F9" or "Ctrl+Shift+M". Names
+// are case-insensitive: letters, digits, F1-F12, Ctrl, Shift, Alt, Space,
+// Enter, Tab, Esc, arrows (Up, Down, Left, Right) and the navigation keys.
+// "none" or an empty string returns nil, which never fires.
+func ParseHotkey(s string) (*Chord, error) {
+	return parseChord(s, func(name string) ([]string, bool) {
+		upper := strings.ToUpper(name)
+		if names, ok := keyAliases[upper]; ok {
+			return names, true
+		}
+		if len(upper) == 1 && (upper[0] >= 'A' && upper[0] <= 'Z' || upper[0] >= '0' && upper[0] <= '9') {
+			return []string{"VK_" + upper}, true
+		}
+		var n int
+		if _, err := fmt.Sscanf(upper, "F%d", &n); err == nil && upper == fmt.Sprintf("F%d", n) && n >= 1 && n <= 12 {
+			return []string{"VK_" + upper}, true
+		}
+		for _, k := range namedKeys {
+			if upper == k {
+				return []string{"VK_" + k}, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// ParseCombo parses a gamepad button combo such as "Back+Start", where
+// buttons are the names the gamepad listener logs (matched case-insensitively).
+// "none" or an empty string returns nil, which never fires.
+func ParseCombo(s string, buttons []string) (*Chord, error) {
+	return parseChord(s, func(name string) ([]string, bool) {
+		for _, b := range buttons {
+			if strings.EqualFold(b, name) {
+				return []string{b}, true
+			}
+		}
+		return nil, false
+	})
+}
+
+// parseChord splits "A+B+C" and resolves every part with resolve.
+func parseChord(s string, resolve func(string) ([]string, bool)) (*Chord, error) {
+	s = strings.TrimSpace(s)
+	if s == "" || strings.EqualFold(s, "none") {
+		return nil, nil
+	}
+	c := &Chord{name: s}
+	for _, part := range strings.Split(s, "+") {
+		part = strings.TrimSpace(part)
+		names, ok := resolve(part)
+		if !ok {
+			return nil, fmt.Errorf("unknown key %q in %q", part, s)
+		}
+		c.keys = append(c.keys, names)
+	}
+	return c, nil
+}
@@ -0,0 +1,56 @@
+//go:build windows
+
+package recorder
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// CheckFFmpeg runs ffmpeg.exe at ffmpegPath and returns its version line. It
+// fails if the binary does not run or lacks the gfxcapture filter the
+// recorder depends on.
+func CheckFFmpeg(ffmpegPath string) (string, error) {
+	out, err := runFFmpeg(ffmpegPath, "-hide_banner", "-version")
+	if err != nil {
+		return "", fmt.Errorf("run %s: %w", ffmpegPath, err)
+	}
+	version, _, _ := strings.Cut(strings.TrimSpace(out), "\n")
+
+	filters, err := runFFmpeg(ffmpegPath, "-hide_banner", "-filters")
+	if err != nil {
+		return version, fmt.Errorf("list filters: %w", err)
+	}
+	if !strings.Contains(filters, "gfxcapture") {
+		return version, fmt.Errorf("%s has no gfxcapture filter; window capture will not work", ffmpegPath)
+	}
+	return strings.TrimSpace(version), nil
+}
+
+// runFFmpeg runs ffmpeg with a hidden console window and a short timeout and
+// returns its combined output.
+func runFFmpeg(ffmpegPath string, args ...string) (string, error) {
+	cmd := exec.Command(ffmpegPath, args...)
+	cmd.SysProcAttr = &windows.SysProcAttr{HideWindow: true}
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	cmd.Stderr = &out
+	if err := cmd.Start(); err != nil {
+		return "", err
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return out.String(), err
+	case <-time.After(15 * time.Second):
+		_ = cmd.Process.Kill()
+		<-done
+		return out.String(), fmt.Errorf("timed out")
+	}
+}
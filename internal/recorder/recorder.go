@@ -1,27 +1,41 @@
 //go:build windows
 
 // Package recorder starts and supervises an FFmpeg process that records a
-// specific game window (by exact title) using the DXGI capture device on Windows.
+// specific game window (by handle, or by exact title) using the Windows
+// Graphics Capture filter (gfxcapture).
 // Output is written as HLS: a playlist.m3u8 manifest and segment files output_###.ts.
 //
 // Typical command (example):
 //
-//	ffmpeg -f dxgi -framerate 30 -i title="Window Title" \
-//	  -vf scale=1280:720 -b:v 800k -b:a 128k -c:v libx264 -preset veryfast -crf 30 \
-//	  -g 60 -pix_fmt yuv420p -f hls -hls_time 5 -hls_list_size 0 \
+//	ffmpeg -filter_complex "gfxcapture=hwnd=1234:max_framerate=30,hwdownload,format=bgra,scale=1280:720,format=yuv420p" \
+//	  -an -c:v libx264 -preset veryfast -crf 30 -b:v 700k -g 60 -force_key_frames "expr:gte(t,n_forced*200)" \
+//	  -f hls -hls_time 200 -hls_list_size 0 \
 //	  -hls_segment_filename "C:\out\output_%03d.ts" "C:\out\playlist.m3u8"
+//
+// A title is passed to gfxcapture as a regular expression; it is quoted for the
+// regex and escaped for the filter graph, so quotes, colons and brackets in it
+// are matched literally.
+//
+// In replay buffer mode (BufferSegments > 0) segments are short and FFmpeg
+// lists only the last BufferSegments of them, deleting older ones
+// (-hls_list_size N -hls_flags delete_segments), so the directory holds a
+// rolling window of the last few minutes.
 package recorder
 
 import (
 	"bufio"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/registry"
@@ -32,14 +46,26 @@ import (
 	"polytube/replay/utils"
 )
 
+// SegmentDuration is the target length of an HLS segment. A keyframe is forced
+// on every segment boundary, so segments are this long up to one frame.
+const SegmentDuration = 200 * time.Second
+
+// BufferSegmentDuration is the segment length in replay buffer mode. Short
+// segments keep the buffer close to the requested length.
+const BufferSegmentDuration = 10 * time.Second
+
 // Recorder holds configuration for launching FFmpeg and waiting for it.
 type Recorder struct {
-	Title          string                 // exact window title to capture
+	Title          string                 // exact window title to capture, case-insensitive; used if Handle is zero
+	Handle         uintptr                // window to capture (HWND), e.g. found by window.Selector
 	DirPath        string                 // directory to place HLS files
 	FFmpegPath     string                 // path to ffmpeg.exe
 	Logger         logger.LoggerInterface // internal logger for diagnostic output
 	EventLogger    events.EventLoggerInterface
+	Segment        time.Duration // HLS segment length; zero uses SegmentDuration
+	BufferSegments int           // keep only the last N segments on disk; zero keeps all
 	cmd            *exec.Cmd
+	stdin          io.WriteCloser // FFmpeg's interactive commands; see Stop
 	stdioWG        sync.WaitGroup
 	manifestPath   string
 	segmentPattern string
@@ -49,7 +75,7 @@ type Recorder struct {
 	waitErr        error
 }
 
-// Start spawns ffmpeg.exe screen capture bound to the target window title.
+// Start spawns ffmpeg.exe screen capture bound to the target window.
 // It wires stdout/stderr to the internal logger. If FFmpeg cannot be started, returns error.
 //
 // Notes:
@@ -62,8 +88,8 @@ func (r *Recorder) Start() error {
 			r.startErr = errors.New("recorder: Logger is required")
 			return
 		}
-		if strings.TrimSpace(r.Title) == "" {
-			r.startErr = errors.New("recorder: Title is required")
+		if r.Handle == 0 && strings.TrimSpace(r.Title) == "" {
+			r.startErr = errors.New("recorder: Handle or Title is required")
 			return
 		}
 		if strings.TrimSpace(r.DirPath) == "" {
@@ -88,17 +114,26 @@ func (r *Recorder) Start() error {
 		r.manifestPath = filepath.Join(r.DirPath, "playlist.m3u8")
 		r.segmentPattern = filepath.Join(r.DirPath, "output_%03d.ts")
 
+		segment := r.Segment
+		if segment <= 0 {
+			segment = SegmentDuration
+		}
+
+		// Capture a specific window: by handle, or by title (case-insensitive
+		// exact match).
+		window := fmt.Sprintf("hwnd=%d", r.Handle)
+		if r.Handle == 0 {
+			window = "window_title=" + utils.EscapeFilterValue("(?i)^"+regexp.QuoteMeta(r.Title)+"$")
+		}
+
 		// Build FFmpeg arguments.
 		// Using conservative encoding defaults; tune as needed.
 		args := []string{
 			"-loglevel", "warning",
 			"-y",
 
-			// Capture video from a specific window (case-insensitive exact match)
-			"-filter_complex", fmt.Sprintf(
-				"gfxcapture=window_title='(?i)^%s$':max_framerate=30,hwdownload,format=bgra,scale=1280:720,format=yuv420p",
-				r.Title,
-			),
+			// Capture video from the window
+			"-filter_complex", "gfxcapture=" + window + ":max_framerate=30,hwdownload,format=bgra,scale=1280:720,format=yuv420p",
 
 			// Disable audio completely
 			"-an",
@@ -109,15 +144,21 @@ func (r *Recorder) Start() error {
 			"-crf", "30",
 			"-b:v", "700k",
 			"-g", "60",
+			// Keyframes exactly on the segment boundaries: gfxcapture's frame rate
+			// varies, so -g alone would let the cuts drift from N*segment, where
+			// the event files roll (see events.NewParquetSink).
+			"-force_key_frames", fmt.Sprintf("expr:gte(t,n_forced*%d)", int(segment.Seconds())),
 
 			// Output format (HLS)
 			"-f", "hls",
-			"-hls_time", "200",
-			"-hls_list_size", "0",
-			"-hls_segment_filename", r.segmentPattern,
-
-			r.manifestPath,
+			"-hls_time", strconv.Itoa(int(segment.Seconds())),
+			"-hls_list_size", strconv.Itoa(r.BufferSegments), // 0 lists every segment
 		}
+		if r.BufferSegments > 0 {
+			// Replay buffer: delete the segments that dropped out of the playlist.
+			args = append(args, "-hls_flags", "delete_segments")
+		}
+		args = append(args, "-hls_segment_filename", r.segmentPattern, r.manifestPath)
 		r.Logger.Info(fmt.Sprintf("FFmpeg path: %s", ffmpeg))
 		r.Logger.Info(fmt.Sprintf("FFmpeg args: %s", strings.Join(args, " ")))
 
@@ -136,6 +177,11 @@ func (r *Recorder) Start() error {
 			r.startErr = fmt.Errorf("recorder: stderr pipe: %w", err)
 			return
 		}
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			r.startErr = fmt.Errorf("recorder: stdin pipe: %w", err)
+			return
+		}
 
 		// Start process.
 		if err := cmd.Start(); err != nil {
@@ -143,6 +189,7 @@ func (r *Recorder) Start() error {
 			return
 		}
 		r.cmd = cmd
+		r.stdin = stdin
 
 		// Stream stdout/stderr to internal logger.
 		r.stdioWG.Add(2)
@@ -190,6 +237,18 @@ func (r *Recorder) Wait() error {
 	return r.waitErr
 }
 
+// Stop asks FFmpeg to end the recording as if the window had closed: it
+// finishes the last segment and exits, and Wait returns.
+func (r *Recorder) Stop() error {
+	if r.stdin == nil {
+		return errors.New("recorder: Stop called before Start")
+	}
+	if _, err := io.WriteString(r.stdin, "q"); err != nil {
+		return fmt.Errorf("recorder: stop ffmpeg: %w", err)
+	}
+	return nil
+}
+
 func (r *Recorder) LogRecordingStartedEvent() error {
 	event := models.Event{
 		Timestamp:  utils.NowEpochSeconds(),
@@ -197,6 +256,7 @@ func (r *Recorder) LogRecordingStartedEvent() error {
 		EventLevel: "",
 		Content:    "",
 		Value:      0,
+		Source:     string(models.EventSourceSystem),
 	}
 	r.EventLogger.LogEvent(event)
 	return nil
@@ -0,0 +1,160 @@
+package uploader
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Checksum holds the digests of a file or part as lowercase hex strings.
+// SHA256 is always computed; MD5 only when Content-MD5 is enabled.
+type Checksum struct {
+	SHA256 string `json:"sha256"`
+	MD5    string `json:"md5,omitempty"`
+}
+
+// IsZero reports whether no digest was computed.
+func (c Checksum) IsZero() bool {
+	return c.SHA256 == "" && c.MD5 == ""
+}
+
+// SHA256Base64 returns the SHA-256 digest base64 encoded, as used by the
+// x-amz-checksum-sha256 and Content-Digest headers.
+func (c Checksum) SHA256Base64() string {
+	return hexToBase64(c.SHA256)
+}
+
+// MD5Base64 returns the MD5 digest base64 encoded, as used by Content-MD5.
+func (c Checksum) MD5Base64() string {
+	return hexToBase64(c.MD5)
+}
+
+func hexToBase64(h string) string {
+	if h == "" {
+		return ""
+	}
+	b, err := hex.DecodeString(h)
+	if err != nil {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+// computeChecksum reads r to the end and returns its digests.
+func computeChecksum(r io.Reader, withMD5 bool) (Checksum, error) {
+	sha := sha256.New()
+	writers := []io.Writer{sha}
+	var md hash.Hash
+	if withMD5 {
+		md = md5.New()
+		writers = append(writers, md)
+	}
+	if _, err := io.Copy(io.MultiWriter(writers...), r); err != nil {
+		return Checksum{}, err
+	}
+	sum := Checksum{SHA256: hex.EncodeToString(sha.Sum(nil))}
+	if md != nil {
+		sum.MD5 = hex.EncodeToString(md.Sum(nil))
+	}
+	return sum, nil
+}
+
+// fileChecksum returns the digests of length bytes of path starting at offset.
+func fileChecksum(path string, offset, length int64, withMD5 bool) (Checksum, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("open file: %w", err)
+	}
+	defer file.Close()
+	sum, err := computeChecksum(io.NewSectionReader(file, offset, length), withMD5)
+	if err != nil {
+		return Checksum{}, fmt.Errorf("read %s: %w", path, err)
+	}
+	return sum, nil
+}
+
+// checksumError reports content that arrived corrupted. It is retryable: the
+// file is sent again.
+func checksumError(format string, args ...any) *UploadError {
+	return &UploadError{Kind: ErrorKindChecksum, Err: fmt.Errorf(format, args...)}
+}
+
+// verifyResponse compares the digests a server reports for an upload with the
+// expected checksum. Servers report them in different ways:
+//
+//	x-amz-checksum-sha256       S3 when the checksum was sent
+//	Content-Digest/Repr-Digest  RFC 9530 (sha-256=:<base64>:)
+//	ETag                        S3 single PUT and UploadPart: hex MD5 of the body
+//
+// Headers that are absent, or an ETag that is not a plain MD5 (multipart,
+// SSE-KMS), are not checked.
+func verifyResponse(h http.Header, sum Checksum) error {
+	if h == nil || sum.IsZero() {
+		return nil
+	}
+	if sum.SHA256 != "" {
+		if got := h.Get("x-amz-checksum-sha256"); got != "" && got != sum.SHA256Base64() {
+			return checksumError("server reported sha256 %s, expected %s", got, sum.SHA256Base64())
+		}
+		for _, name := range []string{"Content-Digest", "Repr-Digest"} {
+			if got, ok := digestSHA256(h.Get(name)); ok && got != sum.SHA256Base64() {
+				return checksumError("server reported %s sha-256 %s, expected %s", name, got, sum.SHA256Base64())
+			}
+		}
+	}
+	if sum.MD5 != "" {
+		etag := strings.Trim(strings.TrimPrefix(h.Get("ETag"), "W/"), `"`)
+		if isHexMD5(etag) && !strings.EqualFold(etag, sum.MD5) {
+			return checksumError("server reported ETag %s, expected md5 %s", etag, sum.MD5)
+		}
+	}
+	return nil
+}
+
+// digestSHA256 extracts the sha-256 value from an RFC 9530 digest header.
+func digestSHA256(v string) (string, bool) {
+	for _, item := range strings.Split(v, ",") {
+		name, value, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if ok && strings.EqualFold(name, "sha-256") {
+			return strings.Trim(value, ":"), true
+		}
+	}
+	return "", false
+}
+
+func isHexMD5(s string) bool {
+	if len(s) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
+
+// checksum returns the digests of the file, reusing the ones recorded in the
+// journal when the file is unchanged.
+func (u *Uploader) checksum(path string, fi os.FileInfo) (Checksum, error) {
+	name := filepath.Base(path)
+	if u.Journal != nil {
+		if sum, ok := u.Journal.Checksum(name, fi.Size(), fi.ModTime()); ok && (sum.MD5 != "" || !u.ContentMD5) {
+			return sum, nil
+		}
+	}
+	sum, err := fileChecksum(path, 0, fi.Size(), u.ContentMD5)
+	if err != nil {
+		return Checksum{}, err
+	}
+	if u.Journal != nil {
+		if err := u.Journal.SetChecksum(name, fi.Size(), fi.ModTime(), sum); err != nil {
+			u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal for %s: %v", name, err))
+		}
+	}
+	return sum, nil
+}
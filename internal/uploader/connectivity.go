@@ -0,0 +1,89 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// offlineProbeInterval is how often an offline Uploader checks whether the
+// storage is reachable again.
+const offlineProbeInterval = 30 * time.Second
+
+// Pinger is implemented by storages that can cheaply check whether they are
+// reachable. Any answer from the server counts as reachable; only transport
+// failures (no network, DNS, unmounted share) count as offline.
+type Pinger interface {
+	Ping() error
+}
+
+// CheckConnectivity reports whether storage is reachable. Storages without a
+// Pinger are assumed reachable.
+func CheckConnectivity(storage Storage) error {
+	p, ok := storage.(Pinger)
+	if !ok {
+		return nil
+	}
+	return p.Ping()
+}
+
+// pingHTTP sends a HEAD request to rawURL; any HTTP response means reachable.
+func pingHTTP(client *http.Client, rawURL string) error {
+	req, err := http.NewRequest(http.MethodHead, rawURL, nil)
+	if err != nil {
+		return fmt.Errorf("create HEAD request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return networkError(fmt.Errorf("HEAD %s failed: %w", rawURL, err))
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Online reports whether uploads should be attempted. After a connectivity
+// failure the Uploader stays offline and probes the storage at most every
+// offlineProbeInterval; files stay spooled on disk in the meantime.
+func (u *Uploader) Online() bool {
+	u.Mu.Lock()
+	if !u.offline || time.Since(u.lastProbe) < offlineProbeInterval {
+		online := !u.offline
+		u.Mu.Unlock()
+		return online
+	}
+	u.lastProbe = time.Now()
+	u.Mu.Unlock()
+
+	if err := CheckConnectivity(u.Storage); err != nil {
+		return false
+	}
+	u.Mu.Lock()
+	u.offline = false
+	u.Mu.Unlock()
+	u.Logger.Info("uploader: connectivity restored; resuming uploads")
+	return true
+}
+
+// noteFailure switches the Uploader to offline mode when err is a
+// connectivity failure.
+func (u *Uploader) noteFailure(err error) {
+	var ue *UploadError
+	if !errors.As(err, &ue) || ue.Kind != ErrorKindNetwork {
+		return
+	}
+	u.Mu.Lock()
+	wasOffline := u.offline
+	u.offline = true
+	u.lastProbe = time.Now()
+	u.Mu.Unlock()
+	if !wasOffline {
+		u.Logger.Warn(fmt.Sprintf("uploader: storage unreachable (%v); keeping files spooled until connectivity returns", err))
+	}
+}
+
+func (u *Uploader) isOffline() bool {
+	u.Mu.Lock()
+	defer u.Mu.Unlock()
+	return u.offline
+}
@@ -0,0 +1,331 @@
+package uploader
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"polytube/replay/internal/info"
+)
+
+// JournalFileName is the name of the on-disk upload journal kept in every session directory.
+const JournalFileName = "upload_journal.json"
+
+// FileStatus describes how far a file has progressed through the upload pipeline.
+type FileStatus string
+
+const (
+	FileStatusPending   FileStatus = "pending"   // seen but not yet signed
+	FileStatusSigned    FileStatus = "signed"    // signed URL obtained
+	FileStatusUploaded  FileStatus = "uploaded"  // PUT returned 2xx
+	FileStatusConfirmed FileStatus = "confirmed" // uploaded and the local file did not change during the upload
+	FileStatusFailed    FileStatus = "failed"    // retries exhausted or permanent error
+)
+
+// JournalEntry records the upload state of a single file.
+// Size and ModTime describe the local file at the time the status was recorded,
+// so a file that keeps growing (playlist, internal log) is uploaded again.
+type JournalEntry struct {
+	Status    FileStatus `json:"status"`
+	Size      int64      `json:"size"`
+	ModTime   time.Time  `json:"mod_time"`
+	UpdatedAt time.Time  `json:"updated_at"`
+	Attempts  int        `json:"attempts,omitempty"`
+	Error     string     `json:"error,omitempty"`
+
+	Checksum  *Checksum        `json:"checksum,omitempty"`  // digests of the file in this state
+	Multipart *MultipartUpload `json:"multipart,omitempty"` // in-progress multipart state
+}
+
+// sameFile reports whether the entry describes the file with this size and mod time.
+func (e *JournalEntry) sameFile(size int64, modTime time.Time) bool {
+	return e.Size == size && e.ModTime.Equal(modTime)
+}
+
+// Journal is the persistent upload state of one session directory.
+// It is rewritten atomically on every change so that a crash, power loss or
+// network outage never leaves a half-written journal behind.
+type Journal struct {
+	SessionID      string                   `json:"session_id"`
+	CreatedAt      time.Time                `json:"created_at"`
+	SessionInfo    info.SessionInfo         `json:"session_info"`
+	SessionCreated bool                     `json:"session_created"`
+	SessionEnded   bool                     `json:"session_ended"`
+	Completed      bool                     `json:"completed"`
+	Game           *GameExit                `json:"game,omitempty"` // sent when the session is ended
+	Files          map[string]*JournalEntry `json:"files"`          // keyed by file name
+
+	path string
+	mu   sync.Mutex
+}
+
+// CreateJournal writes a fresh journal for a new session into dir.
+func CreateJournal(dir, sessionID string, sessionInfo info.SessionInfo) (*Journal, error) {
+	j := &Journal{
+		SessionID:   sessionID,
+		CreatedAt:   time.Now().UTC(),
+		SessionInfo: sessionInfo,
+		Files:       make(map[string]*JournalEntry),
+		path:        filepath.Join(dir, JournalFileName),
+	}
+	if err := j.Save(); err != nil {
+		return nil, err
+	}
+	return j, nil
+}
+
+// LoadJournal reads the journal stored in dir.
+func LoadJournal(dir string) (*Journal, error) {
+	path := filepath.Join(dir, JournalFileName)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("read journal: %w", err)
+	}
+	j := &Journal{path: path}
+	if err := json.Unmarshal(data, j); err != nil {
+		return nil, fmt.Errorf("parse journal %s: %w", path, err)
+	}
+	if j.SessionID == "" {
+		return nil, fmt.Errorf("journal %s has no session_id", path)
+	}
+	if j.Files == nil {
+		j.Files = make(map[string]*JournalEntry)
+	}
+	return j, nil
+}
+
+// FindUnfinishedSessions returns the session directories under baseDir whose
+// journal exists and is not marked completed.
+func FindUnfinishedSessions(baseDir string) ([]string, error) {
+	entries, err := os.ReadDir(baseDir)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("read dir: %w", err)
+	}
+
+	var dirs []string
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		dir := filepath.Join(baseDir, entry.Name())
+		j, err := LoadJournal(dir)
+		if err != nil {
+			continue
+		}
+		if !j.Completed {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
+
+// Save atomically persists the journal (write to temp file, then rename).
+func (j *Journal) Save() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.saveLocked()
+}
+
+func (j *Journal) saveLocked() error {
+	data, err := json.MarshalIndent(j, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal journal: %w", err)
+	}
+	tmp := j.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return fmt.Errorf("write journal: %w", err)
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return fmt.Errorf("replace journal: %w", err)
+	}
+	return nil
+}
+
+// SetGameExit records how the recorded game ended.
+func (j *Journal) SetGameExit(e GameExit) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Game = &e
+	return j.saveLocked()
+}
+
+// GameExit returns how the recorded game ended, or nil if unknown.
+func (j *Journal) GameExit() *GameExit {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if j.Game == nil {
+		return nil
+	}
+	e := *j.Game
+	return &e
+}
+
+// SetStatus records the status of a file along with its current size and mod time.
+func (j *Journal) SetStatus(name string, status FileStatus, size int64, modTime time.Time) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.putLocked(name, &JournalEntry{
+		Status:    status,
+		Size:      size,
+		ModTime:   modTime,
+		UpdatedAt: time.Now().UTC(),
+	})
+	return j.saveLocked()
+}
+
+// SetFailed records that the file could not be delivered after the given number of attempts.
+func (j *Journal) SetFailed(name string, size int64, modTime time.Time, attempts int, err error) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entry := &JournalEntry{
+		Status:    FileStatusFailed,
+		Size:      size,
+		ModTime:   modTime,
+		UpdatedAt: time.Now().UTC(),
+		Attempts:  attempts,
+		Error:     err.Error(),
+	}
+	// Keep multipart progress of the same file so a later attempt can resume it,
+	// unless the failure was permanent (e.g. the upload ID is no longer known).
+	// A checksum mismatch means a part arrived corrupted, so that upload starts over too.
+	if prev, ok := j.Files[name]; ok && prev.Multipart != nil && prev.sameFile(size, modTime) &&
+		classify(err).Retryable() && classify(err).Kind != ErrorKindChecksum {
+		entry.Multipart = prev.Multipart
+	}
+	j.putLocked(name, entry)
+	return j.saveLocked()
+}
+
+// SetMultipart records the progress of a multipart upload of the file.
+func (j *Journal) SetMultipart(name string, size int64, modTime time.Time, mp *MultipartUpload) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cp := *mp
+	cp.Parts = append([]CompletedPart(nil), mp.Parts...)
+	j.putLocked(name, &JournalEntry{
+		Status:    FileStatusSigned,
+		Size:      size,
+		ModTime:   modTime,
+		UpdatedAt: time.Now().UTC(),
+		Multipart: &cp,
+	})
+	return j.saveLocked()
+}
+
+// SetChecksum records the digests of the file in its current state.
+func (j *Journal) SetChecksum(name string, size int64, modTime time.Time, sum Checksum) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.Files[name]
+	if !ok || !e.sameFile(size, modTime) {
+		e = &JournalEntry{Status: FileStatusPending, Size: size, ModTime: modTime}
+		j.Files[name] = e
+	}
+	e.Checksum = &sum
+	e.UpdatedAt = time.Now().UTC()
+	return j.saveLocked()
+}
+
+// Checksum returns the recorded digests of the file if it is unchanged.
+func (j *Journal) Checksum(name string, size int64, modTime time.Time) (Checksum, bool) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.Files[name]
+	if !ok || e.Checksum == nil || !e.sameFile(size, modTime) {
+		return Checksum{}, false
+	}
+	return *e.Checksum, true
+}
+
+// putLocked replaces the entry of name, keeping the recorded checksum when the
+// file itself did not change. j.mu must be held.
+func (j *Journal) putLocked(name string, entry *JournalEntry) {
+	if prev, ok := j.Files[name]; ok && entry.Checksum == nil && prev.Checksum != nil && prev.sameFile(entry.Size, entry.ModTime) {
+		entry.Checksum = prev.Checksum
+	}
+	j.Files[name] = entry
+}
+
+// Multipart returns a copy of the recorded multipart progress of the file, or
+// nil if there is none or the file changed since it was recorded.
+func (j *Journal) Multipart(name string, size int64, modTime time.Time) *MultipartUpload {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.Files[name]
+	if !ok || e.Multipart == nil || !e.sameFile(size, modTime) {
+		return nil
+	}
+	cp := *e.Multipart
+	cp.Parts = append([]CompletedPart(nil), e.Multipart.Parts...)
+	return &cp
+}
+
+// IsDone reports whether the file was uploaded in exactly its current state.
+func (j *Journal) IsDone(name string, size int64, modTime time.Time) bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	e, ok := j.Files[name]
+	if !ok {
+		return false
+	}
+	if e.Status != FileStatusUploaded && e.Status != FileStatusConfirmed {
+		return false
+	}
+	return e.sameFile(size, modTime)
+}
+
+// MarkSessionCreated records that the remote session was created.
+func (j *Journal) MarkSessionCreated() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.SessionCreated = true
+	return j.saveLocked()
+}
+
+// MarkSessionEnded records that the remote session was patched as ended.
+func (j *Journal) MarkSessionEnded() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.SessionEnded = true
+	return j.saveLocked()
+}
+
+// MarkCompleted records that every file of the session was delivered.
+func (j *Journal) MarkCompleted() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.Completed = true
+	return j.saveLocked()
+}
+
+// IsSessionCreated reports whether the remote session was already created.
+func (j *Journal) IsSessionCreated() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.SessionCreated
+}
+
+// IsSessionEnded reports whether the remote session was already patched as ended.
+func (j *Journal) IsSessionEnded() bool {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.SessionEnded
+}
+
+// Entries returns a copy of the per-file upload state, keyed by file name.
+func (j *Journal) Entries() map[string]JournalEntry {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	entries := make(map[string]JournalEntry, len(j.Files))
+	for name, e := range j.Files {
+		entries[name] = *e
+	}
+	return entries
+}
@@ -0,0 +1,174 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultPartSize is the part size used when Uploader.PartSize is left zero.
+// Files larger than the part size are uploaded in parts when the storage
+// supports it. S3 requires at least 5 MiB for every part but the last.
+const DefaultPartSize = 8 * 1024 * 1024
+
+// ErrMultipartUnsupported is returned by StartMultipart when the server does
+// not offer multipart uploads; the Uploader then falls back to a single PUT.
+var ErrMultipartUnsupported = errors.New("multipart upload not supported by storage")
+
+// MultipartStorage is implemented by storages that can receive a file in
+// independently retried parts. Progress is kept in a MultipartUpload which the
+// journal persists, so an interrupted upload resumes at the first missing part.
+type MultipartStorage interface {
+	// StartMultipart begins a multipart upload of obj with the requested part size.
+	// The storage may choose a different part size.
+	StartMultipart(sessionID string, obj Object, partSize int64) (*MultipartUpload, error)
+	// SignPart prepares the destination of one part (1-based); part carries
+	// the size and checksum of that part.
+	SignPart(sessionID string, obj Object, mp *MultipartUpload, partNumber int, part Object) (Target, error)
+	// PutPart sends one part and returns its ETag.
+	PutPart(target Target, part Object) (string, error)
+	// CompleteMultipart assembles the uploaded parts into the final object.
+	CompleteMultipart(sessionID string, obj Object, mp *MultipartUpload) error
+}
+
+// MultipartUpload is the resumable state of a multipart upload.
+type MultipartUpload struct {
+	UploadID string          `json:"upload_id"`
+	PartSize int64           `json:"part_size"`
+	Parts    []CompletedPart `json:"parts"` // parts already uploaded
+}
+
+// CompletedPart is an uploaded part and the ETag the storage returned for it.
+type CompletedPart struct {
+	Number int    `json:"number"`
+	ETag   string `json:"etag"`
+	Size   int64  `json:"size"`
+}
+
+// partCount returns the number of parts needed for size bytes.
+func (mp *MultipartUpload) partCount(size int64) int {
+	if size <= 0 {
+		return 1
+	}
+	return int((size + mp.PartSize - 1) / mp.PartSize)
+}
+
+// hasPart reports whether part n was already uploaded.
+func (mp *MultipartUpload) hasPart(n int) bool {
+	for _, p := range mp.Parts {
+		if p.Number == n {
+			return true
+		}
+	}
+	return false
+}
+
+// sortedParts returns parts ordered by part number.
+func sortedParts(parts []CompletedPart) []CompletedPart {
+	sorted := append([]CompletedPart(nil), parts...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Number < sorted[j].Number })
+	return sorted
+}
+
+func (u *Uploader) partSize() int64 {
+	if u.PartSize > 0 {
+		return u.PartSize
+	}
+	return DefaultPartSize
+}
+
+// uploadMultipart uploads path in parts, resuming from the journal when the
+// file is unchanged. Every part is retried on its own, so a flaky network only
+// costs the current part. It returns the total number of attempts made.
+func (u *Uploader) uploadMultipart(ms MultipartStorage, path string, before os.FileInfo, sum Checksum) (int, error) {
+	fileName := filepath.Base(path)
+	size := before.Size()
+	obj := Object{Name: fileName, Path: path, Size: size, Checksum: sum}
+	total := 0
+
+	var mp *MultipartUpload
+	if u.Journal != nil {
+		mp = u.Journal.Multipart(fileName, size, before.ModTime())
+	}
+	if mp != nil {
+		u.Logger.Info(fmt.Sprintf("uploader: resuming multipart upload of %s (%d part(s) done)", fileName, len(mp.Parts)))
+	} else {
+		attempts, err := u.retry("start multipart "+fileName, func() (err error) {
+			mp, err = ms.StartMultipart(u.SessionID, obj, u.partSize())
+			return err
+		})
+		total += attempts
+		if err != nil {
+			return total, err
+		}
+		u.saveMultipart(path, before, mp)
+	}
+
+	parts := mp.partCount(size)
+	for n := 1; n <= parts; n++ {
+		if mp.hasPart(n) {
+			continue
+		}
+		offset := int64(n-1) * mp.PartSize
+		length := mp.PartSize
+		if offset+length > size {
+			length = size - offset
+		}
+
+		partSum, err := fileChecksum(path, offset, length, u.ContentMD5)
+		if err != nil {
+			return total, err
+		}
+
+		var target *Target
+		var etag string
+		attempts, err := u.retry(fmt.Sprintf("upload %s part %d/%d", fileName, n, parts), func() error {
+			part := Object{Name: fileName, Path: path, Size: length, Checksum: partSum}
+			if target == nil {
+				t, err := ms.SignPart(u.SessionID, obj, mp, n, part)
+				if err != nil {
+					return err
+				}
+				target = &t
+			}
+			file, err := os.Open(path)
+			if err != nil {
+				return fmt.Errorf("open file: %w", err)
+			}
+			defer file.Close()
+			part.Body = u.Bandwidth.Reader(io.NewSectionReader(file, offset, length))
+
+			etag, err = ms.PutPart(*target, part)
+			if err != nil && classify(err).Kind == ErrorKindExpiredURL {
+				u.Logger.Info(fmt.Sprintf("uploader: part URL for %s part %d rejected; re-signing", fileName, n))
+				target = nil
+			}
+			return err
+		})
+		total += attempts
+		if err != nil {
+			return total, err
+		}
+		mp.Parts = append(mp.Parts, CompletedPart{Number: n, ETag: etag, Size: length})
+		u.saveMultipart(path, before, mp)
+	}
+
+	attempts, err := u.retry("complete multipart "+fileName, func() error {
+		return ms.CompleteMultipart(u.SessionID, obj, mp)
+	})
+	total += attempts
+	return total, err
+}
+
+// saveMultipart persists multipart progress so the next run can resume it.
+func (u *Uploader) saveMultipart(path string, fi os.FileInfo, mp *MultipartUpload) {
+	if u.Journal == nil {
+		return
+	}
+	if err := u.Journal.SetMultipart(filepath.Base(path), fi.Size(), fi.ModTime(), mp); err != nil {
+		u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal for %s: %v", filepath.Base(path), err))
+	}
+}
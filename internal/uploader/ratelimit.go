@@ -0,0 +1,88 @@
+package uploader
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// DefaultMaxConcurrent is the number of simultaneous uploads used when
+// Uploader.MaxConcurrent is left zero.
+const DefaultMaxConcurrent = 2
+
+// limiterChunk is the largest read performed before waiting for tokens, which
+// keeps the bucket's burst small and the resulting traffic smooth.
+const limiterChunk = 32 * 1024
+
+// BandwidthLimiter is a token bucket (tokens are bytes) shared by every upload
+// so the total upload rate stays below a fixed budget, regardless of how many
+// uploads run concurrently. A nil *BandwidthLimiter does not limit.
+type BandwidthLimiter struct {
+	rate   float64 // bytes per second
+	burst  float64 // bucket capacity in bytes
+	tokens float64
+	last   time.Time
+	mu     sync.Mutex
+}
+
+// NewBandwidthLimiter returns a limiter allowing bytesPerSecond on average, or
+// nil (unlimited) if bytesPerSecond <= 0.
+func NewBandwidthLimiter(bytesPerSecond int64) *BandwidthLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	burst := float64(bytesPerSecond)
+	if burst < limiterChunk {
+		burst = limiterChunk
+	}
+	return &BandwidthLimiter{
+		rate:   float64(bytesPerSecond),
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// WaitN blocks until n bytes may be sent. n must not exceed limiterChunk.
+func (l *BandwidthLimiter) WaitN(n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+	// Reserve the tokens now (possibly going negative) so concurrent callers queue fairly.
+	l.tokens -= float64(n)
+	deficit := -l.tokens
+	l.mu.Unlock()
+
+	if deficit > 0 {
+		time.Sleep(time.Duration(deficit / l.rate * float64(time.Second)))
+	}
+}
+
+// Reader wraps r so that reads consume tokens from the limiter.
+func (l *BandwidthLimiter) Reader(r io.Reader) io.Reader {
+	if l == nil {
+		return r
+	}
+	return &limitedReader{r: r, l: l}
+}
+
+type limitedReader struct {
+	r io.Reader
+	l *BandwidthLimiter
+}
+
+func (lr *limitedReader) Read(p []byte) (int, error) {
+	if len(p) > limiterChunk {
+		p = p[:limiterChunk]
+	}
+	n, err := lr.r.Read(p)
+	lr.l.WaitN(n)
+	return n, err
+}
@@ -0,0 +1,218 @@
+package uploader
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies upload failures so the retry loop knows whether to
+// back off, re-sign the URL or give up.
+type ErrorKind int
+
+const (
+	ErrorKindNetwork     ErrorKind = iota // DNS, refused, reset, timeout: retry
+	ErrorKindServer                       // 5xx: retry
+	ErrorKindRateLimited                  // 429: retry after Retry-After
+	ErrorKindExpiredURL                   // signed URL expired or rejected: re-sign and retry
+	ErrorKindPermanent                    // other 4xx or local errors: give up
+	ErrorKindChecksum                     // content arrived corrupted: upload again
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindNetwork:
+		return "NETWORK"
+	case ErrorKindServer:
+		return "SERVER"
+	case ErrorKindRateLimited:
+		return "RATE_LIMITED"
+	case ErrorKindExpiredURL:
+		return "EXPIRED_URL"
+	case ErrorKindPermanent:
+		return "PERMANENT"
+	case ErrorKindChecksum:
+		return "CHECKSUM_MISMATCH"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// UploadError is a classified failure of a request made by the uploader.
+type UploadError struct {
+	Kind       ErrorKind
+	StatusCode int           // 0 for transport errors
+	RetryAfter time.Duration // parsed Retry-After header, if any
+	Err        error
+}
+
+func (e *UploadError) Error() string {
+	return fmt.Sprintf("%s: %v", e.Kind, e.Err)
+}
+
+func (e *UploadError) Unwrap() error {
+	return e.Err
+}
+
+// Retryable reports whether another attempt may succeed.
+func (e *UploadError) Retryable() bool {
+	return e.Kind != ErrorKindPermanent
+}
+
+// classify returns err as an *UploadError. Unclassified errors are permanent.
+func classify(err error) *UploadError {
+	var ue *UploadError
+	if errors.As(err, &ue) {
+		return ue
+	}
+	return &UploadError{Kind: ErrorKindPermanent, Err: err}
+}
+
+// networkError wraps a transport-level failure (client.Do returned an error).
+func networkError(err error) *UploadError {
+	return &UploadError{Kind: ErrorKindNetwork, Err: err}
+}
+
+// statusError classifies a non-2xx response. signedURL marks responses from the
+// storage provider behind a signed URL, where 401/403 (and 400 mentioning
+// expiry) mean the signature is no longer valid rather than a permanent error.
+func statusError(resp *http.Response, body []byte, signedURL bool) *UploadError {
+	ue := &UploadError{
+		StatusCode: resp.StatusCode,
+		Err:        fmt.Errorf("server returned %d: %s", resp.StatusCode, strings.TrimSpace(string(body))),
+	}
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		ue.Kind = ErrorKindRateLimited
+		ue.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case resp.StatusCode >= 500:
+		ue.Kind = ErrorKindServer
+		ue.RetryAfter = parseRetryAfter(resp.Header.Get("Retry-After"), time.Now())
+	case resp.StatusCode == http.StatusRequestTimeout:
+		ue.Kind = ErrorKindNetwork
+	case resp.StatusCode == http.StatusBadRequest && isChecksumMismatch(body):
+		ue.Kind = ErrorKindChecksum
+	case signedURL && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden):
+		ue.Kind = ErrorKindExpiredURL
+	case signedURL && resp.StatusCode == http.StatusBadRequest && strings.Contains(strings.ToLower(string(body)), "expired"):
+		ue.Kind = ErrorKindExpiredURL
+	default:
+		ue.Kind = ErrorKindPermanent
+	}
+	return ue
+}
+
+// isChecksumMismatch recognizes the error bodies servers send when the content
+// does not match the checksum sent with it (S3 BadDigest and friends).
+func isChecksumMismatch(body []byte) bool {
+	b := strings.ToLower(string(body))
+	return strings.Contains(b, "baddigest") ||
+		strings.Contains(b, "checksum mismatch") ||
+		strings.Contains(b, "sha256mismatch") ||
+		strings.Contains(b, "did not match the value you specified")
+}
+
+// parseRetryAfter parses a Retry-After header given either as delay-seconds or an HTTP date.
+func parseRetryAfter(v string, now time.Time) time.Duration {
+	v = strings.TrimSpace(v)
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil {
+		if secs < 0 {
+			return 0
+		}
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := t.Sub(now); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+// RetryPolicy controls how failed requests are retried.
+type RetryPolicy struct {
+	MaxAttempts int           // total attempts per file, including the first
+	BaseDelay   time.Duration // delay before the first retry
+	MaxDelay    time.Duration // cap for the exponential backoff
+}
+
+// DefaultRetryPolicy is used when Uploader.Retry is left zero.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 6,
+	BaseDelay:   time.Second,
+	MaxDelay:    time.Minute,
+}
+
+// Backoff returns the delay before retry number attempt (1-based) using
+// exponential backoff with full jitter. A server-provided Retry-After wins
+// when it is longer, up to MaxDelay.
+func (p RetryPolicy) Backoff(attempt int, err *UploadError) time.Duration {
+	ceiling := p.BaseDelay
+	for i := 1; i < attempt && ceiling < p.MaxDelay; i++ {
+		ceiling *= 2
+	}
+	if ceiling > p.MaxDelay {
+		ceiling = p.MaxDelay
+	}
+	delay := time.Duration(0)
+	if ceiling > 0 {
+		delay = time.Duration(rand.Int63n(int64(ceiling) + 1))
+	}
+	if err != nil && err.RetryAfter > delay {
+		delay = min(err.RetryAfter, p.MaxDelay)
+	}
+	return delay
+}
+
+// retry runs fn until it succeeds, fails permanently or the policy is exhausted.
+// It returns the number of attempts made and the last error.
+//
+// When the attempts are exhausted by a connectivity failure the Uploader goes
+// offline (see Online).
+func (u *Uploader) retry(what string, fn func() error) (int, error) {
+	policy := u.retryPolicy()
+	var lastErr *UploadError
+	for attempt := 1; attempt <= policy.MaxAttempts; attempt++ {
+		if attempt > 1 {
+			delay := policy.Backoff(attempt-1, lastErr)
+			u.Logger.Warn(fmt.Sprintf("uploader: %s failed (%v); retry %d/%d in %s", what, lastErr, attempt-1, policy.MaxAttempts-1, delay.Round(time.Millisecond)))
+			time.Sleep(delay)
+		}
+		err := fn()
+		if err == nil {
+			return attempt, nil
+		}
+		lastErr = classify(err)
+		if !lastErr.Retryable() {
+			return attempt, lastErr
+		}
+		// Another upload already found the storage unreachable; don't keep
+		// backing off on every file.
+		if lastErr.Kind == ErrorKindNetwork && u.isOffline() {
+			return attempt, lastErr
+		}
+	}
+	u.noteFailure(lastErr)
+	return policy.MaxAttempts, lastErr
+}
+
+func (u *Uploader) retryPolicy() RetryPolicy {
+	p := u.Retry
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = DefaultRetryPolicy.MaxAttempts
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = DefaultRetryPolicy.BaseDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = DefaultRetryPolicy.MaxDelay
+	}
+	return p
+}
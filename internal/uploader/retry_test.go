@@ -0,0 +1,198 @@
+package uploader
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"polytube/replay/internal/logger"
+)
+
+func TestClassify(t *testing.T) {
+	tests := []struct {
+		name      string
+		status    int
+		header    http.Header
+		body      string
+		signedURL bool
+		want      ErrorKind
+		retryIn   time.Duration
+	}{
+		{name: "server error", status: 503, want: ErrorKindServer},
+		{name: "server error with Retry-After", status: 502, header: http.Header{"Retry-After": {"7"}}, want: ErrorKindServer, retryIn: 7 * time.Second},
+		{name: "rate limited", status: 429, header: http.Header{"Retry-After": {"3"}}, want: ErrorKindRateLimited, retryIn: 3 * time.Second},
+		{name: "request timeout", status: 408, want: ErrorKindNetwork},
+		{name: "checksum mismatch", status: 400, body: "<Code>BadDigest</Code>", want: ErrorKindChecksum},
+		{name: "signed URL forbidden", status: 403, signedURL: true, want: ErrorKindExpiredURL},
+		{name: "signed URL expired", status: 400, body: "Request has expired", signedURL: true, want: ErrorKindExpiredURL},
+		{name: "API forbidden", status: 403, want: ErrorKindPermanent},
+		{name: "not found", status: 404, signedURL: true, want: ErrorKindPermanent},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: tt.header}
+			if resp.Header == nil {
+				resp.Header = http.Header{}
+			}
+			got := classify(statusError(resp, []byte(tt.body), tt.signedURL))
+			if got.Kind != tt.want || got.StatusCode != tt.status || got.RetryAfter != tt.retryIn {
+				t.Errorf("got %v (status %d, retry after %s), want %v (status %d, retry after %s)",
+					got.Kind, got.StatusCode, got.RetryAfter, tt.want, tt.status, tt.retryIn)
+			}
+		})
+	}
+
+	if got := classify(networkError(errors.New("connection refused"))); got.Kind != ErrorKindNetwork || !got.Retryable() {
+		t.Errorf("network error classified as %v", got.Kind)
+	}
+	if got := classify(errors.New("open file: access denied")); got.Kind != ErrorKindPermanent || got.Retryable() {
+		t.Errorf("local error classified as %v", got.Kind)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 10, 4, 14, 5, 0, 0, time.UTC)
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"", 0},
+		{"0", 0},
+		{"120", 2 * time.Minute},
+		{" 5 ", 5 * time.Second},
+		{"-1", 0},
+		{"soon", 0},
+		{now.Add(90 * time.Second).Format(http.TimeFormat), 90 * time.Second},
+		{now.Add(-time.Minute).Format(http.TimeFormat), 0},
+	}
+	for _, tt := range tests {
+		if got := parseRetryAfter(tt.value, now); got != tt.want {
+			t.Errorf("parseRetryAfter(%q) = %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestBackoffCapsRetryAfter(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	for attempt := 1; attempt <= 10; attempt++ {
+		if d := p.Backoff(attempt, nil); d < 0 || d > p.MaxDelay {
+			t.Errorf("Backoff(%d) = %s, want within [0, %s]", attempt, d, p.MaxDelay)
+		}
+	}
+	if d := p.Backoff(1, &UploadError{Kind: ErrorKindRateLimited, RetryAfter: 24 * time.Hour}); d != p.MaxDelay {
+		t.Errorf("Backoff with Retry-After 24h = %s, want %s", d, p.MaxDelay)
+	}
+	if d := p.Backoff(1, &UploadError{Kind: ErrorKindRateLimited, RetryAfter: 30 * time.Second}); d < 30*time.Second {
+		t.Errorf("Backoff with Retry-After 30s = %s, want at least 30s", d)
+	}
+}
+
+// fakePolytube stands in for polytube.io: it signs PUT URLs pointing back at
+// itself and answers the PUTs with the queued status codes, then 200.
+type fakePolytube struct {
+	mu       sync.Mutex
+	statuses []int
+	signs    int
+	puts     int
+}
+
+func (f *fakePolytube) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch {
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/api/sign/"):
+		f.signs++
+		w.Write([]byte("http://" + r.Host + "/bucket/output_000.ts"))
+	case r.Method == http.MethodPut && r.URL.Path == "/bucket/output_000.ts":
+		f.puts++
+		status := http.StatusOK
+		if len(f.statuses) > 0 {
+			status, f.statuses = f.statuses[0], f.statuses[1:]
+		}
+		if status == http.StatusTooManyRequests {
+			w.Header().Set("Retry-After", "86400")
+		}
+		w.WriteHeader(status)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// newTestUploader returns an Uploader for srv with fast retries and a segment
+// file to upload.
+func newTestUploader(t *testing.T, srv *httptest.Server) (*Uploader, string, os.FileInfo) {
+	t.Helper()
+	dir := t.TempDir()
+	log, err := logger.NewLogger(filepath.Join(dir, "internal.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { log.Close() })
+	path := filepath.Join(dir, "output_000.ts")
+	if err := os.WriteFile(path, []byte("segment"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &Uploader{
+		DirPath:   dir,
+		SessionID: "session",
+		Storage: &PolytubeStorage{
+			EndpointURL:    srv.URL,
+			ApiID:          "id",
+			ApiKey:         "key",
+			Client:         srv.Client(),
+			TransferClient: srv.Client(),
+			Logger:         log,
+		},
+		Logger: log,
+		Retry:  RetryPolicy{MaxAttempts: 6, BaseDelay: time.Millisecond, MaxDelay: 20 * time.Millisecond},
+	}
+	return u, path, fi
+}
+
+func TestRetryAgainstPolytube(t *testing.T) {
+	fake := &fakePolytube{statuses: []int{
+		http.StatusServiceUnavailable,
+		http.StatusTooManyRequests, // Retry-After: 86400, capped at MaxDelay
+		http.StatusForbidden,       // signed URL expired: re-sign
+	}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	u, path, fi := newTestUploader(t, srv)
+
+	start := time.Now()
+	attempts, err := u.uploadSingle(path, fi, Checksum{})
+	if err != nil {
+		t.Fatalf("upload failed after %d attempt(s): %v", attempts, err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("upload took %s; Retry-After was not capped", elapsed)
+	}
+	if attempts != 4 || fake.puts != 4 {
+		t.Errorf("got %d attempt(s) and %d PUT(s), want 4", attempts, fake.puts)
+	}
+	if fake.signs != 2 {
+		t.Errorf("got %d sign request(s), want 2 (re-signed after 403)", fake.signs)
+	}
+}
+
+func TestRetryGivesUpOnPermanentError(t *testing.T) {
+	fake := &fakePolytube{statuses: []int{http.StatusNotFound}}
+	srv := httptest.NewServer(fake)
+	defer srv.Close()
+	u, path, fi := newTestUploader(t, srv)
+
+	attempts, err := u.uploadSingle(path, fi, Checksum{})
+	if classify(err).Kind != ErrorKindPermanent || attempts != 1 {
+		t.Errorf("got %d attempt(s), error %v; want 1 attempt and a permanent error", attempts, err)
+	}
+}
@@ -0,0 +1,105 @@
+package uploader
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// SpoolQuota bounds the sessions kept on disk waiting for upload. Zero values
+// mean unlimited.
+type SpoolQuota struct {
+	MaxBytes int64         // total size of all pending sessions
+	MaxAge   time.Duration // age of the oldest pending session
+}
+
+// PendingSession is an unfinished session directory in the spool.
+type PendingSession struct {
+	Dir       string
+	SessionID string
+	CreatedAt time.Time
+	Size      int64 // bytes on disk
+}
+
+// ListPendingSessions returns the unfinished sessions under baseDir, oldest first.
+func ListPendingSessions(baseDir string) ([]PendingSession, error) {
+	dirs, err := FindUnfinishedSessions(baseDir)
+	if err != nil {
+		return nil, err
+	}
+	sessions := make([]PendingSession, 0, len(dirs))
+	for _, dir := range dirs {
+		j, err := LoadJournal(dir)
+		if err != nil {
+			continue
+		}
+		created := j.CreatedAt
+		if created.IsZero() {
+			// Journals written before created_at existed.
+			if fi, err := os.Stat(dir); err == nil {
+				created = fi.ModTime()
+			}
+		}
+		sessions = append(sessions, PendingSession{
+			Dir:       dir,
+			SessionID: j.SessionID,
+			CreatedAt: created,
+			Size:      dirSize(dir),
+		})
+	}
+	sort.Slice(sessions, func(i, k int) bool { return sessions[i].CreatedAt.Before(sessions[k].CreatedAt) })
+	return sessions, nil
+}
+
+// EnforceSpoolQuota deletes pending sessions under baseDir that are older than
+// quota.MaxAge, then the oldest ones until the rest fit in quota.MaxBytes.
+// The session directory keep (the one being recorded) is never deleted.
+// It returns the sessions that were deleted.
+func EnforceSpoolQuota(baseDir string, quota SpoolQuota, keep string) ([]PendingSession, error) {
+	sessions, err := ListPendingSessions(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var total int64
+	for _, s := range sessions {
+		total += s.Size
+	}
+
+	var removed []PendingSession
+	now := time.Now()
+	for _, s := range sessions {
+		if keep != "" && filepath.Clean(s.Dir) == filepath.Clean(keep) {
+			continue
+		}
+		tooOld := quota.MaxAge > 0 && now.Sub(s.CreatedAt) > quota.MaxAge
+		tooBig := quota.MaxBytes > 0 && total > quota.MaxBytes
+		if !tooOld && !tooBig {
+			continue
+		}
+		if err := os.RemoveAll(s.Dir); err != nil {
+			return removed, fmt.Errorf("remove %s: %w", s.Dir, err)
+		}
+		total -= s.Size
+		removed = append(removed, s)
+	}
+	return removed, nil
+}
+
+// dirSize returns the total size of the regular files under dir.
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		if fi, err := d.Info(); err == nil {
+			size += fi.Size()
+		}
+		return nil
+	})
+	return size
+}
@@ -0,0 +1,155 @@
+package uploader
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+)
+
+// Storage is a destination for session files and session metadata.
+//
+// Uploading a file is split in two steps so the Uploader can retry them
+// independently: Sign prepares a destination (a signed URL, a presigned S3
+// request, a local path) and Put sends one attempt of the file body to it.
+// When Put fails with ErrorKindExpiredURL the Uploader calls Sign again.
+//
+// Objects carry their checksum. Backends send it along (sign request, PUT
+// headers) and Put fails with ErrorKindChecksum when the stored content does
+// not match, so the Uploader sends the file again.
+type Storage interface {
+	// Ready reports whether the storage is configured well enough to upload
+	// (e.g. credentials are present).
+	Ready() error
+	// CreateSession registers a new session and its metadata.
+	CreateSession(sessionID string, sessionInfo info.SessionInfo) error
+	// EndSession marks the session as ended.
+	EndSession(sessionID string, params PatchSessionParams) error
+	// Sign prepares the destination for obj.
+	Sign(sessionID string, obj Object) (Target, error)
+	// Put sends obj.Body to target.
+	Put(target Target, obj Object) error
+}
+
+// Object is a single local file to upload.
+type Object struct {
+	Name string    // file name, used as the key within the session
+	Path string    // local path
+	Size int64     // content length in bytes
+	Body io.Reader // file content for this attempt (possibly rate limited)
+
+	Checksum Checksum // digests of the content; zero if not computed
+}
+
+// Target is a prepared upload destination returned by Storage.Sign.
+type Target struct {
+	Location string      // URL or local path
+	Header   http.Header // extra headers to send with the PUT, if any
+}
+
+// PatchSessionParams is the body of the session end update.
+type PatchSessionParams struct {
+	Ends bool `json:"ends" db:"ends"`
+	*GameExit
+}
+
+// GameExit describes how the recorded game ended, so crashing sessions can be
+// filtered. It is sent with the session end update when known.
+type GameExit struct {
+	Status    string  `json:"game_status" db:"game_status"`         // exited, crashed or hung
+	ExitCode  *uint32 `json:"exit_code,omitempty" db:"exit_code"`   // nil if the game did not exit
+	CrashDump string  `json:"crash_dump,omitempty" db:"crash_dump"` // file name of the crash dump it left
+}
+
+// StorageOptions carries the settings shared by all storage backends.
+type StorageOptions struct {
+	Endpoint string // polytube.io endpoint, used when the storage URL is empty
+	ApiID    string // API ID, S3 access key or HTTP basic-auth user
+	ApiKey   string // API key, S3 secret key or HTTP basic-auth password
+	Logger   logger.LoggerInterface
+}
+
+// NewStorage selects a storage backend from a URL scheme:
+//
+//	""                      polytube.io at opts.Endpoint
+//	https://host            polytube.io-compatible API at host
+//	s3://bucket/prefix      S3-compatible bucket (?endpoint=, ?region=, ?path_style=)
+//	file:///C:/dir          local or mounted directory (file://server/share for UNC)
+//	put+https://host/base   plain HTTP PUT to <base>/<session>/<file>
+func NewStorage(rawURL string, opts StorageOptions) (Storage, error) {
+	if strings.TrimSpace(rawURL) == "" {
+		return NewPolytubeStorage(opts.Endpoint, opts), nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("parse storage URL: %w", err)
+	}
+	switch strings.ToLower(u.Scheme) {
+	case "http", "https":
+		return NewPolytubeStorage(rawURL, opts), nil
+	case "s3":
+		return NewS3Storage(u, opts)
+	case "file":
+		return NewLocalStorage(u, opts)
+	case "put+http", "put+https":
+		return NewHTTPPutStorage(u, opts)
+	default:
+		return nil, fmt.Errorf("unsupported storage scheme %q (use https, s3, file or put+https)", u.Scheme)
+	}
+}
+
+// newAPIClient returns the client used for short API calls.
+func newAPIClient() *http.Client {
+	return &http.Client{Timeout: 30 * time.Second}
+}
+
+// newTransferClient returns the client used for file bodies. A whole-request
+// timeout would abort large or bandwidth-limited uploads, so only connection
+// setup and the wait for response headers are bounded.
+func newTransferClient() *http.Client {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = 60 * time.Second
+	return &http.Client{Transport: transport}
+}
+
+// putHTTP sends obj.Body to an HTTP target with an exact Content-Length,
+// verifies the digests reported in the response against obj.Checksum and
+// returns the response headers (ETag).
+func putHTTP(client *http.Client, target Target, obj Object) (http.Header, error) {
+	req, err := http.NewRequest(http.MethodPut, target.Location, obj.Body)
+	if err != nil {
+		return nil, fmt.Errorf("create PUT request: %w", err)
+	}
+	// Signed URLs are issued for an exact content length; avoid chunked encoding.
+	req.ContentLength = obj.Size
+	req.Header.Set("Content-Type", "application/octet-stream")
+	if md5 := obj.Checksum.MD5Base64(); md5 != "" {
+		req.Header.Set("Content-MD5", md5)
+	}
+	for k, vs := range target.Header {
+		req.Header.Del(k)
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, networkError(fmt.Errorf("PUT request failed: %w", err))
+	}
+	defer resp.Body.Close()
+
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, statusError(resp, body, true)
+	}
+	if err := verifyResponse(resp.Header, obj.Checksum); err != nil {
+		return nil, err
+	}
+	return resp.Header, nil
+}
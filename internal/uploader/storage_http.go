@@ -0,0 +1,108 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+)
+
+// HTTPPutStorage uploads with plain HTTP PUT requests to
+// <base>/<session-id>/<file>, e.g. a WebDAV share or a simple upload server.
+// If --api-id and --api-key are set they are sent as HTTP basic auth.
+//
+// Storage URL: put+https://files.example.com/playtests
+type HTTPPutStorage struct {
+	BaseURL  string
+	Username string
+	Password string
+	Client   *http.Client
+	Logger   logger.LoggerInterface
+}
+
+// NewHTTPPutStorage parses a put+http(s):// storage URL.
+func NewHTTPPutStorage(u *url.URL, opts StorageOptions) (*HTTPPutStorage, error) {
+	base := *u
+	base.Scheme = strings.TrimPrefix(strings.ToLower(u.Scheme), "put+")
+	if base.Host == "" {
+		return nil, fmt.Errorf("HTTP PUT storage URL needs a host: %s", u.Redacted())
+	}
+	return &HTTPPutStorage{
+		BaseURL:  strings.TrimSuffix(base.String(), "/"),
+		Username: opts.ApiID,
+		Password: opts.ApiKey,
+		Client:   newTransferClient(),
+		Logger:   opts.Logger,
+	}, nil
+}
+
+func (s *HTTPPutStorage) Ready() error {
+	return nil
+}
+
+func (s *HTTPPutStorage) objectURL(sessionID, name string) string {
+	return s.BaseURL + "/" + url.PathEscape(sessionID) + "/" + url.PathEscape(name)
+}
+
+func (s *HTTPPutStorage) header() http.Header {
+	h := http.Header{}
+	if s.Username != "" || s.Password != "" {
+		req := &http.Request{Header: h}
+		req.SetBasicAuth(s.Username, s.Password)
+	}
+	return h
+}
+
+func (s *HTTPPutStorage) CreateSession(sessionID string, sessionInfo info.SessionInfo) error {
+	body, err := json.Marshal(sessionInfo)
+	if err != nil {
+		return fmt.Errorf("marshal SessionInfo: %w", err)
+	}
+	return s.putJSON(sessionID, "session_info.json", body)
+}
+
+func (s *HTTPPutStorage) EndSession(sessionID string, params PatchSessionParams) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+	return s.putJSON(sessionID, "session_end.json", body)
+}
+
+func (s *HTTPPutStorage) putJSON(sessionID, name string, body []byte) error {
+	target := Target{Location: s.objectURL(sessionID, name), Header: s.header()}
+	target.Header.Set("Content-Type", "application/json")
+	s.Logger.Info(fmt.Sprintf("uploader: writing %s", target.Location))
+	_, err := putHTTP(s.Client, target, Object{Name: name, Size: int64(len(body)), Body: bytes.NewReader(body)})
+	return err
+}
+
+// Sign returns the object URL. The SHA-256 is sent as an RFC 9530
+// Content-Digest header, which servers may check and echo back.
+func (s *HTTPPutStorage) Sign(sessionID string, obj Object) (Target, error) {
+	target := Target{Location: s.objectURL(sessionID, obj.Name), Header: s.header()}
+	if sha := obj.Checksum.SHA256Base64(); sha != "" {
+		target.Header.Set("Content-Digest", "sha-256=:"+sha+":")
+	}
+	return target, nil
+}
+
+// Put sends the file. The URL is not signed, so 401/403 mean bad credentials
+// rather than an expired signature and are not worth re-signing.
+func (s *HTTPPutStorage) Put(target Target, obj Object) error {
+	_, err := putHTTP(s.Client, target, obj)
+	if err != nil && classify(err).Kind == ErrorKindExpiredURL {
+		return &UploadError{Kind: ErrorKindPermanent, StatusCode: classify(err).StatusCode, Err: classify(err).Err}
+	}
+	return err
+}
+
+// Ping checks that the server answers at all.
+func (s *HTTPPutStorage) Ping() error {
+	return pingHTTP(s.Client, s.BaseURL)
+}
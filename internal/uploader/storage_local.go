@@ -0,0 +1,134 @@
+package uploader
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+)
+
+var windowsDrivePath = regexp.MustCompile(`^/[A-Za-z]:`)
+
+// LocalStorage copies session files into a local or mounted directory as
+// <root>/<session-id>/<file>. Session metadata is written to session_info.json
+// and session_end.json next to them.
+//
+// Storage URL: file:///C:/Playtests or file://server/share/playtests (UNC).
+type LocalStorage struct {
+	Root   string
+	Logger logger.LoggerInterface
+}
+
+// NewLocalStorage parses a file:// storage URL.
+func NewLocalStorage(u *url.URL, opts StorageOptions) (*LocalStorage, error) {
+	p := u.Path
+	switch {
+	case u.Host != "" && u.Host != "localhost":
+		// file://server/share/dir -> \\server\share\dir
+		p = `\\` + u.Host + filepath.FromSlash(p)
+	case windowsDrivePath.MatchString(p):
+		// file:///C:/dir -> C:\dir
+		p = filepath.FromSlash(p[1:])
+	default:
+		p = filepath.FromSlash(p)
+	}
+	if strings.TrimSpace(p) == "" {
+		return nil, errors.New("file storage URL needs a directory: file:///C:/dir")
+	}
+	return &LocalStorage{Root: p, Logger: opts.Logger}, nil
+}
+
+func (s *LocalStorage) Ready() error {
+	if err := os.MkdirAll(s.Root, 0o755); err != nil {
+		return fmt.Errorf("storage directory %s is not writable: %w", s.Root, err)
+	}
+	return nil
+}
+
+func (s *LocalStorage) CreateSession(sessionID string, sessionInfo info.SessionInfo) error {
+	body, err := json.MarshalIndent(sessionInfo, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal SessionInfo: %w", err)
+	}
+	return s.writeFile(filepath.Join(s.Root, sessionID, "session_info.json"), body)
+}
+
+func (s *LocalStorage) EndSession(sessionID string, params PatchSessionParams) error {
+	body, err := json.MarshalIndent(params, "", "\t")
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+	return s.writeFile(filepath.Join(s.Root, sessionID, "session_end.json"), body)
+}
+
+func (s *LocalStorage) writeFile(dst string, body []byte) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return networkError(fmt.Errorf("create directory: %w", err))
+	}
+	if err := os.WriteFile(dst, body, 0o644); err != nil {
+		return networkError(fmt.Errorf("write %s: %w", dst, err))
+	}
+	return nil
+}
+
+// Sign resolves the destination path of obj.
+func (s *LocalStorage) Sign(sessionID string, obj Object) (Target, error) {
+	return Target{Location: filepath.Join(s.Root, sessionID, obj.Name)}, nil
+}
+
+// Put copies obj.Body to a temporary file next to the destination and renames
+// it into place, so readers of the share never see a partial file. The copy is
+// hashed on the way and must match obj.Checksum. I/O errors are reported as
+// network errors because a mounted share behaves like one.
+func (s *LocalStorage) Put(target Target, obj Object) error {
+	dst := target.Location
+	if err := os.MkdirAll(filepath.Dir(dst), 0o755); err != nil {
+		return networkError(fmt.Errorf("create directory: %w", err))
+	}
+	tmp := dst + ".part"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return networkError(fmt.Errorf("create %s: %w", tmp, err))
+	}
+	sha := sha256.New()
+	n, err := io.Copy(io.MultiWriter(f, sha), obj.Body)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		_ = os.Remove(tmp)
+		return networkError(fmt.Errorf("copy to %s: %w", tmp, err))
+	}
+	if n != obj.Size {
+		_ = os.Remove(tmp)
+		return networkError(fmt.Errorf("copied %d of %d bytes to %s", n, obj.Size, tmp))
+	}
+	if got := hex.EncodeToString(sha.Sum(nil)); obj.Checksum.SHA256 != "" && got != obj.Checksum.SHA256 {
+		_ = os.Remove(tmp)
+		return checksumError("copied sha256 %s, expected %s", got, obj.Checksum.SHA256)
+	}
+	if err := os.Rename(tmp, dst); err != nil {
+		_ = os.Remove(tmp)
+		return networkError(fmt.Errorf("rename %s: %w", tmp, err))
+	}
+	s.Logger.Info(fmt.Sprintf("uploader: copied %s to %s", obj.Name, dst))
+	return nil
+}
+
+// Ping checks that the directory (e.g. a mounted share) is available.
+func (s *LocalStorage) Ping() error {
+	if err := s.Ready(); err != nil {
+		return networkError(err)
+	}
+	return nil
+}
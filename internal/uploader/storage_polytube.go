@@ -0,0 +1,300 @@
+package uploader
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+	"polytube/replay/pkg/models"
+)
+
+// PolytubeStorage talks to the polytube.io API:
+//
+//	POST  /api/session/<api-id>/<session-id>                        create session
+//	PATCH /api/session/<api-id>/<session-id>                        end session
+//	GET   /api/sign/<api-id>/<session-id>/<file>/put?content_length=&sha256=&md5= signed PUT URL
+//
+// Large files use the multipart endpoints when the server offers them:
+//
+//	GET   /api/sign/<api-id>/<session-id>/<file>/multipart?content_length=&part_size=  start, returns {"upload_id","part_size"}
+//	GET   /api/sign/<api-id>/<session-id>/<file>/part?upload_id=&part_number=&content_length=  signed part URL
+//	POST  /api/sign/<api-id>/<session-id>/<file>/complete?upload_id=  body {"parts":[{"part_number","etag"}],"sha256"}
+//
+// Requests carry the "api-key" header.
+type PolytubeStorage struct {
+	EndpointURL    string
+	ApiID          string
+	ApiKey         string
+	Client         *http.Client // API calls
+	TransferClient *http.Client // file bodies
+	Logger         logger.LoggerInterface
+}
+
+// NewPolytubeStorage returns a polytube.io storage for endpoint.
+func NewPolytubeStorage(endpoint string, opts StorageOptions) *PolytubeStorage {
+	return &PolytubeStorage{
+		EndpointURL:    endpoint,
+		ApiID:          opts.ApiID,
+		ApiKey:         opts.ApiKey,
+		Client:         newAPIClient(),
+		TransferClient: newTransferClient(),
+		Logger:         opts.Logger,
+	}
+}
+
+func (s *PolytubeStorage) Ready() error {
+	if s.ApiID == "" || s.ApiKey == "" {
+		return errors.New("Api-ID or Api-Key are empty")
+	}
+	return nil
+}
+
+func (s *PolytubeStorage) sessionURL(sessionID string) string {
+	apiId := s.ApiID
+	if apiId == "" {
+		apiId = "anon"
+	}
+	return fmt.Sprintf("%s/api/session/%s/%s",
+		strings.TrimSuffix(s.EndpointURL, "/"),
+		apiId,     // maps to params.user_id
+		sessionID, // maps to params.session_id
+	)
+}
+
+func (s *PolytubeStorage) CreateSession(sessionID string, sessionInfo info.SessionInfo) error {
+	url := s.sessionURL(sessionID)
+	s.Logger.Info(fmt.Sprintf("Uploader: Creating session at %s", url))
+
+	sessionJSON, err := json.Marshal(sessionInfo)
+	if err != nil {
+		return fmt.Errorf("marshal SessionInfo: %w", err)
+	}
+	s.Logger.Info(fmt.Sprintf("Uploader: Creating session with json %s", sessionJSON))
+
+	if err := s.sendJSON(http.MethodPost, url, sessionJSON); err != nil {
+		return fmt.Errorf("create session at %s: %w", url, err)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Uploader: session created successfully at %s", url))
+	return nil
+}
+
+func (s *PolytubeStorage) EndSession(sessionID string, params PatchSessionParams) error {
+	url := s.sessionURL(sessionID)
+	s.Logger.Info(fmt.Sprintf("Uploader: Sending PATCH to %s", url))
+
+	jsonBody, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+	s.Logger.Info(fmt.Sprintf("Uploader: PATCH request body: %s", string(jsonBody)))
+
+	if err := s.sendJSON(http.MethodPatch, url, jsonBody); err != nil {
+		return fmt.Errorf("patch session at %s: %w", url, err)
+	}
+
+	s.Logger.Info(fmt.Sprintf("Uploader: PATCH succeeded for %s", url))
+	return nil
+}
+
+// sendJSON sends an authenticated JSON request and classifies failures.
+func (s *PolytubeStorage) sendJSON(method, url string, body []byte) error {
+	_, err := s.request(method, url, body)
+	return err
+}
+
+// request sends an authenticated API request and returns the response body.
+func (s *PolytubeStorage) request(method, url string, body []byte) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+	req, err := http.NewRequest(method, url, reader)
+	if err != nil {
+		return nil, fmt.Errorf("create %s request: %w", method, err)
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("api-key", s.ApiKey)
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, networkError(fmt.Errorf("%s request failed: %w", method, err))
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, statusError(resp, respBody, false)
+	}
+	return respBody, nil
+}
+
+// signURL builds /api/sign/<api-id>/<session-id>/<file>/<action>?<params>.
+func (s *PolytubeStorage) signURL(sessionID, fileName, action string, params []models.SearchParam) string {
+	return fmt.Sprintf("%s/api/sign/%s/%s/%s/%s?%s",
+		strings.TrimSuffix(s.EndpointURL, "/"),
+		s.ApiID,   // maps to params.user_id
+		sessionID, // maps to params.session_id
+		fileName,  // maps to params.file_name
+		action,
+		EncodeSearchParams(params),
+	)
+}
+
+// Sign sends a GET request to retrieve a signed URL for uploading the given file.
+// The file's checksums are sent along so the server can sign them into the URL.
+// The response is either the URL as plain text or a JSON object
+// {"url": ..., "headers": {...}} naming headers the PUT must carry.
+func (s *PolytubeStorage) Sign(sessionID string, obj Object) (Target, error) {
+	params := append([]models.SearchParam{{
+		Key:   "content_length",
+		Value: fmt.Sprintf("%d", obj.Size),
+	}}, checksumParams(obj.Checksum)...)
+	url := s.signURL(sessionID, obj.Name, "put", params)
+
+	s.Logger.Info(fmt.Sprintf("uploader: requesting signed URL for %s -> %s", obj.Name, url))
+
+	body, err := s.request(http.MethodGet, url, nil)
+	if err != nil {
+		return Target{}, err
+	}
+
+	s.Logger.Info(fmt.Sprintf("uploader: received signed URL for %s", obj.Name))
+	return parseSignResponse(body)
+}
+
+// parseSignResponse reads a plain-text signed URL or a JSON sign response.
+func parseSignResponse(body []byte) (Target, error) {
+	trimmed := bytes.TrimSpace(body)
+	if !bytes.HasPrefix(trimmed, []byte("{")) {
+		return Target{Location: string(trimmed)}, nil
+	}
+	var resp struct {
+		URL     string            `json:"url"`
+		Headers map[string]string `json:"headers"`
+	}
+	if err := json.Unmarshal(trimmed, &resp); err != nil || resp.URL == "" {
+		return Target{}, &UploadError{Kind: ErrorKindPermanent, Err: fmt.Errorf("invalid sign response: %s", trimmed)}
+	}
+	target := Target{Location: resp.URL}
+	if len(resp.Headers) > 0 {
+		target.Header = http.Header{}
+		for k, v := range resp.Headers {
+			target.Header.Set(k, v)
+		}
+	}
+	return target, nil
+}
+
+// checksumParams returns the sign request parameters for sum.
+func checksumParams(sum Checksum) []models.SearchParam {
+	var params []models.SearchParam
+	if sum.SHA256 != "" {
+		params = append(params, models.SearchParam{Key: "sha256", Value: sum.SHA256})
+	}
+	if sum.MD5 != "" {
+		params = append(params, models.SearchParam{Key: "md5", Value: sum.MD5})
+	}
+	return params
+}
+
+// Put uploads the file to the signed URL via HTTP PUT.
+func (s *PolytubeStorage) Put(target Target, obj Object) error {
+	_, err := putHTTP(s.TransferClient, target, obj)
+	return err
+}
+
+// StartMultipart asks the server for a multipart upload. A server without the
+// multipart endpoints answers 404/405/501, which means a single PUT is used.
+func (s *PolytubeStorage) StartMultipart(sessionID string, obj Object, partSize int64) (*MultipartUpload, error) {
+	params := append([]models.SearchParam{
+		{Key: "content_length", Value: fmt.Sprintf("%d", obj.Size)},
+		{Key: "part_size", Value: fmt.Sprintf("%d", partSize)},
+	}, checksumParams(obj.Checksum)...)
+	url := s.signURL(sessionID, obj.Name, "multipart", params)
+	s.Logger.Info(fmt.Sprintf("uploader: starting multipart upload for %s -> %s", obj.Name, url))
+
+	body, err := s.request(http.MethodGet, url, nil)
+	if err != nil {
+		var uerr *UploadError
+		if errors.As(err, &uerr) {
+			switch uerr.StatusCode {
+			case http.StatusNotFound, http.StatusMethodNotAllowed, http.StatusNotImplemented:
+				return nil, ErrMultipartUnsupported
+			}
+		}
+		return nil, err
+	}
+
+	var resp struct {
+		UploadID string `json:"upload_id"`
+		PartSize int64  `json:"part_size"`
+	}
+	if err := json.Unmarshal(body, &resp); err != nil || resp.UploadID == "" {
+		return nil, &UploadError{Kind: ErrorKindPermanent, Err: fmt.Errorf("invalid multipart response: %s", body)}
+	}
+	if resp.PartSize <= 0 {
+		resp.PartSize = partSize
+	}
+	return &MultipartUpload{UploadID: resp.UploadID, PartSize: resp.PartSize}, nil
+}
+
+func (s *PolytubeStorage) SignPart(sessionID string, obj Object, mp *MultipartUpload, partNumber int, part Object) (Target, error) {
+	params := append([]models.SearchParam{
+		{Key: "upload_id", Value: mp.UploadID},
+		{Key: "part_number", Value: fmt.Sprintf("%d", partNumber)},
+		{Key: "content_length", Value: fmt.Sprintf("%d", part.Size)},
+	}, checksumParams(part.Checksum)...)
+	body, err := s.request(http.MethodGet, s.signURL(sessionID, obj.Name, "part", params), nil)
+	if err != nil {
+		return Target{}, err
+	}
+	return parseSignResponse(body)
+}
+
+func (s *PolytubeStorage) PutPart(target Target, part Object) (string, error) {
+	header, err := putHTTP(s.TransferClient, target, part)
+	if err != nil {
+		return "", err
+	}
+	return header.Get("ETag"), nil
+}
+
+func (s *PolytubeStorage) CompleteMultipart(sessionID string, obj Object, mp *MultipartUpload) error {
+	type completedPart struct {
+		PartNumber int    `json:"part_number"`
+		ETag       string `json:"etag"`
+	}
+	var req struct {
+		Parts  []completedPart `json:"parts"`
+		SHA256 string          `json:"sha256,omitempty"` // of the whole file, for server-side verification
+	}
+	req.SHA256 = obj.Checksum.SHA256
+	for _, p := range sortedParts(mp.Parts) {
+		req.Parts = append(req.Parts, completedPart{PartNumber: p.Number, ETag: p.ETag})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+
+	params := []models.SearchParam{{Key: "upload_id", Value: mp.UploadID}}
+	if _, err := s.request(http.MethodPost, s.signURL(sessionID, obj.Name, "complete", params), body); err != nil {
+		return fmt.Errorf("complete multipart upload of %s: %w", obj.Name, err)
+	}
+	s.Logger.Info(fmt.Sprintf("uploader: completed multipart upload of %s (%d parts)", obj.Name, len(mp.Parts)))
+	return nil
+}
+
+// Ping checks that the endpoint answers at all.
+func (s *PolytubeStorage) Ping() error {
+	return pingHTTP(s.Client, s.EndpointURL)
+}
@@ -0,0 +1,371 @@
+package uploader
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"polytube/replay/internal/info"
+	"polytube/replay/internal/logger"
+)
+
+// s3PresignExpiry is how long a presigned request stays valid.
+const s3PresignExpiry = 15 * time.Minute
+
+// S3Storage uploads to an S3-compatible bucket (AWS S3, MinIO, Ceph, R2...)
+// using SigV4 presigned PUT requests. Objects are stored as
+// <prefix>/<session-id>/<file>; session metadata goes to session_info.json
+// and session_end.json next to them.
+//
+// Storage URL: s3://bucket/prefix?endpoint=http://minio:9000&region=us-east-1&path_style=true
+//
+// Credentials come from --api-id/--api-key, falling back to AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN.
+type S3Storage struct {
+	Bucket       string
+	Prefix       string
+	Endpoint     *url.URL // scheme and host of the S3 API
+	Region       string
+	PathStyle    bool // bucket in the path instead of the host name (MinIO)
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	Client       *http.Client
+	Logger       logger.LoggerInterface
+
+	now func() time.Time // overridable clock for signing
+}
+
+// NewS3Storage parses an s3:// storage URL.
+func NewS3Storage(u *url.URL, opts StorageOptions) (*S3Storage, error) {
+	if u.Host == "" {
+		return nil, errors.New("s3 storage URL needs a bucket: s3://bucket/prefix")
+	}
+	q := u.Query()
+
+	region := q.Get("region")
+	if region == "" {
+		region = os.Getenv("AWS_REGION")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	s := &S3Storage{
+		Bucket:       u.Host,
+		Prefix:       strings.Trim(u.Path, "/"),
+		Region:       region,
+		AccessKey:    opts.ApiID,
+		SecretKey:    opts.ApiKey,
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		Client:       newTransferClient(),
+		Logger:       opts.Logger,
+		now:          time.Now,
+	}
+	if s.AccessKey == "" {
+		s.AccessKey = os.Getenv("AWS_ACCESS_KEY_ID")
+	}
+	if s.SecretKey == "" {
+		s.SecretKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+	}
+
+	if ep := q.Get("endpoint"); ep != "" {
+		endpoint, err := url.Parse(ep)
+		if err != nil || endpoint.Host == "" {
+			return nil, fmt.Errorf("invalid s3 endpoint %q", ep)
+		}
+		s.Endpoint = endpoint
+		s.PathStyle = q.Get("path_style") != "false"
+	} else {
+		s.Endpoint = &url.URL{Scheme: "https", Host: fmt.Sprintf("s3.%s.amazonaws.com", region)}
+		s.PathStyle = q.Get("path_style") == "true"
+	}
+	return s, nil
+}
+
+func (s *S3Storage) Ready() error {
+	if s.AccessKey == "" || s.SecretKey == "" {
+		return errors.New("S3 access key or secret key are empty")
+	}
+	return nil
+}
+
+func (s *S3Storage) key(sessionID, name string) string {
+	return path.Join(s.Prefix, sessionID, name)
+}
+
+func (s *S3Storage) CreateSession(sessionID string, sessionInfo info.SessionInfo) error {
+	body, err := json.Marshal(sessionInfo)
+	if err != nil {
+		return fmt.Errorf("marshal SessionInfo: %w", err)
+	}
+	return s.putJSON(s.key(sessionID, "session_info.json"), body)
+}
+
+func (s *S3Storage) EndSession(sessionID string, params PatchSessionParams) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return fmt.Errorf("marshal JSON: %w", err)
+	}
+	return s.putJSON(s.key(sessionID, "session_end.json"), body)
+}
+
+func (s *S3Storage) putJSON(key string, body []byte) error {
+	target := Target{
+		Location: s.presign(http.MethodPut, key, nil, nil),
+		Header:   http.Header{"Content-Type": {"application/json"}},
+	}
+	s.Logger.Info(fmt.Sprintf("uploader: writing s3://%s/%s", s.Bucket, key))
+	_, err := putHTTP(s.Client, target, Object{Name: path.Base(key), Size: int64(len(body)), Body: bytes.NewReader(body)})
+	return err
+}
+
+// Sign presigns a PUT for the object locally; no round trip is needed. The
+// SHA-256 is signed into the request as x-amz-checksum-sha256 so S3 rejects
+// content that does not match it.
+func (s *S3Storage) Sign(sessionID string, obj Object) (Target, error) {
+	var header http.Header
+	if sha := obj.Checksum.SHA256Base64(); sha != "" {
+		header = http.Header{"X-Amz-Checksum-Sha256": {sha}}
+	}
+	return Target{Location: s.presign(http.MethodPut, s.key(sessionID, obj.Name), nil, header), Header: header}, nil
+}
+
+func (s *S3Storage) Put(target Target, obj Object) error {
+	_, err := putHTTP(s.Client, target, obj)
+	return err
+}
+
+// StartMultipart initiates an S3 multipart upload (POST ?uploads).
+func (s *S3Storage) StartMultipart(sessionID string, obj Object, partSize int64) (*MultipartUpload, error) {
+	key := s.key(sessionID, obj.Name)
+	body, err := s.send(http.MethodPost, s.presign(http.MethodPost, key, url.Values{"uploads": {""}}, nil), nil)
+	if err != nil {
+		return nil, fmt.Errorf("initiate multipart upload of %s: %w", key, err)
+	}
+	var result struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := xml.Unmarshal(body, &result); err != nil || result.UploadID == "" {
+		return nil, &UploadError{Kind: ErrorKindPermanent, Err: fmt.Errorf("invalid InitiateMultipartUpload response: %s", body)}
+	}
+	s.Logger.Info(fmt.Sprintf("uploader: started multipart upload of s3://%s/%s", s.Bucket, key))
+	return &MultipartUpload{UploadID: result.UploadID, PartSize: partSize}, nil
+}
+
+// SignPart presigns an UploadPart request. Parts are verified through
+// Content-MD5 and the returned ETag: S3 rejects part checksums unless the
+// upload was started with a checksum algorithm.
+func (s *S3Storage) SignPart(sessionID string, obj Object, mp *MultipartUpload, partNumber int, part Object) (Target, error) {
+	extra := url.Values{
+		"partNumber": {fmt.Sprintf("%d", partNumber)},
+		"uploadId":   {mp.UploadID},
+	}
+	return Target{Location: s.presign(http.MethodPut, s.key(sessionID, obj.Name), extra, nil)}, nil
+}
+
+func (s *S3Storage) PutPart(target Target, part Object) (string, error) {
+	header, err := putHTTP(s.Client, target, part)
+	if err != nil {
+		return "", err
+	}
+	return header.Get("ETag"), nil
+}
+
+// CompleteMultipart assembles the parts (POST ?uploadId). S3 can answer 200
+// with an <Error> body, which is treated as a server error.
+func (s *S3Storage) CompleteMultipart(sessionID string, obj Object, mp *MultipartUpload) error {
+	type part struct {
+		PartNumber int
+		ETag       string
+	}
+	req := struct {
+		XMLName xml.Name `xml:"CompleteMultipartUpload"`
+		Parts   []part   `xml:"Part"`
+	}{}
+	for _, p := range sortedParts(mp.Parts) {
+		req.Parts = append(req.Parts, part{PartNumber: p.Number, ETag: p.ETag})
+	}
+	body, err := xml.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("marshal XML: %w", err)
+	}
+
+	key := s.key(sessionID, obj.Name)
+	resp, err := s.send(http.MethodPost, s.presign(http.MethodPost, key, url.Values{"uploadId": {mp.UploadID}}, nil), body)
+	if err != nil {
+		return fmt.Errorf("complete multipart upload of %s: %w", key, err)
+	}
+	if bytes.Contains(resp, []byte("<Error>")) {
+		return &UploadError{Kind: ErrorKindServer, Err: fmt.Errorf("complete multipart upload of %s: %s", key, resp)}
+	}
+	s.Logger.Info(fmt.Sprintf("uploader: completed multipart upload of s3://%s/%s (%d parts)", s.Bucket, key, len(mp.Parts)))
+	return nil
+}
+
+// send performs a presigned request with an optional body and returns the
+// response body.
+func (s *S3Storage) send(method, location string, body []byte) ([]byte, error) {
+	req, err := http.NewRequest(method, location, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("create %s request: %w", method, err)
+	}
+	req.ContentLength = int64(len(body))
+
+	resp, err := s.Client.Do(req)
+	if err != nil {
+		return nil, networkError(fmt.Errorf("%s request failed: %w", method, err))
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, statusError(resp, respBody, true)
+	}
+	return respBody, nil
+}
+
+// presign builds a SigV4 query-string-authenticated URL for method on key.
+// The host header and the given headers (which the request must then send
+// unchanged) are signed; the payload is UNSIGNED-PAYLOAD, so the same URL
+// works for streamed bodies.
+func (s *S3Storage) presign(method, key string, extra url.Values, header http.Header) string {
+	now := s.now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", date, s.Region)
+
+	host := s.Endpoint.Host
+	uriPath := "/" + key
+	if s.PathStyle {
+		uriPath = "/" + s.Bucket + "/" + key
+	} else {
+		host = s.Bucket + "." + host
+	}
+
+	q := url.Values{}
+	for k, vs := range extra {
+		q[k] = vs
+	}
+	q.Set("X-Amz-Algorithm", "AWS4-HMAC-SHA256")
+	q.Set("X-Amz-Credential", s.AccessKey+"/"+scope)
+	q.Set("X-Amz-Date", amzDate)
+	q.Set("X-Amz-Expires", fmt.Sprintf("%d", int(s3PresignExpiry.Seconds())))
+	canonicalHeaders, signedHeaders := awsCanonicalHeaders(host, header)
+	q.Set("X-Amz-SignedHeaders", signedHeaders)
+	if s.SessionToken != "" {
+		q.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	canonicalURI := awsEscapePath(uriPath)
+	canonicalQuery := awsCanonicalQuery(q)
+	canonicalRequest := strings.Join([]string{
+		method,
+		canonicalURI,
+		canonicalQuery,
+		canonicalHeaders,
+		signedHeaders,
+		"UNSIGNED-PAYLOAD",
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256",
+		amzDate,
+		scope,
+		hex.EncodeToString(hash[:]),
+	}, "\n")
+
+	signingKey := hmacSHA256([]byte("AWS4"+s.SecretKey), date)
+	signingKey = hmacSHA256(signingKey, s.Region)
+	signingKey = hmacSHA256(signingKey, "s3")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+
+	return fmt.Sprintf("%s://%s%s?%s&X-Amz-Signature=%s", s.Endpoint.Scheme, host, canonicalURI, canonicalQuery, signature)
+}
+
+// awsCanonicalHeaders returns the canonical header block and the signed header
+// list for host plus header, sorted by lowercase name.
+func awsCanonicalHeaders(host string, header http.Header) (string, string) {
+	values := map[string]string{"host": host}
+	for k, vs := range header {
+		values[strings.ToLower(k)] = strings.TrimSpace(strings.Join(vs, ","))
+	}
+	names := make([]string, 0, len(values))
+	for k := range values {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	for _, k := range names {
+		b.WriteString(k + ":" + values[k] + "\n")
+	}
+	return b.String(), strings.Join(names, ";")
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// awsEscape percent-encodes everything except the SigV4 unreserved characters.
+func awsEscape(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// awsEscapePath escapes each path segment, keeping the slashes.
+func awsEscapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, seg := range segments {
+		segments[i] = awsEscape(seg)
+	}
+	return strings.Join(segments, "/")
+}
+
+// awsCanonicalQuery encodes q sorted by key, as required by SigV4.
+func awsCanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var parts []string
+	for _, k := range keys {
+		vals := append([]string(nil), q[k]...)
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, awsEscape(k)+"="+awsEscape(v))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// Ping checks that the S3 endpoint answers at all.
+func (s *S3Storage) Ping() error {
+	return pingHTTP(s.Client, s.Endpoint.Scheme+"://"+s.Endpoint.Host)
+}
@@ -1,80 +1,153 @@
 // Package uploader manages uploading of generated files (HLS segments, manifests,
-// event logs, internal logs) to a remote server.
+// event logs, internal logs) to a Storage backend (polytube.io, an S3-compatible
+// bucket, a local or mounted folder, or a plain HTTP PUT server; see NewStorage).
 //
 // It provides three main entrypoints:
-//   - UploadTS()          : periodically upload new .ts segment files.
+//   - UploadTS()          : periodically upload new .ts segments and finished events_###.parquet files.
 //   - UploadRemaining()   : at shutdown, upload any remaining files except internal log.
 //   - UploadLogFile()     : upload the internal log file last.
 //
-// Each upload is executed concurrently. Uploaded files are tracked in-memory only;
-// no on-disk persistence is used.
-//
-// HTTP headers:
-//
-//	Api-Id: <ApiID>
-//	Api-Key: <ApiKey>
-//	Content-Type: application/octet-stream
+// Uploads run in a bounded worker pool (MaxConcurrent) and share one
+// BandwidthLimiter so recording never saturates the player's connection.
+// Each upload is retried with exponential backoff according to Retry (see
+// RetryPolicy); expired signed URLs are re-signed. Uploaded files are tracked
+// in-memory and, when a Journal is attached, persisted to upload_journal.json
+// inside DirPath so an interrupted session can be resumed on the next launch
+// (see FindUnfinishedSessions).
 package uploader
 
 import (
-	"bytes"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"io"
-	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
-	"strings"
 	"sync"
 	"time"
 
 	"polytube/replay/internal/info"
 	"polytube/replay/internal/logger"
 	"polytube/replay/pkg/models"
-	"polytube/replay/utils"
 )
 
 // Uploader manages background and shutdown uploads.
 type Uploader struct {
 	DirPath             string          // directory to scan
-	EndpointURL         string          // base URL
-	ApiID               string          // API ID header
-	ApiKey              string          // API Key header
+	Storage             Storage         // upload destination
 	SessionID           string          // Session ID
 	UploadedFiles       map[string]bool // in-memory record of uploaded paths
-	Client              *http.Client    // HTTP client (lazy-initialized)
 	Mu                  sync.Mutex      // guards UploadedFiles
 	WG                  sync.WaitGroup  // tracks concurrent uploads
 	Logger              *logger.Logger  // internal logger
 	InternalLogFilePath string
 	SessionInfo         info.SessionInfo
+	Journal             *Journal          // optional on-disk upload journal
+	Retry               RetryPolicy       // zero value uses DefaultRetryPolicy
+	MaxConcurrent       int               // max simultaneous uploads; zero uses DefaultMaxConcurrent
+	Bandwidth           *BandwidthLimiter // shared upload rate limit; nil means unlimited
+	PartSize            int64             // multipart part size (and threshold); zero uses DefaultPartSize
+	ContentMD5          bool              // also compute MD5 and send Content-MD5 (SHA-256 is always sent)
+
+	// OnFileDone, if set, is called from the upload workers after every file
+	// finishes (uploaded or failed), e.g. to print progress.
+	OnFileDone func(path string, result FileResult)
+
+	inFlight  map[string]bool       // paths currently being uploaded; guarded by Mu
+	offline   bool                  // storage unreachable; guarded by Mu
+	lastProbe time.Time             // last connectivity probe while offline; guarded by Mu
+	results   map[string]FileResult // final per-file outcome; guarded by Mu
+	slots     chan struct{}         // worker pool semaphore
+	slotsOnce sync.Once
 }
 
-type PatchSessionParams struct {
-	Ends bool `json:"ends" db:"ends"`
+// FileResult is the final outcome of the most recent upload of a file.
+type FileResult struct {
+	Status   FileStatus
+	Attempts int
+	Err      error
 }
 
 func (u *Uploader) StartSessionInfo() {
-	if url, err := u.CreateSession(); err != nil {
-		u.Logger.Error(fmt.Errorf("uploader: failed to create session at %s: %w", url, err).Error())
+	if u.Journal != nil && u.Journal.IsSessionCreated() {
+		u.Logger.Info("uploader: session already created (journal), skipping")
+		return
+	}
+	if !u.Online() {
+		u.Logger.Warn("uploader: offline; session will be created when connectivity returns")
 		return
 	}
+	if _, err := u.retry("create session", func() error {
+		return u.Storage.CreateSession(u.SessionID, u.SessionInfo)
+	}); err != nil {
+		u.Logger.Error(fmt.Errorf("uploader: failed to create session: %w", err).Error())
+		return
+	}
+	if u.Journal != nil {
+		if err := u.Journal.MarkSessionCreated(); err != nil {
+			u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal: %v", err))
+		}
+	}
 }
 
 func (u *Uploader) EndSessionInfo() {
+	if u.Journal != nil && u.Journal.IsSessionEnded() {
+		u.Logger.Info("uploader: session already ended (journal), skipping")
+		return
+	}
+	if !u.Online() {
+		u.Logger.Warn("uploader: offline; session will be ended on a later run")
+		return
+	}
 	params := PatchSessionParams{
 		Ends: true,
 	}
-	if url, err := u.PatchSessionEnd(params); err != nil {
-		u.Logger.Error(fmt.Errorf("uploader: failed to patch session at %s: %w", url, err).Error())
+	if u.Journal != nil {
+		params.GameExit = u.Journal.GameExit()
+	}
+	if _, err := u.retry("end session", func() error {
+		return u.Storage.EndSession(u.SessionID, params)
+	}); err != nil {
+		u.Logger.Error(fmt.Errorf("uploader: failed to end session: %w", err).Error())
 		return
 	}
+	if u.Journal != nil {
+		if err := u.Journal.MarkSessionEnded(); err != nil {
+			u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal: %v", err))
+		}
+	}
 }
 
-// UploadTS scans DirPath for .ts files and uploads any that aren't yet uploaded.
+// FinishSession marks the journal completed if every file in DirPath was uploaded
+// in its current state. Call it after the internal log upload has finished.
+func (u *Uploader) FinishSession() bool {
+	if u.Journal == nil {
+		return false
+	}
+	done := true
+	filepath.WalkDir(u.DirPath, func(path string, d os.DirEntry, err error) error {
+		if err != nil || d.IsDir() || isLocalFile(path) {
+			return nil
+		}
+		if !u.isUploaded(path) {
+			done = false
+		}
+		return nil
+	})
+	if !done {
+		return false
+	}
+	if err := u.Journal.MarkCompleted(); err != nil {
+		u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal: %v", err))
+		return false
+	}
+	return true
+}
+
+// UploadTS scans DirPath for segment files (.ts video segments and rolled
+// events_###.parquet files) and uploads any that aren't yet uploaded.
 // It skips files still being written by checking last-modified timestamps
-// (simple heuristic: older than ~2s).
+// (simple heuristic: older than ~2s). Event files only get their final name
+// once complete, so a matching event file is never partial.
 func (u *Uploader) UploadTS() {
 
 	if u.DirPath == "" {
@@ -83,10 +156,21 @@ func (u *Uploader) UploadTS() {
 	}
 	// u.Logger.Info("uploader: scanning for .ts files")
 
-	if u.ApiID == "" || u.ApiKey == "" {
-		u.Logger.Error(fmt.Errorf("failed to upload: Api-ID or Api-Key are empty! Api-ID: %s, Api-Key: %s", u.ApiID, u.ApiKey).Error())
+	if err := u.Storage.Ready(); err != nil {
+		u.Logger.Error(fmt.Errorf("failed to upload: %w", err).Error())
+		return
+	}
+	// While offline, segments stay spooled on disk until a probe succeeds.
+	if !u.Online() {
 		return
 	}
+	// The session could not be created if the recording started offline.
+	if u.Journal != nil && !u.Journal.IsSessionCreated() {
+		u.StartSessionInfo()
+		if !u.Journal.IsSessionCreated() {
+			return
+		}
+	}
 	filepath.WalkDir(u.DirPath, func(path string, d os.DirEntry, err error) error {
 		if err != nil {
 			u.Logger.Warn(fmt.Sprintf("uploader: walk error: %v", err))
@@ -95,19 +179,19 @@ func (u *Uploader) UploadTS() {
 		if d.IsDir() {
 			return nil
 		}
-		if filepath.Ext(path) != ".ts" {
+		if !isSegmentFile(path) {
 			return nil
 		}
-		if u.isUploaded(path) {
+		if u.isUploaded(path) || u.failedPermanently(path) {
 			return nil
 		}
 		if !isStable(path) {
 			// file still being written; skip for now
 			return nil
 		}
-		u.Logger.Info(fmt.Sprintf("uploader: scheduling TS upload %s", path))
-		u.WG.Add(1)
-		go u.uploadFile(path)
+		if u.schedule(path) {
+			u.Logger.Info(fmt.Sprintf("uploader: scheduling TS upload %s", path))
+		}
 		return nil
 	})
 }
@@ -117,8 +201,12 @@ func (u *Uploader) UploadTS() {
 func (u *Uploader) UploadRemaining() {
 
 	u.Logger.Info("uploader: uploading remaining files (excluding internal log)")
-	if u.ApiID == "" || u.ApiKey == "" {
-		u.Logger.Error(fmt.Errorf("failed to upload: Api-ID or Api-Key are empty! Api-ID: %s, Api-Key: %s", u.ApiID, u.ApiKey).Error())
+	if err := u.Storage.Ready(); err != nil {
+		u.Logger.Error(fmt.Errorf("failed to upload: %w", err).Error())
+		return
+	}
+	if !u.Online() {
+		u.Logger.Warn("uploader: offline; remaining files stay spooled for a later run")
 		return
 	}
 	filepath.WalkDir(u.DirPath, func(path string, d os.DirEntry, err error) error {
@@ -126,7 +214,7 @@ func (u *Uploader) UploadRemaining() {
 			u.Logger.Warn(fmt.Sprintf("uploader: walk error: %v", err))
 			return nil
 		}
-		if d.IsDir() {
+		if d.IsDir() || isLocalFile(path) {
 			return nil
 		}
 
@@ -140,9 +228,9 @@ func (u *Uploader) UploadRemaining() {
 			return nil
 		}
 
-		u.Logger.Info(fmt.Sprintf("uploader: scheduling upload %s", path))
-		u.WG.Add(1)
-		go u.uploadFile(path)
+		if u.schedule(path) {
+			u.Logger.Info(fmt.Sprintf("uploader: scheduling upload %s", path))
+		}
 		return nil
 	})
 }
@@ -155,217 +243,254 @@ func (u *Uploader) UploadLogFile() {
 	}
 	path := u.InternalLogFilePath
 	u.Logger.Info(fmt.Sprintf("uploader: scheduling internal log upload %s", path))
-	if u.ApiID == "" || u.ApiKey == "" {
-		u.Logger.Error(fmt.Errorf("failed to upload: Api-ID or Api-Key are empty! Api-ID: %s, Api-Key: %s", u.ApiID, u.ApiKey).Error())
+	if err := u.Storage.Ready(); err != nil {
+		u.Logger.Error(fmt.Errorf("failed to upload: %w", err).Error())
+		return
+	}
+	if !u.Online() {
 		return
 	}
+	u.schedule(path)
+}
+
+// schedule queues an upload of path on the worker pool unless one is already
+// queued or running. It reports whether a new upload was queued.
+func (u *Uploader) schedule(path string) bool {
+	u.Mu.Lock()
+	if u.inFlight == nil {
+		u.inFlight = make(map[string]bool)
+	}
+	if u.inFlight[path] {
+		u.Mu.Unlock()
+		return false
+	}
+	u.inFlight[path] = true
+	u.Mu.Unlock()
+
 	u.WG.Add(1)
-	go u.uploadFile(path)
+	go func() {
+		defer func() {
+			u.Mu.Lock()
+			delete(u.inFlight, path)
+			u.Mu.Unlock()
+		}()
+		slots := u.workerSlots()
+		slots <- struct{}{}
+		defer func() { <-slots }()
+		u.uploadFile(path)
+	}()
+	return true
 }
 
-// uploadFile coordinates getting the signed URL and uploading the file.
+// uploadFile uploads one file, in parts when it is larger than the part size and
+// the storage supports it, and records the outcome in the journal.
 func (u *Uploader) uploadFile(path string) {
 	defer u.WG.Done()
 
 	fileName := filepath.Base(path)
 
-	signedURL, err := u.getSignedURL(path)
+	before, err := os.Stat(path)
 	if err != nil {
-		u.Logger.Error(fmt.Errorf("uploader: failed to get signed URL for %s: %w", fileName, err).Error())
+		u.Logger.Error(fmt.Errorf("uploader: failed to stat %s: %w", fileName, err).Error())
+		u.recordFailure(path, nil, 0, fmt.Errorf("stat file: %w", err))
 		return
 	}
 
-	if err := u.putFileToSignedURL(signedURL, path); err != nil {
-		u.Logger.Error(fmt.Errorf("uploader: failed to upload %s: %w", fileName, err).Error())
+	sum, err := u.checksum(path, before)
+	if err != nil {
+		u.Logger.Error(fmt.Errorf("uploader: failed to checksum %s: %w", fileName, err).Error())
+		u.recordFailure(path, before, 0, fmt.Errorf("checksum file: %w", err))
 		return
 	}
 
-	u.markUploaded(path)
-}
-
-func (u *Uploader) CreateSession() (string, error) {
-
-	apiId := u.ApiID
-	if apiId == "" {
-		apiId = "anon"
+	var attempts int
+	multipart := false
+	if ms, ok := u.Storage.(MultipartStorage); ok && before.Size() > u.partSize() {
+		multipart = true
+		attempts, err = u.uploadMultipart(ms, path, before, sum)
+		if errors.Is(err, ErrMultipartUnsupported) {
+			u.Logger.Info(fmt.Sprintf("uploader: storage has no multipart support; sending %s in one request", fileName))
+			multipart = false
+		}
 	}
-
-	url := fmt.Sprintf("%s/api/session/%s/%s",
-		strings.TrimSuffix(u.EndpointURL, "/"),
-		apiId,       // maps to params.user_id
-		u.SessionID, // maps to params.session_id
-	)
-
-	u.Logger.Info(fmt.Sprintf("Uploader: Creating session at %s", url))
-
-	// u.SessionInfo to json
-	sessionJSON, err := json.Marshal(u.SessionInfo)
-	if err != nil {
-		return url, fmt.Errorf("marshal SessionInfo: %w", err)
+	if !multipart {
+		attempts, err = u.uploadSingle(path, before, sum)
 	}
-	u.Logger.Info(fmt.Sprintf("Uploader: Creating session with json %s", sessionJSON))
-
-	req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(sessionJSON))
 	if err != nil {
-		return url, fmt.Errorf("create POST request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", u.ApiKey)
-
-	client := u.client()
-	resp, err := client.Do(req)
-	if err != nil {
-		return url, fmt.Errorf("POST request failed: %w", err)
+		u.Logger.Error(fmt.Errorf("uploader: giving up on %s after %d attempt(s): %w", fileName, attempts, err).Error())
+		u.recordFailure(path, before, attempts, err)
+		return
 	}
-	defer resp.Body.Close()
+	u.recordStatus(path, FileStatusUploaded, before)
+	status := FileStatusUploaded
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return url, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+	// Confirm only if the file did not change while it was being uploaded;
+	// otherwise the journal keeps the old size/mod time and it is uploaded again.
+	if after, err := os.Stat(path); err == nil && after.Size() == before.Size() && after.ModTime().Equal(before.ModTime()) {
+		u.recordStatus(path, FileStatusConfirmed, before)
+		status = FileStatusConfirmed
 	}
 
-	u.Logger.Info(fmt.Sprintf("Uploader: session created successfully at %s", url))
-	return url, nil
+	u.setResult(path, FileResult{Status: status, Attempts: attempts})
+	u.markUploaded(path)
 }
 
-func (u *Uploader) PatchSessionEnd(params PatchSessionParams) (string, error) {
-	apiId := u.ApiID
-	if apiId == "" {
-		apiId = "anon"
-	}
-
-	url := fmt.Sprintf("%s/api/session/%s/%s",
-		strings.TrimSuffix(u.EndpointURL, "/"),
-		apiId,
-		u.SessionID,
-	)
-
-	u.Logger.Info(fmt.Sprintf("Uploader: Sending PATCH to %s (params=%+v)", url, params))
-
-	jsonBody, err := json.Marshal(params)
-	if err != nil {
-		u.Logger.Error(fmt.Sprintf("Uploader: failed to marshal JSON body: %v", err))
-		return url, fmt.Errorf("marshal JSON: %w", err)
+// recordFailure records a failed upload of path in the journal and the
+// results. fi is the file as it was attempted; nil if it could not be read.
+func (u *Uploader) recordFailure(path string, fi os.FileInfo, attempts int, err error) {
+	if u.Journal != nil {
+		var size int64
+		var modTime time.Time
+		if fi != nil {
+			size, modTime = fi.Size(), fi.ModTime()
+		}
+		if jerr := u.Journal.SetFailed(filepath.Base(path), size, modTime, attempts, err); jerr != nil {
+			u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal for %s: %v", filepath.Base(path), jerr))
+		}
 	}
-	u.Logger.Info(fmt.Sprintf("Uploader: PATCH request body: %s", string(jsonBody)))
+	u.setResult(path, FileResult{Status: FileStatusFailed, Attempts: attempts, Err: err})
+}
 
-	req, err := http.NewRequest(http.MethodPatch, url, bytes.NewReader(jsonBody))
-	if err != nil {
-		u.Logger.Error(fmt.Sprintf("Uploader: failed to create PATCH request: %v", err))
-		return url, fmt.Errorf("create PATCH request: %w", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("api-key", u.ApiKey)
+// uploadSingle sends the whole file in one request per attempt, re-signing
+// the destination when the signed URL has expired and sending the file again
+// when the server reports a checksum mismatch.
+func (u *Uploader) uploadSingle(path string, before os.FileInfo, sum Checksum) (int, error) {
+	fileName := filepath.Base(path)
+	var target *Target
+	return u.retry("upload "+fileName, func() error {
+		obj := Object{Name: fileName, Path: path, Size: before.Size(), Checksum: sum}
+		if target == nil {
+			t, err := u.Storage.Sign(u.SessionID, obj)
+			if err != nil {
+				return err
+			}
+			target = &t
+			u.recordStatus(path, FileStatusSigned, before)
+		}
+		if err := u.putFile(*target, obj); err != nil {
+			if classify(err).Kind == ErrorKindExpiredURL {
+				u.Logger.Info(fmt.Sprintf("uploader: signed URL for %s rejected; re-signing", fileName))
+				target = nil
+			}
+			return err
+		}
+		u.Logger.Info(fmt.Sprintf("uploader: %s uploaded successfully!", fileName))
+		return nil
+	})
+}
 
-	client := u.client()
-	resp, err := client.Do(req)
-	if err != nil {
-		u.Logger.Error(fmt.Sprintf("Uploader: PATCH request failed: %v", err))
-		return url, fmt.Errorf("PATCH request failed: %w", err)
+// Results returns the final outcome of every file uploaded (or attempted) so far, keyed by path.
+func (u *Uploader) Results() map[string]FileResult {
+	u.Mu.Lock()
+	defer u.Mu.Unlock()
+	out := make(map[string]FileResult, len(u.results))
+	for k, v := range u.results {
+		out[k] = v
 	}
-	defer resp.Body.Close()
+	return out
+}
 
-	respBody, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		u.Logger.Error(fmt.Sprintf("Uploader: server returned %d: %s", resp.StatusCode, string(respBody)))
-		return url, fmt.Errorf("server returned %d: %s", resp.StatusCode, string(respBody))
+// LogSummary writes one line per failed file plus a total to the internal logger.
+func (u *Uploader) LogSummary() {
+	results := u.Results()
+	failed := 0
+	for path, r := range results {
+		if r.Status == FileStatusFailed {
+			failed++
+			u.Logger.Error(fmt.Sprintf("uploader: %s failed after %d attempt(s): %v", filepath.Base(path), r.Attempts, r.Err))
+		}
 	}
-
-	u.Logger.Info(fmt.Sprintf("Uploader: PATCH succeeded (%d) for %s", resp.StatusCode, url))
-	return url, nil
+	u.Logger.Info(fmt.Sprintf("uploader: %d file(s) attempted, %d failed", len(results), failed))
 }
 
-// getSignedURL sends a GET request to retrieve a signed URL for uploading the given file.
-func (u *Uploader) getSignedURL(path string) (string, error) {
-	fileName := filepath.Base(path)
-	contentLength, err := utils.GetFileContentLength(path)
+// putFile opens the file and sends one attempt of it to target through the
+// shared bandwidth limiter.
+func (u *Uploader) putFile(target Target, obj Object) error {
+	file, err := os.Open(obj.Path)
 	if err != nil {
-		return "", err
+		return fmt.Errorf("open file: %w", err)
 	}
+	defer file.Close()
 
-	params := []models.SearchParam{{
-		Key:   "content_length",
-		Value: fmt.Sprintf("%d", contentLength),
-	}}
+	obj.Body = u.Bandwidth.Reader(file)
+	return u.Storage.Put(target, obj)
+}
 
-	url := fmt.Sprintf("%s/api/sign/%s/%s/%s/%s?%s",
-		strings.TrimSuffix(u.EndpointURL, "/"),
-		u.ApiID,     // maps to params.user_id
-		u.SessionID, // maps to params.session_id
-		fileName,    // maps to params.file_name
-		"put",
-		EncodeSearchParams(params),
-	)
+// --- helpers ---
 
-	u.Logger.Info(fmt.Sprintf("uploader: requesting signed URL for %s -> %s", fileName, url))
+// workerSlots lazily creates the worker pool semaphore.
+func (u *Uploader) workerSlots() chan struct{} {
+	u.slotsOnce.Do(func() {
+		n := u.MaxConcurrent
+		if n <= 0 {
+			n = DefaultMaxConcurrent
+		}
+		u.slots = make(chan struct{}, n)
+	})
+	return u.slots
+}
 
-	req, err := http.NewRequest(http.MethodGet, url, nil)
-	if err != nil {
-		return "", fmt.Errorf("create GET request: %w", err)
+func (u *Uploader) isUploaded(path string) bool {
+	u.Mu.Lock()
+	uploaded := u.UploadedFiles[path]
+	u.Mu.Unlock()
+	if uploaded || u.Journal == nil {
+		return uploaded
 	}
-	req.Header.Set("api-key", u.ApiKey)
-
-	client := u.client()
-	resp, err := client.Do(req)
+	fi, err := os.Stat(path)
 	if err != nil {
-		return "", fmt.Errorf("GET request failed: %w", err)
-	}
-	defer resp.Body.Close()
-
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return "", fmt.Errorf("server returned %d: %s", resp.StatusCode, string(body))
+		return false
 	}
-
-	signedURL := strings.TrimSpace(string(body))
-	u.Logger.Info(fmt.Sprintf("uploader: received signed URL for %s: %s", fileName, signedURL))
-	return signedURL, nil
+	return u.Journal.IsDone(filepath.Base(path), fi.Size(), fi.ModTime())
 }
 
-// putFileToSignedURL uploads the file to the signed URL via HTTP PUT.
-func (u *Uploader) putFileToSignedURL(signedURL string, path string) error {
-	fileName := filepath.Base(path)
-	file, err := os.Open(path)
-	if err != nil {
-		return fmt.Errorf("open file: %w", err)
+// recordStatus writes the file's progress to the journal, if one is attached.
+func (u *Uploader) recordStatus(path string, status FileStatus, fi os.FileInfo) {
+	if u.Journal == nil {
+		return
 	}
-	defer file.Close()
-
-	req, err := http.NewRequest(http.MethodPut, signedURL, file)
-	if err != nil {
-		return fmt.Errorf("create PUT request: %w", err)
+	if err := u.Journal.SetStatus(filepath.Base(path), status, fi.Size(), fi.ModTime()); err != nil {
+		u.Logger.Warn(fmt.Sprintf("uploader: failed to update journal for %s: %v", filepath.Base(path), err))
 	}
-	req.Header.Set("Content-Type", "application/octet-stream")
+}
 
-	client := u.client()
-	resp, err := client.Do(req)
-	if err != nil {
-		return fmt.Errorf("PUT request failed: %w", err)
-	}
-	defer resp.Body.Close()
+// isLocalFile reports whether path is the upload journal or a temp file (a
+// manifest or event file still being written), which are never uploaded.
+func isLocalFile(path string) bool {
+	name := filepath.Base(path)
+	return name == JournalFileName || filepath.Ext(name) == ".tmp"
+}
 
-	body, _ := io.ReadAll(resp.Body)
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("upload failed (status %d): %s", resp.StatusCode, string(body))
+// isSegmentFile reports whether path is a finished segment the poller uploads
+// while recording: an HLS segment or a rolled event file.
+func isSegmentFile(path string) bool {
+	name := filepath.Base(path)
+	if filepath.Ext(name) == ".ts" {
+		return true
 	}
-
-	u.Logger.Info(fmt.Sprintf("uploader: %s uploaded successfully! (status %d)", fileName, resp.StatusCode))
-	return nil
+	ok, _ := filepath.Match("events_[0-9]*.parquet", name)
+	return ok
 }
 
-// --- helpers ---
-
-func (u *Uploader) client() *http.Client {
-	if u.Client == nil {
-		u.Client = &http.Client{Timeout: 30 * time.Second}
+func (u *Uploader) setResult(path string, r FileResult) {
+	u.Mu.Lock()
+	if u.results == nil {
+		u.results = make(map[string]FileResult)
+	}
+	u.results[path] = r
+	u.Mu.Unlock()
+	if u.OnFileDone != nil {
+		u.OnFileDone(path, r)
 	}
-	return u.Client
 }
 
-func (u *Uploader) isUploaded(path string) bool {
+// failedPermanently reports whether the last upload of path failed with a
+// non-retryable error. The poller leaves such files to UploadRemaining.
+func (u *Uploader) failedPermanently(path string) bool {
 	u.Mu.Lock()
 	defer u.Mu.Unlock()
-	return u.UploadedFiles[path]
+	r, ok := u.results[path]
+	return ok && r.Status == FileStatusFailed && !classify(r.Err).Retryable()
 }
 
 func (u *Uploader) markUploaded(path string) {
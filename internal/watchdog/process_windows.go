@@ -0,0 +1,65 @@
+//go:build windows
+
+package watchdog
+
+import (
+	"fmt"
+
+	"github.com/gonutz/w32/v3"
+	"golang.org/x/sys/windows"
+
+	"polytube/replay/internal/window"
+)
+
+var isHungAppWindow = windows.NewLazySystemDLL("user32.dll").NewProc("IsHungAppWindow")
+
+// OpenProcess opens the process owning w, the window the recorder captures.
+func OpenProcess(w window.Window) (Process, error) {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION|windows.SYNCHRONIZE, false, uint32(w.PID))
+	if err != nil {
+		return nil, fmt.Errorf("open process %d: %w", w.PID, err)
+	}
+	p := &windowProcess{hwnd: w.Handle, pid: w.PID, handle: handle, name: window.ExeName(w.PID)}
+	if p.name == "" {
+		p.name = fmt.Sprintf("pid %d", w.PID)
+	}
+	return p, nil
+}
+
+// windowProcess is the process owning a window.
+type windowProcess struct {
+	hwnd   w32.HWND
+	pid    int
+	name   string
+	handle windows.Handle
+}
+
+func (p *windowProcess) PID() int     { return p.pid }
+func (p *windowProcess) Name() string { return p.name }
+
+func (p *windowProcess) Exited() (uint32, bool, error) {
+	event, err := windows.WaitForSingleObject(p.handle, 0)
+	if err != nil {
+		return 0, false, err
+	}
+	if event != windows.WAIT_OBJECT_0 {
+		return 0, false, nil
+	}
+	var code uint32
+	if err := windows.GetExitCodeProcess(p.handle, &code); err != nil {
+		return 0, true, err
+	}
+	return code, true, nil
+}
+
+func (p *windowProcess) Responding() bool {
+	if !windows.IsWindow(windows.HWND(p.hwnd)) {
+		return true
+	}
+	hung, _, _ := isHungAppWindow.Call(uintptr(p.hwnd))
+	return hung == 0
+}
+
+func (p *windowProcess) Close() error {
+	return windows.CloseHandle(p.handle)
+}
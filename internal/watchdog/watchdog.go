@@ -0,0 +1,336 @@
+// Package watchdog supervises the process of the recorded game, so a session
+// tells a normal quit from a crash or a hang. FFmpeg only notices that the
+// window is gone; the watchdog opens the process owning the window (see
+// OpenProcess) and watches its exit code, whether its window still answers
+// window messages, and crash dumps written while it ran.
+//
+// It logs GAME_HUNG when the window stops responding, and one GAME_CRASHED or
+// GAME_EXITED event with the exit code when the process ends. Stop returns the
+// outcome for the session end update.
+//
+// The supervision logic only talks to a Process, so it runs against a fake
+// process on any OS; the Windows implementation is in process_windows.go.
+package watchdog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"polytube/replay/internal/events"
+	"polytube/replay/internal/logger"
+	"polytube/replay/pkg/models"
+	"polytube/replay/utils"
+)
+
+// Game statuses, from least to most severe.
+const (
+	StatusExited  = "exited"  // the game quit, with any exit code that is not a crash
+	StatusHung    = "hung"    // the window stopped responding and did not recover before the end
+	StatusCrashed = "crashed" // a crash exit code, or a crash dump was written
+)
+
+const (
+	// DefaultHangAfter is how long the window must not respond before the
+	// game counts as hung.
+	DefaultHangAfter = 10 * time.Second
+	// DefaultInterval is how often the process is checked.
+	DefaultInterval = time.Second
+)
+
+// Process is the game process being supervised.
+type Process interface {
+	PID() int
+	// Name returns the executable file name, e.g. game.exe.
+	Name() string
+	// Exited reports whether the process has exited and, if so, its exit code.
+	// An error with exited true means the exit code could not be read.
+	Exited() (code uint32, exited bool, err error)
+	// Responding reports whether the window of the process answers window
+	// messages. A window that is gone counts as responding.
+	Responding() bool
+	// Close releases the process handle.
+	Close() error
+}
+
+// Exit describes how the game ended.
+type Exit struct {
+	Status    string  // StatusExited, StatusHung or StatusCrashed
+	Code      *uint32 // exit code; nil if the process did not exit or it is unknown
+	CrashDump string  // path of a crash dump written while it ran
+}
+
+// Watchdog supervises the game process found by Find.
+type Watchdog struct {
+	// Find resolves the game process. It is retried every Interval until it
+	// succeeds, since the window may appear after the recording started.
+	Find        func() (Process, error)
+	EventLogger events.EventLoggerInterface
+	Logger      logger.LoggerInterface
+	HangAfter   time.Duration // zero uses DefaultHangAfter; negative disables hang detection
+	Interval    time.Duration // zero uses DefaultInterval
+	DumpDirs    []string      // folders watched for new *.dmp files, e.g. %LOCALAPPDATA%\CrashDumps
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu        sync.Mutex
+	proc      Process
+	started   time.Time // when supervision started; dumps older than this are ignored
+	hungSince time.Time // zero while responding
+	hung      bool      // GAME_HUNG logged for the current hang
+	exit      *Exit
+}
+
+// Start supervises the game in the background until it exits or Stop is called.
+func (w *Watchdog) Start(ctx context.Context) {
+	if w.HangAfter == 0 {
+		w.HangAfter = DefaultHangAfter
+	}
+	if w.Interval <= 0 {
+		w.Interval = DefaultInterval
+	}
+	ctx, w.cancel = context.WithCancel(ctx)
+	w.done = make(chan struct{})
+	w.started = time.Now()
+	go w.run(ctx)
+}
+
+// Stop waits up to grace for the game to exit, as it usually does right after
+// its window closed, then stops supervising it. It returns how the game ended,
+// or nil if it is still running normally or was never found.
+func (w *Watchdog) Stop(grace time.Duration) *Exit {
+	if w.done == nil {
+		return nil
+	}
+	select {
+	case <-w.done:
+	case <-time.After(grace):
+		w.cancel()
+		<-w.done
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.proc != nil {
+		w.proc.Close()
+	}
+	if w.exit != nil {
+		return w.exit
+	}
+	// Still running: only a hang or a crash dump (e.g. a crash reporter
+	// holding the process) says something went wrong.
+	if w.proc != nil {
+		if dump := w.findDump(); dump != "" {
+			return &Exit{Status: StatusCrashed, CrashDump: dump}
+		}
+		if w.hung {
+			return &Exit{Status: StatusHung}
+		}
+	}
+	return nil
+}
+
+func (w *Watchdog) run(ctx context.Context) {
+	defer close(w.done)
+	defer w.cancel()
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+	for {
+		if w.check() {
+			return
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check looks at the process once and reports whether supervision is over.
+func (w *Watchdog) check() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.proc == nil {
+		proc, err := w.Find()
+		if err != nil {
+			return false
+		}
+		w.proc = proc
+		w.Logger.Info(fmt.Sprintf("Watchdog: supervising %s (pid %d)", proc.Name(), proc.PID()))
+	}
+
+	code, exited, err := w.proc.Exited()
+	switch {
+	case exited && err != nil:
+		w.Logger.Warn(fmt.Sprintf("Watchdog: exit code of %s (pid %d) unknown: %v", w.proc.Name(), w.proc.PID(), err))
+		w.exited(nil)
+		return true
+	case err != nil:
+		w.Logger.Warn(fmt.Sprintf("Watchdog: query %s (pid %d) failed: %v", w.proc.Name(), w.proc.PID(), err))
+		return false
+	case exited:
+		w.exited(&code)
+		return true
+	}
+
+	if w.HangAfter < 0 {
+		return false
+	}
+	now := time.Now()
+	switch {
+	case w.proc.Responding():
+		if w.hung {
+			w.Logger.Info(fmt.Sprintf("Watchdog: %s responding again after %s", w.proc.Name(), now.Sub(w.hungSince).Round(time.Second)))
+		}
+		w.hungSince, w.hung = time.Time{}, false
+	case w.hungSince.IsZero():
+		w.hungSince = now
+	case !w.hung && now.Sub(w.hungSince) >= w.HangAfter:
+		w.hung = true
+		w.Logger.Warn(fmt.Sprintf("Watchdog: %s (pid %d) not responding", w.proc.Name(), w.proc.PID()))
+		w.log(models.EventTypeGameHung, models.EventLevelWarning,
+			fmt.Sprintf("%s is not responding", w.proc.Name()), 0, map[string]any{"hang_ms": now.Sub(w.hungSince).Milliseconds()})
+	}
+	return false
+}
+
+// exited records the end of the process and logs it. code is nil if the exit
+// code could not be read. w.mu must be held.
+func (w *Watchdog) exited(code *uint32) {
+	exit := &Exit{Status: StatusExited, Code: code, CrashDump: w.findDump()}
+	fields := map[string]any{}
+	value, codeText := uint32(0), "unknown"
+	if code != nil {
+		value, codeText = *code, FormatExitCode(*code)
+		fields["exit_code"] = *code
+	}
+	content := fmt.Sprintf("%s exited with code %s", w.proc.Name(), codeText)
+	switch {
+	case (code != nil && IsCrashCode(*code)) || exit.CrashDump != "":
+		exit.Status = StatusCrashed
+		content = fmt.Sprintf("%s crashed with code %s", w.proc.Name(), codeText)
+	case w.hung:
+		exit.Status = StatusHung
+		content = fmt.Sprintf("%s exited while not responding, with code %s", w.proc.Name(), codeText)
+	}
+	if exit.CrashDump != "" {
+		fields["crash_dump"] = exit.CrashDump
+		content += fmt.Sprintf(" (crash dump %s)", filepath.Base(exit.CrashDump))
+	}
+	w.exit = exit
+
+	switch exit.Status {
+	case StatusCrashed:
+		w.Logger.Error("Watchdog: " + content)
+		w.log(models.EventTypeGameCrashed, models.EventLevelError, content, value, fields)
+	case StatusHung:
+		w.Logger.Warn("Watchdog: " + content)
+		w.log(models.EventTypeGameExited, models.EventLevelWarning, content, value, fields)
+	default:
+		level := models.EventLevelLog
+		if code == nil || *code != 0 {
+			level = models.EventLevelWarning
+		}
+		w.Logger.Info("Watchdog: " + content)
+		w.log(models.EventTypeGameExited, level, content, value, fields)
+	}
+}
+
+// log logs one event about the game process. w.mu must be held.
+func (w *Watchdog) log(t models.EventType, level models.EventLevel, content string, code uint32, fields map[string]any) {
+	fields["pid"] = w.proc.PID()
+	fields["exe"] = w.proc.Name()
+	attrs, _ := json.Marshal(fields)
+	w.EventLogger.LogEvent(models.Event{
+		Timestamp:  utils.NowEpochSeconds(),
+		EventType:  t.String(),
+		EventLevel: level.String(),
+		Content:    content,
+		Value:      float64(code),
+		Attributes: string(attrs),
+		Source:     string(models.EventSourceSystem),
+	})
+}
+
+// findDump returns the newest crash dump of the game written since
+// supervision started, or "" if there is none. w.mu must be held.
+func (w *Watchdog) findDump() string {
+	var (
+		newest   string
+		newestAt time.Time
+	)
+	for _, dir := range w.DumpDirs {
+		filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() || !ownDump(d.Name(), w.proc.Name()) {
+				return nil
+			}
+			fi, err := d.Info()
+			if err != nil || fi.ModTime().Before(w.started) || fi.ModTime().Before(newestAt) {
+				return nil
+			}
+			newest, newestAt = path, fi.ModTime()
+			return nil
+		})
+	}
+	return newest
+}
+
+// ownDump reports whether the file name is a crash dump that may belong to
+// exe. Windows Error Reporting names its dumps <exe>.<pid>.dmp, so those of
+// other programs are told apart; engine crash handlers use names of their own
+// (crash.dmp, UEMinidump.dmp) in folders of their own.
+func ownDump(name, exe string) bool {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".dmp") {
+		return false
+	}
+	if strings.Contains(name, ".exe.") {
+		return strings.HasPrefix(name, strings.ToLower(exe)+".")
+	}
+	return true
+}
+
+// crashCodes names the exit codes of common crashes: the NTSTATUS code of the
+// unhandled exception that killed the process.
+var crashCodes = map[uint32]string{
+	0x80000003: "breakpoint",
+	0xC0000005: "access violation",
+	0xC000001D: "illegal instruction",
+	0xC0000094: "integer divide by zero",
+	0xC00000FD: "stack overflow",
+	0xC0000142: "DLL initialization failed",
+	0xC0000374: "heap corruption",
+	0xC0000409: "stack buffer overrun",
+	0xC0000602: "fail fast exception",
+	0xE06D7363: "unhandled C++ exception",
+}
+
+// IsCrashCode reports whether a process exit code means the process was
+// killed by an unhandled exception: a known crash code or an NTSTATUS error
+// code (0xC0000000 and above). 0xFFFFFFFF is exit(-1), not a crash.
+func IsCrashCode(code uint32) bool {
+	if _, ok := crashCodes[code]; ok {
+		return true
+	}
+	return code >= 0xC0000000 && code != 0xFFFFFFFF
+}
+
+// FormatExitCode formats an exit code for people: small codes in decimal,
+// NTSTATUS-like codes in hex with their name when known, e.g.
+// "0xC0000005 (access violation)".
+func FormatExitCode(code uint32) string {
+	if code < 0x10000 {
+		return fmt.Sprint(code)
+	}
+	s := fmt.Sprintf("0x%08X", code)
+	if name, ok := crashCodes[code]; ok {
+		s += " (" + name + ")"
+	}
+	return s
+}
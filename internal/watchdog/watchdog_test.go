@@ -0,0 +1,263 @@
+package watchdog
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"polytube/replay/internal/logger"
+	"polytube/replay/pkg/models"
+)
+
+// fakeProcess is a game process driven by the test.
+type fakeProcess struct {
+	mu         sync.Mutex
+	exited     bool
+	code       uint32
+	exitErr    error
+	responding bool
+	closed     bool
+}
+
+func newFakeProcess() *fakeProcess { return &fakeProcess{responding: true} }
+
+func (p *fakeProcess) PID() int     { return 1234 }
+func (p *fakeProcess) Name() string { return "game.exe" }
+
+func (p *fakeProcess) Exited() (uint32, bool, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.code, p.exited, p.exitErr
+}
+
+func (p *fakeProcess) Responding() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.responding
+}
+
+func (p *fakeProcess) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.closed = true
+	return nil
+}
+
+func (p *fakeProcess) exit(code uint32, err error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.exited, p.code, p.exitErr = true, code, err
+}
+
+func (p *fakeProcess) setResponding(responding bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.responding = responding
+}
+
+// eventRecorder collects the events logged by the watchdog.
+type eventRecorder struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+func (r *eventRecorder) LogEvent(e models.Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.events = append(r.events, e)
+}
+
+func (r *eventRecorder) Close() error { return nil }
+
+func (r *eventRecorder) types() []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var types []string
+	for _, e := range r.events {
+		types = append(types, e.EventType)
+	}
+	return types
+}
+
+func newWatchdog(p *fakeProcess, events *eventRecorder, dumpDirs ...string) *Watchdog {
+	return &Watchdog{
+		Find:        func() (Process, error) { return p, nil },
+		EventLogger: events,
+		Logger:      logger.DiscardLogger{},
+		HangAfter:   30 * time.Millisecond,
+		Interval:    5 * time.Millisecond,
+		DumpDirs:    dumpDirs,
+	}
+}
+
+func TestWatchdogExit(t *testing.T) {
+	tests := []struct {
+		name      string
+		code      uint32
+		exitErr   error
+		dump      string // crash dump written while the game ran
+		wantCode  bool
+		status    string
+		eventType models.EventType
+		level     models.EventLevel
+	}{
+		{name: "normal exit", code: 0, wantCode: true, status: StatusExited, eventType: models.EventTypeGameExited, level: models.EventLevelLog},
+		{name: "error exit", code: 1, wantCode: true, status: StatusExited, eventType: models.EventTypeGameExited, level: models.EventLevelWarning},
+		{name: "crash code", code: 0xC0000005, wantCode: true, status: StatusCrashed, eventType: models.EventTypeGameCrashed, level: models.EventLevelError},
+		{name: "crash dump", code: 0, dump: "game.exe.1234.dmp", wantCode: true, status: StatusCrashed, eventType: models.EventTypeGameCrashed, level: models.EventLevelError},
+		{name: "dump of another program", code: 0, dump: "other.exe.99.dmp", wantCode: true, status: StatusExited, eventType: models.EventTypeGameExited, level: models.EventLevelLog},
+		{name: "unknown exit code", exitErr: errors.New("access denied"), status: StatusExited, eventType: models.EventTypeGameExited, level: models.EventLevelWarning},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dumps := t.TempDir()
+			p := newFakeProcess()
+			events := &eventRecorder{}
+			w := newWatchdog(p, events, dumps)
+			w.Start(t.Context())
+
+			if tt.dump != "" {
+				time.Sleep(10 * time.Millisecond) // dumps older than the start are ignored
+				if err := os.WriteFile(filepath.Join(dumps, tt.dump), []byte("MDMP"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			p.exit(tt.code, tt.exitErr)
+
+			exit := w.Stop(time.Second)
+			if exit == nil {
+				t.Fatal("Stop returned nil")
+			}
+			if exit.Status != tt.status {
+				t.Errorf("status = %q, want %q", exit.Status, tt.status)
+			}
+			switch {
+			case tt.wantCode && (exit.Code == nil || *exit.Code != tt.code):
+				t.Errorf("code = %v, want %d", exit.Code, tt.code)
+			case !tt.wantCode && exit.Code != nil:
+				t.Errorf("code = %d, want unknown", *exit.Code)
+			}
+			if wantDump := tt.status == StatusCrashed && tt.dump != ""; wantDump != (exit.CrashDump != "") {
+				t.Errorf("crash dump = %q", exit.CrashDump)
+			}
+			if len(events.events) != 1 || events.events[0].EventType != tt.eventType.String() || events.events[0].EventLevel != tt.level.String() {
+				t.Errorf("events = %+v, want one %s %s", events.events, tt.level, tt.eventType)
+			}
+			if !p.closed {
+				t.Error("process handle not closed")
+			}
+		})
+	}
+}
+
+func TestWatchdogHangAndRecovery(t *testing.T) {
+	p := newFakeProcess()
+	events := &eventRecorder{}
+	w := newWatchdog(p, events)
+	w.Start(t.Context())
+
+	p.setResponding(false)
+	waitFor(t, "GAME_HUNG", func() bool { return len(events.types()) == 1 })
+	time.Sleep(50 * time.Millisecond) // still hung: logged once
+	p.setResponding(true)
+	waitFor(t, "recovery", func() bool {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		return !w.hung
+	})
+
+	if exit := w.Stop(10 * time.Millisecond); exit != nil {
+		t.Errorf("Stop = %+v, want nil for a game that recovered", exit)
+	}
+	if got := events.types(); len(got) != 1 || got[0] != models.EventTypeGameHung.String() {
+		t.Errorf("events = %v, want one GAME_HUNG", got)
+	}
+}
+
+func TestWatchdogHungAtStop(t *testing.T) {
+	p := newFakeProcess()
+	events := &eventRecorder{}
+	w := newWatchdog(p, events)
+	w.Start(t.Context())
+
+	p.setResponding(false)
+	waitFor(t, "GAME_HUNG", func() bool { return len(events.types()) == 1 })
+	if exit := w.Stop(10 * time.Millisecond); exit == nil || exit.Status != StatusHung || exit.Code != nil {
+		t.Errorf("Stop = %+v, want hung without exit code", exit)
+	}
+}
+
+func TestWatchdogStopAfterGrace(t *testing.T) {
+	p := newFakeProcess()
+	events := &eventRecorder{}
+	w := newWatchdog(p, events)
+	w.Start(t.Context())
+
+	const grace = 50 * time.Millisecond
+	start := time.Now()
+	exit := w.Stop(grace)
+	if elapsed := time.Since(start); elapsed < grace || elapsed > grace+time.Second {
+		t.Errorf("Stop took %s, want about %s", elapsed, grace)
+	}
+	if exit != nil {
+		t.Errorf("Stop = %+v, want nil for a game still running", exit)
+	}
+	if !p.closed {
+		t.Error("process handle not closed")
+	}
+	if got := events.types(); len(got) != 0 {
+		t.Errorf("events = %v, want none", got)
+	}
+}
+
+func TestWatchdogNeverFound(t *testing.T) {
+	w := &Watchdog{
+		Find:        func() (Process, error) { return nil, errors.New("no window") },
+		EventLogger: &eventRecorder{},
+		Logger:      logger.DiscardLogger{},
+		Interval:    5 * time.Millisecond,
+	}
+	w.Start(t.Context())
+	if exit := w.Stop(20 * time.Millisecond); exit != nil {
+		t.Errorf("Stop = %+v, want nil", exit)
+	}
+}
+
+func TestIsCrashCode(t *testing.T) {
+	tests := []struct {
+		code uint32
+		want bool
+		text string
+	}{
+		{0, false, "0"},
+		{1, false, "1"},
+		{0xFFFFFFFF, false, "0xFFFFFFFF"},
+		{0x80000003, true, "0x80000003 (breakpoint)"},
+		{0xC0000005, true, "0xC0000005 (access violation)"},
+		{0xC0000017, true, "0xC0000017"},
+		{0xE06D7363, true, "0xE06D7363 (unhandled C++ exception)"},
+	}
+	for _, tt := range tests {
+		if got := IsCrashCode(tt.code); got != tt.want {
+			t.Errorf("IsCrashCode(%#x) = %v, want %v", tt.code, got, tt.want)
+		}
+		if got := FormatExitCode(tt.code); got != tt.text {
+			t.Errorf("FormatExitCode(%#x) = %q, want %q", tt.code, got, tt.text)
+		}
+	}
+}
+
+// waitFor polls cond until it holds or a second passed.
+func waitFor(t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for %s", what)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,85 @@
+//go:build windows
+
+package window
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Selector picks the window to record. Every criterion that is set must
+// match; text is compared case-insensitively.
+type Selector struct {
+	Title    string         // exact title
+	Contains string         // part of the title, for titles with changing text (FPS, level name)
+	Regexp   *regexp.Regexp // matches the title
+	Class    string         // exact window class name, e.g. UnityWndClass
+	Exe      string         // executable file name of the owning process; ".exe" may be left out
+	PID      int            // owning process
+}
+
+// IsZero reports whether no criterion is set.
+func (s Selector) IsZero() bool {
+	return s.Title == "" && s.Contains == "" && s.Regexp == nil && s.Class == "" && s.Exe == "" && s.PID == 0
+}
+
+// Match reports whether w matches every criterion of s.
+func (s Selector) Match(w Window) bool {
+	switch {
+	case s.Title != "" && !strings.EqualFold(w.Title, s.Title):
+	case s.Contains != "" && !strings.Contains(strings.ToLower(w.Title), strings.ToLower(s.Contains)):
+	case s.Regexp != nil && !s.Regexp.MatchString(w.Title):
+	case s.Class != "" && !strings.EqualFold(w.Class, s.Class):
+	case s.PID != 0 && w.PID != s.PID:
+	case s.Exe != "" && !strings.EqualFold(trimExe(ExeName(w.PID)), trimExe(s.Exe)): // last: opens the process
+	default:
+		return true
+	}
+	return false
+}
+
+// Find returns the topmost window matching s.
+func (s Selector) Find() (Window, error) {
+	for _, w := range List() {
+		if s.Match(w) {
+			return w, nil
+		}
+	}
+	return Window{}, fmt.Errorf("no window with %s", s)
+}
+
+// String describes the criteria, e.g. `title containing "Level", exe "game.exe"`.
+func (s Selector) String() string {
+	var parts []string
+	if s.Title != "" {
+		parts = append(parts, fmt.Sprintf("title %q", s.Title))
+	}
+	if s.Contains != "" {
+		parts = append(parts, fmt.Sprintf("title containing %q", s.Contains))
+	}
+	if s.Regexp != nil {
+		parts = append(parts, fmt.Sprintf("title matching %q", s.Regexp))
+	}
+	if s.Class != "" {
+		parts = append(parts, fmt.Sprintf("class %q", s.Class))
+	}
+	if s.Exe != "" {
+		parts = append(parts, fmt.Sprintf("exe %q", s.Exe))
+	}
+	if s.PID != 0 {
+		parts = append(parts, fmt.Sprintf("pid %d", s.PID))
+	}
+	if len(parts) == 0 {
+		return "any title"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// trimExe drops the .exe extension, so game and game.exe compare equal.
+func trimExe(name string) string {
+	if strings.HasSuffix(strings.ToLower(name), ".exe") {
+		return name[:len(name)-len(".exe")]
+	}
+	return name
+}
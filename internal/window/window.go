@@ -0,0 +1,99 @@
+//go:build windows
+
+// Package window finds the top-level windows of other processes: the game
+// window the recorder captures and the process owning it. A Selector picks the
+// window by title, class, executable or process ID.
+package window
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/gonutz/w32/v3"
+	"golang.org/x/sys/windows"
+)
+
+// Window is a visible top-level window.
+type Window struct {
+	Handle w32.HWND
+	Title  string
+	Class  string // window class name
+	PID    int    // owning process
+}
+
+// List returns the visible top-level windows that have a title, in z-order
+// (topmost first).
+func List() []Window {
+	var list []Window
+	for _, hwnd := range topLevelWindows() {
+		if !w32.IsWindowVisible(hwnd) {
+			continue
+		}
+		title, err := w32.GetWindowText(hwnd)
+		if err != nil || title == "" {
+			continue
+		}
+		_, pid, err := w32.GetWindowThreadProcessId(hwnd)
+		if err != nil {
+			continue
+		}
+		class, _ := w32.GetClassName(hwnd)
+		list = append(list, Window{Handle: hwnd, Title: title, Class: class, PID: int(pid)})
+	}
+	return list
+}
+
+// ExeName returns the executable file name of the process pid, e.g. game.exe,
+// or "" if it can't be queried.
+func ExeName(pid int) string {
+	handle, err := windows.OpenProcess(windows.PROCESS_QUERY_LIMITED_INFORMATION, false, uint32(pid))
+	if err != nil {
+		return ""
+	}
+	defer windows.CloseHandle(handle)
+	buf := make([]uint16, windows.MAX_PATH)
+	size := uint32(len(buf))
+	if err := windows.QueryFullProcessImageName(handle, 0, &buf[0], &size); err != nil {
+		return ""
+	}
+	return filepath.Base(windows.UTF16ToString(buf[:size]))
+}
+
+// Wait calls find every interval until it finds a window or ctx is done.
+func Wait(ctx context.Context, interval time.Duration, find func() (Window, error)) (Window, error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		w, err := find()
+		if err == nil {
+			return w, nil
+		}
+		select {
+		case <-ctx.Done():
+			return Window{}, fmt.Errorf("%w: %w", context.Cause(ctx), err)
+		case <-ticker.C:
+		}
+	}
+}
+
+// Windows enumeration callbacks are a limited resource, so there is only one.
+var (
+	enumMu       sync.Mutex
+	enumFound    []w32.HWND
+	enumCallback = w32.NewEnumWindowsCallback(func(hwnd w32.HWND, _ uintptr) bool {
+		enumFound = append(enumFound, hwnd)
+		return true
+	})
+)
+
+// topLevelWindows returns every top-level window, in z-order.
+func topLevelWindows() []w32.HWND {
+	enumMu.Lock()
+	defer enumMu.Unlock()
+	enumFound = nil
+	w32.EnumWindows(enumCallback, 0)
+	return enumFound
+}
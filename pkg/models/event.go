@@ -8,6 +8,12 @@ const (
 	EventTypeInputLog EventType = iota
 	EventTypeConsoleLog
 	EventTypeRecordingStarted
+	EventTypeEventsDropped
+	EventTypeMarker
+	EventTypeBufferSaveRequested
+	EventTypeGameCrashed
+	EventTypeGameHung
+	EventTypeGameExited
 )
 
 func (e EventType) String() string {
@@ -18,6 +24,18 @@ func (e EventType) String() string {
 		return "CONSOLE_LOG"
 	case EventTypeRecordingStarted:
 		return "RECORDING_STARTED"
+	case EventTypeEventsDropped:
+		return "EVENTS_DROPPED"
+	case EventTypeMarker:
+		return "MARKER"
+	case EventTypeBufferSaveRequested:
+		return "BUFFER_SAVE_REQUESTED"
+	case EventTypeGameCrashed:
+		return "GAME_CRASHED"
+	case EventTypeGameHung:
+		return "GAME_HUNG"
+	case EventTypeGameExited:
+		return "GAME_EXITED"
 	default:
 		return "UNKNOWN"
 	}
@@ -56,10 +74,39 @@ func (e EventLevel) String() string {
 	}
 }
 
+// EventSchemaVersion is the version of the Event columns written to events.parquet.
+// It is recorded in the session manifest so readers know which columns to expect.
+//
+//	1: timestamp, eventType, eventLevel, content, value
+//	2: + attributes
+//	3: + sessionMs, sequence, source
+//
+// Columns are only ever added, never renamed, retyped or removed, so readers
+// of an older version keep working on newer files.
+const EventSchemaVersion = 3
+
+// EventSource identifies the component that produced an event.
+type EventSource string
+
+const (
+	EventSourceMNK     EventSource = "mnk"     // mouse and keyboard listener
+	EventSourceGamepad EventSource = "gamepad" // gamepad listener
+	EventSourceConsole EventSource = "console" // stdin lines
+	EventSourceIPC     EventSource = "ipc"     // local HTTP endpoint
+	EventSourceSystem  EventSource = "system"  // the recorder itself
+)
+
+// Event is one row of the event log. SessionMs and Sequence are assigned by
+// the event logger; producers fill in the rest. The JSON names match the
+// parquet column names.
 type Event struct {
-	Timestamp  float64 `parquet:"name=timestamp, type=DOUBLE"`
-	EventType  string  `parquet:"name=eventType, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	EventLevel string  `parquet:"name=eventLevel, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Content    string  `parquet:"name=content, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY"`
-	Value      float64 `parquet:"name=value, type=DOUBLE"`
+	Timestamp  float64 `parquet:"name=timestamp, type=DOUBLE" json:"timestamp"`
+	EventType  string  `parquet:"name=eventType, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"eventType"`
+	EventLevel string  `parquet:"name=eventLevel, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"eventLevel"`
+	Content    string  `parquet:"name=content, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"content"`
+	Value      float64 `parquet:"name=value, type=DOUBLE" json:"value"`
+	Attributes string  `parquet:"name=attributes, type=BYTE_ARRAY, convertedtype=UTF8" json:"attributes"` // JSON object of custom fields, "" if none
+	SessionMs  int64   `parquet:"name=sessionMs, type=INT64" json:"sessionMs"`                            // ms since RECORDING_STARTED; negative before it
+	Sequence   int64   `parquet:"name=sequence, type=INT64" json:"sequence"`                              // arrival order from 1; gaps are dropped events
+	Source     string  `parquet:"name=source, type=BYTE_ARRAY, convertedtype=UTF8, encoding=PLAIN_DICTIONARY" json:"source"`
 }
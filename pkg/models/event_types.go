@@ -0,0 +1,93 @@
+package models
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// Custom event types
+//
+// Games may send any event type. Registering custom types (--event-types)
+// documents them in the session manifest and makes the type list closed:
+// once at least one custom type is registered, events of types that are
+// neither built in nor registered are rejected.
+
+var (
+	eventTypeName = regexp.MustCompile(`^[A-Z][A-Z0-9_]*$`)
+
+	customTypesMu sync.RWMutex
+	customTypes   = make(map[string]bool)
+)
+
+// BuiltinEventTypes lists the event types produced by the recorder itself.
+func BuiltinEventTypes() []string {
+	return []string{
+		EventTypeInputLog.String(),
+		EventTypeConsoleLog.String(),
+		EventTypeRecordingStarted.String(),
+		EventTypeEventsDropped.String(),
+		EventTypeMarker.String(),
+		EventTypeBufferSaveRequested.String(),
+		EventTypeGameCrashed.String(),
+		EventTypeGameHung.String(),
+		EventTypeGameExited.String(),
+	}
+}
+
+// IsBuiltinEventType reports whether name is produced by the recorder itself.
+func IsBuiltinEventType(name string) bool {
+	for _, t := range BuiltinEventTypes() {
+		if t == name {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateEventTypeName checks that name can be registered as a custom type.
+func ValidateEventTypeName(name string) error {
+	if !eventTypeName.MatchString(name) {
+		return fmt.Errorf("event type %q must be UPPER_SNAKE_CASE", name)
+	}
+	if IsBuiltinEventType(name) {
+		return fmt.Errorf("event type %s is built in", name)
+	}
+	return nil
+}
+
+// RegisterEventType registers a custom event type. Names are UPPER_SNAKE_CASE.
+func RegisterEventType(name string) error {
+	if err := ValidateEventTypeName(name); err != nil {
+		return err
+	}
+	customTypesMu.Lock()
+	defer customTypesMu.Unlock()
+	customTypes[name] = true
+	return nil
+}
+
+// CustomEventTypes returns the registered custom event types, sorted.
+func CustomEventTypes() []string {
+	customTypesMu.RLock()
+	defer customTypesMu.RUnlock()
+	types := make([]string, 0, len(customTypes))
+	for t := range customTypes {
+		types = append(types, t)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// IsAllowedEventType reports whether events of type name are accepted: every
+// type is while no custom type is registered, otherwise only built-in and
+// registered ones.
+func IsAllowedEventType(name string) bool {
+	if IsBuiltinEventType(name) {
+		return true
+	}
+	customTypesMu.RLock()
+	defer customTypesMu.RUnlock()
+	return len(customTypes) == 0 || customTypes[name]
+}
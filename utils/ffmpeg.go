@@ -0,0 +1,10 @@
+package utils
+
+import "strings"
+
+// EscapeFilterValue escapes a filter option value for an FFmpeg filter graph:
+// first for the option parser (\ ' :), then for the graph parser (\ ' [ ] , ;).
+func EscapeFilterValue(s string) string {
+	s = strings.NewReplacer(`\`, `\\`, `'`, `\'`, `:`, `\:`).Replace(s)
+	return strings.NewReplacer(`\`, `\\`, `'`, `\'`, `[`, `\[`, `]`, `\]`, `,`, `\,`, `;`, `\;`).Replace(s)
+}
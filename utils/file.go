@@ -1,6 +1,9 @@
 package utils
 
-import "os"
+import (
+	"io"
+	"os"
+)
 
 func GetFileSizeMB(path string) (float64, error) {
 	info, err := os.Stat(path)
@@ -19,3 +22,21 @@ func GetFileContentLength(path string) (int64, error) {
 	}
 	return info.Size(), nil
 }
+
+// CopyFile copies src to dst, replacing dst.
+func CopyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}